@@ -1,8 +1,14 @@
 package api
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,11 +24,39 @@ import (
 // devicse:
 //   GET  /devices: lookup devices
 // pcap:
+//   GET    /pcap:  list capturing sessions
 //   POST   /pcap:  start a capturing
 //   DELETE /pcap:  stop a capturing
 //   WS     /pcap/{sessionID}: get packets
+//   PATCH  /pcap/{sessionID}: change the filter of a capturing
+//   GET    /pcap/{sessionID}/info: get the session info
+//   GET    /pcap/{sessionID}/stats: get the capture statistics
+//   POST   /pcap/{sessionID}/pause: pause a capturing
+//   POST   /pcap/{sessionID}/resume: resume a paused capturing
+//   GET    /pcap/{sessionID}/conversations: get the conversations
+//   GET    /pcap/{sessionID}/endpoints: get the endpoints
+//   GET    /pcap/{sessionID}/protocols: get the protocol hierarchy
+//   GET    /pcap/{sessionID}/streams: list the TCP streams
+//   GET    /pcap/{sessionID}/streams/{id}: follow a TCP stream
+// bpf:
+//   POST   /bpf/compile: validate & compile a BPF filter
 //
 
+// Config configures the http api.
+type Config struct {
+	// OutputDir is the directory (on the server) of the output_file
+	// of POST /pcap, which must be a relative path in it.
+	// Empty disables the output files.
+	OutputDir string
+	// CaptureDir is the directory (on the server) of the saved captures
+	// to read by the file of POST /pcap, which must be a relative path
+	// in it. Empty disables reading files.
+	CaptureDir string
+}
+
+// config of the registered http api
+var config Config
+
 // wssessions holds sessions' ws output handler
 var wssessions sync.Map // map[SessionID]websocket.Handler
 
@@ -60,20 +94,71 @@ func getDevices(req GetDevicesRequest) (GetDevicesResponse, error) {
 }
 
 type StartPcapRequest struct {
-	Device  string        `json:"device"`
-	Filter  string        `json:"filter"`
-	Snaplen int           `json:"snaplen"`
-	Promisc bool          `json:"promisc"`
-	Timeout time.Duration `json:"timeout"`
-	Format  string        `json:"format"`
-	Output  string        `json:"output"`
+	Device  goners.Devices `json:"device"` // "eth0", "eth0,tun0", ["eth0", "tun0"] or "any"
+	Filter  string         `json:"filter"`
+	Snaplen int            `json:"snaplen"`
+	Promisc bool           `json:"promisc"`
+	Timeout time.Duration  `json:"timeout"`
+
+	// DisplayFilter is a Wireshark style display filter
+	// (e.g. "tcp.dstport == 443"), applied after decoding.
+	DisplayFilter string `json:"display_filter"`
+
+	// FastPath decodes only Ethernet/IPv4/IPv6/TCP/UDP/ICMP layers,
+	// for busy links.
+	FastPath bool `json:"fast_path"`
+
+	// Defrag reassembles IPv4/IPv6 fragments before decoding.
+	Defrag bool `json:"defrag"`
+
+	// TrackStreams reassembles TCP streams for
+	// GET /pcap/{sessionID}/streams.
+	TrackStreams bool `json:"track_streams"`
+
+	// TrackFlows counts the conversations & endpoints for
+	// GET /pcap/{sessionID}/conversations and /endpoints.
+	TrackFlows bool `json:"track_flows"`
+
+	// TrackProtocols counts the protocol hierarchy for
+	// GET /pcap/{sessionID}/protocols.
+	TrackProtocols bool `json:"track_protocols"`
+
+	// File reads packets from a saved pcap/pcapng file (on the server)
+	// instead of capturing live packets from the Device: a relative path
+	// in the Config.CaptureDir.
+	File string `json:"file"`
+
+	// stop conditions: count, duration, max_bytes
+	goners.StopConditions
+
+	Format string `json:"format"` // text | summary | tcpdump | template | fields | json
+	Output string `json:"output"` // ws | pcap | pcapng
+
+	// Template is the Go text/template of each packet
+	// when Format is template (see goners.NewTemplatePacketsFormater).
+	Template string `json:"template"`
+	// Fields are the fields of the CSV (or TSV) rows when Format is
+	// fields (see goners.NewFieldsPacketsFormater). The first message
+	// to each WebSocket client is the header.
+	Fields goners.FieldsOptions `json:"fields"`
+	// Json selects the profile (summary | fields | full) of the JSON,
+	// and omits or truncates the payloads, when Format is json
+	// (see goners.NewJsonProfilePacketsFormater).
+	Json goners.JsonOptions `json:"json"`
+
+	// OutputFile is the file (on the server) to write
+	// when Output is pcap or pcapng: a relative path in
+	// the Config.OutputDir.
+	OutputFile string `json:"output_file"`
+	// Rotate configures the ring buffer of OutputFile.
+	Rotate goners.RotateOptions `json:"rotate"`
 }
 
 func newDefaultStartPcapRequest() *StartPcapRequest {
 	return &StartPcapRequest{
-		Device:  "",
+		Device:  nil,
 		Filter:  "",
-		Snaplen: 262144,
+		Snaplen: goners.DefaultSnaplen,
 		Promisc: false,
 		Timeout: goners.BlockForever,
 		Format:  "json",
@@ -95,11 +180,86 @@ func StartPcap(c *gin.Context) {
 		return
 	}
 
+	if req.DisplayFilter != "" {
+		if _, err := goners.CompileDisplayFilter(req.DisplayFilter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.Format == "template" {
+		if _, err := goners.ParsePacketTemplate(req.Template); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if req.Format == "fields" {
+		if _, err := goners.NewFieldsPacketsFormater(req.Fields); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := req.Json.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if req.File != "" {
+		file, err := resolvePath(config.CaptureDir, req.File)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("bad file: %v", err),
+			})
+			return
+		}
+		req.File = file
+
+		// the link type of a file is known before the capturing:
+		// check the filter for it before creating any output
+		if req.Filter != "" {
+			source, err := goners.OpenPacketSource(goners.FileSource{Path: req.File}, req.Filter)
+			if errors.Is(err, goners.ErrBadFilter) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			if err == nil {
+				source.Close()
+			}
+		}
+	}
+
+	if req.OutputFile != "" {
+		file, err := resolvePath(config.OutputDir, req.OutputFile)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("bad output_file: %v", err),
+			})
+			return
+		}
+		req.OutputFile = file
+	}
+
 	resp, err := startPcap(req)
 
 	if err != nil {
 		slog.Warn("startPcap failed.", "err", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
+		status := http.StatusInternalServerError
+		if errors.Is(err, goners.ErrBadFilter) { // for the link type of the source
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -107,6 +267,24 @@ func StartPcap(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// resolvePath resolves the name of a file (on the server) from a request
+// in the dir. Absolute paths and ".." are rejected: clients can't reach
+// the files out of the dir.
+func resolvePath(dir, name string) (string, error) {
+	if dir == "" {
+		return "", errors.New("files on the server are disabled")
+	}
+	if !filepath.IsLocal(name) {
+		return "", errors.New("want a relative path in the directory")
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(name), "/") {
+		if elem == ".." {
+			return "", errors.New("\"..\" is not allowed")
+		}
+	}
+	return filepath.Join(dir, name), nil
+}
+
 func startPcap(req *StartPcapRequest) (StartPcapResponse, error) {
 	config := goners.PcapSessionConfig{
 		Device:  req.Device,
@@ -114,31 +292,89 @@ func startPcap(req *StartPcapRequest) (StartPcapResponse, error) {
 		Snaplen: req.Snaplen,
 		Promisc: req.Promisc,
 		Timeout: req.Timeout,
+
+		DisplayFilter: req.DisplayFilter,
+		FastPath:      req.FastPath,
+		Defrag:        req.Defrag,
+		TrackStreams:  req.TrackStreams,
+
+		TrackFlows:     req.TrackFlows,
+		TrackProtocols: req.TrackProtocols,
+
+		StopConditions: req.StopConditions,
+	}
+	if req.File != "" {
+		config.Source = goners.FileSource{Path: req.File}
 	}
 
 	var formater goners.PacketsFormater
 	switch req.Format {
 	case "text":
 		formater = goners.StringPacketsFormater
+	case "summary":
+		formater = goners.SummaryPacketsFormater
+	case "tcpdump":
+		formater = goners.TcpdumpPacketsFormater
+	case "template":
+		var err error
+		if formater, err = goners.NewTemplatePacketsFormater(req.Template); err != nil {
+			return StartPcapResponse{}, err
+		}
+	case "fields":
+		var err error
+		if formater, err = goners.NewFieldsPacketsFormater(req.Fields); err != nil {
+			return StartPcapResponse{}, err
+		}
 	case "json":
-		formater = goners.JsonPacketsFormater
+		var err error
+		if formater, err = goners.NewJsonProfilePacketsFormater(req.Json); err != nil {
+			return StartPcapResponse{}, err
+		}
 	}
 	config.Format = formater
 
-	// TODO: outputer choice
-	out, ws := goners.NewWebSocketOutputer()
-	config.Output = out
+	var ws websocket.Handler
+	switch req.Output {
+	case "ws":
+		config.Output, ws = goners.NewWebSocketOutputer()
+	case "pcap", "pcapng":
+		if req.OutputFile == "" {
+			return StartPcapResponse{}, fmt.Errorf("output_file is required for %v output", req.Output)
+		}
+		out, err := goners.NewRotatingPcapOutputer(req.OutputFile, goners.PcapFileFormat(req.Output), req.Snaplen, req.Rotate)
+		if err != nil {
+			return StartPcapResponse{}, err
+		}
+		config.PacketsOutput = out
+	default:
+		return StartPcapResponse{}, fmt.Errorf("unknown output: %v", req.Output)
+	}
 
 	sessionID, err := goners.GetPcapSessionsManager().StartSession(&config)
 	if err != nil {
+		if c, ok := config.PacketsOutput.(io.Closer); ok {
+			c.Close()
+		}
 		return StartPcapResponse{}, err
 	}
 
-	wssessions.Store(sessionID, ws)
+	if ws != nil {
+		wssessions.Store(sessionID, ws)
+		go deleteWsSessionOnDone(sessionID)
+	}
 
 	return StartPcapResponse{SessionID: sessionID}, nil
 }
 
+// deleteWsSessionOnDone deletes the ws output handler of the session
+// after it ends, by itself or by DELETE /pcap.
+func deleteWsSessionOnDone(sessionID goners.SessionID) {
+	if done, err := goners.GetPcapSessionsManager().SessionDone(sessionID); err == nil {
+		<-done
+	}
+	wssessions.Delete(sessionID)
+}
+
 type StopPcapRequest struct {
 	SessionID goners.SessionID `json:"session_id"`
 }
@@ -174,6 +410,117 @@ func stopPcap(req StopPcapRequest) (StopPcapResponse, error) {
 	return StopPcapResponse{DeletedSessionID: req.SessionID}, err
 }
 
+type ListPcapResponse []goners.SessionInfo
+
+// GET /pcap
+func ListPcap(c *gin.Context) {
+	c.JSON(http.StatusOK, ListPcapResponse(goners.GetPcapSessionsManager().ListSessions()))
+}
+
+type GetPcapInfoResponse goners.SessionInfo
+
+// GET /pcap/{sessionID}/info
+func GetPcapInfo(c *gin.Context) {
+	sessionID := goners.SessionID(c.Param("sessionID"))
+	info, err := goners.GetPcapSessionsManager().GetSession(sessionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, GetPcapInfoResponse(info))
+}
+
+type UpdatePcapRequest struct {
+	Filter string `json:"filter"`
+}
+
+type UpdatePcapResponse goners.SessionInfo
+
+// PATCH /pcap/{sessionID}
+func UpdatePcap(c *gin.Context) {
+	sessionID := goners.SessionID(c.Param("sessionID"))
+
+	req := UpdatePcapRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	m := goners.GetPcapSessionsManager()
+	if err := m.UpdateSession(sessionID, req.Filter); err != nil {
+		status := http.StatusBadRequest // bad filter
+		if errors.Is(err, goners.ErrSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	info, err := m.GetSession(sessionID)
+	if err != nil { // ended just now
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, UpdatePcapResponse(info))
+}
+
+type PausePcapResponse goners.SessionInfo
+
+// POST /pcap/{sessionID}/pause
+func PausePcap(c *gin.Context) {
+	setPcapPaused(c, goners.GetPcapSessionsManager().PauseSession)
+}
+
+// POST /pcap/{sessionID}/resume
+func ResumePcap(c *gin.Context) {
+	setPcapPaused(c, goners.GetPcapSessionsManager().ResumeSession)
+}
+
+// setPcapPaused pauses or resumes the session by the given method
+// of the PcapSessionsManager, and responses the session info.
+func setPcapPaused(c *gin.Context, set func(goners.SessionID) error) {
+	sessionID := goners.SessionID(c.Param("sessionID"))
+
+	if err := set(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	info, err := goners.GetPcapSessionsManager().GetSession(sessionID)
+	if err != nil { // ended just now
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, PausePcapResponse(info))
+}
+
+type GetPcapStatsResponse goners.CaptureStats
+
+// GET /pcap/{sessionID}/stats
+func GetPcapStats(c *gin.Context) {
+	sessionID := goners.SessionID(c.Param("sessionID"))
+	stats, err := goners.GetPcapSessionsManager().SessionStats(sessionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, GetPcapStatsResponse(stats))
+}
+
 // WS /pcap/{sessionID}
 func WsPcap(c *gin.Context) {
 	sessionID := goners.SessionID(c.Param("sessionID"))
@@ -199,18 +546,215 @@ func WsPcap(c *gin.Context) {
 	wsHandler.ServeHTTP(c.Writer, c.Request)
 }
 
+type GetPcapConversationsResponse []goners.Conversation
+
+// GET /pcap/{sessionID}/conversations?type=tcp
+//
+// Gets the conversations of the layer type (all by default),
+// the most bytes first.
+func GetPcapConversations(c *gin.Context) {
+	sessionID := goners.SessionID(c.Param("sessionID"))
+	flows, err := goners.GetPcapSessionsManager().SessionFlows(sessionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, GetPcapConversationsResponse(flows.Conversations(c.Query("type"))))
+}
+
+type GetPcapEndpointsResponse []goners.Endpoint
+
+// GET /pcap/{sessionID}/endpoints?type=ip
+//
+// Gets the endpoints of the layer type (all by default),
+// the most bytes first.
+func GetPcapEndpoints(c *gin.Context) {
+	sessionID := goners.SessionID(c.Param("sessionID"))
+	flows, err := goners.GetPcapSessionsManager().SessionFlows(sessionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, GetPcapEndpointsResponse(flows.Endpoints(c.Query("type"))))
+}
+
+type GetPcapProtocolsResponse *goners.ProtocolNode
+
+// GET /pcap/{sessionID}/protocols
+//
+// Gets the protocol hierarchy of a session.
+func GetPcapProtocols(c *gin.Context) {
+	sessionID := goners.SessionID(c.Param("sessionID"))
+	protocols, err := goners.GetPcapSessionsManager().SessionProtocols(sessionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, GetPcapProtocolsResponse(protocols.Hierarchy()))
+}
+
+type ListPcapStreamsResponse []goners.TCPStream
+
+// GET /pcap/{sessionID}/streams
+//
+// Lists the TCP streams (without data) of a session started
+// with track_streams.
+func ListPcapStreams(c *gin.Context) {
+	streams, err := pcapStreams(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ListPcapStreamsResponse(streams.Streams()))
+}
+
+type GetPcapStreamResponse goners.TCPStream
+
+// GET /pcap/{sessionID}/streams/{id}?mode=ascii|hex|raw
+//
+// Follows the TCP stream of the index id. The stream is a JSON
+// (data in base64) by default, or text in the mode.
+func GetPcapStream(c *gin.Context) {
+	index, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("bad stream id: %v", c.Param("id")),
+		})
+		return
+	}
+	var mode goners.FollowMode
+	if m := c.Query("mode"); m != "" {
+		if mode, err = goners.ParseFollowMode(m); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	streams, err := pcapStreams(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	stream, err := streams.Stream(index)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if mode == "" {
+		c.JSON(http.StatusOK, GetPcapStreamResponse(stream))
+		return
+	}
+	var buf bytes.Buffer
+	goners.WriteFollow(&buf, stream, mode)
+	contentType := "text/plain; charset=utf-8"
+	if mode == goners.FollowRaw {
+		contentType = "application/octet-stream"
+	}
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// pcapStreams returns the StreamTracker of the session in the path.
+func pcapStreams(c *gin.Context) (*goners.StreamTracker, error) {
+	sessionID := goners.SessionID(c.Param("sessionID"))
+	return goners.GetPcapSessionsManager().SessionStreams(sessionID)
+}
+
+type CompileBPFRequest struct {
+	Filter   string `json:"filter"`
+	LinkType string `json:"link_type"` // e.g. "ethernet" (default), "raw", "linux_sll" or 1
+	Snaplen  int    `json:"snaplen"`
+}
+
+type CompileBPFResponse struct {
+	*goners.BPFProgram
+	Text []string `json:"text"` // disassembled instructions, like tcpdump -d
+}
+
+// POST /bpf/compile
+//
+// Compiles the filter without opening a device. A bad filter is 400,
+// with the error reported by libpcap.
+func CompileBPF(c *gin.Context) {
+	req := CompileBPFRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	resp, err := compileBPF(req)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func compileBPF(req CompileBPFRequest) (CompileBPFResponse, error) {
+	linkType, err := goners.ParseLinkType(req.LinkType)
+	if err != nil {
+		return CompileBPFResponse{}, err
+	}
+	program, err := goners.CompileBPF(req.Filter, linkType, req.Snaplen)
+	if err != nil {
+		return CompileBPFResponse{}, err
+	}
+
+	resp := CompileBPFResponse{
+		BPFProgram: program,
+		Text:       make([]string, 0, len(program.Instructions)),
+	}
+	for i, insn := range program.Instructions {
+		resp.Text = append(resp.Text, insn.Disassemble(i))
+	}
+	return resp, nil
+}
+
 // register http api
-func RegisterHttpApi(r *gin.Engine) {
+func RegisterHttpApi(r *gin.Engine, c Config) {
+	config = c
+
 	r.GET("/devices", GetDevices)
+	r.GET("/pcap", ListPcap)
 	r.POST("/pcap", StartPcap)
 	r.DELETE("/pcap", StopPcap)
-	r.Any("/pcap/:sessionID", WsPcap)
+	r.GET("/pcap/:sessionID", WsPcap)
+	r.PATCH("/pcap/:sessionID", UpdatePcap)
+	r.GET("/pcap/:sessionID/info", GetPcapInfo)
+	r.GET("/pcap/:sessionID/stats", GetPcapStats)
+	r.POST("/pcap/:sessionID/pause", PausePcap)
+	r.POST("/pcap/:sessionID/resume", ResumePcap)
+	r.GET("/pcap/:sessionID/conversations", GetPcapConversations)
+	r.GET("/pcap/:sessionID/endpoints", GetPcapEndpoints)
+	r.GET("/pcap/:sessionID/protocols", GetPcapProtocols)
+	r.GET("/pcap/:sessionID/streams", ListPcapStreams)
+	r.GET("/pcap/:sessionID/streams/:id", GetPcapStream)
+	r.POST("/bpf/compile", CompileBPF)
 }
 
 // router
-func NewHttp() *gin.Engine {
+func NewHttp(c Config) *gin.Engine {
 	r := gin.Default()
 	r.Use(cors.Default()) // cors: allow all
-	RegisterHttpApi(r)
+	RegisterHttpApi(r, c)
 	return r
 }
@@ -1,3 +1,477 @@
 package api
 
-// TODO: http tests
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdfmlr/goners"
+	"github.com/gin-gonic/gin"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// writeTestPcapFile writes n crafted UDP packets into a pcap file.
+func writeTestPcapFile(t *testing.T, file string, n int) {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	udp := &layers.UDP{SrcPort: 40000, DstPort: 9999}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	packets := make([][]gopacket.SerializableLayer, n)
+	for i := range packets {
+		packets[i] = []gopacket.SerializableLayer{ip, udp, gopacket.Payload("hello")}
+	}
+	writeTestPackets(t, file, packets)
+}
+
+// writeTestUdpPcapFile writes a UDP packet from each of the sources
+// (e.g. "10.0.0.1:40000") to the destination ports into a pcap file.
+func writeTestUdpPcapFile(t *testing.T, file string, srcs []string, dstPorts []int) {
+	var packets [][]gopacket.SerializableLayer
+	for i, src := range srcs {
+		host, port, err := net.SplitHostPort(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srcPort, _ := strconv.Atoi(port)
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP,
+			SrcIP: net.ParseIP(host).To4(), DstIP: net.IP{10, 0, 0, 2}}
+		udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPorts[i])}
+		udp.SetNetworkLayerForChecksum(ip)
+		packets = append(packets, []gopacket.SerializableLayer{ip, udp, gopacket.Payload("hello")})
+	}
+	writeTestPackets(t, file, packets)
+}
+
+// writeTestTcpPcapFile writes a TCP stream of the payloads (sent from
+// 10.0.0.1:40000 to 10.0.0.2:80) into a pcap file.
+func writeTestTcpPcapFile(t *testing.T, file string, payloads ...string) {
+	var packets [][]gopacket.SerializableLayer
+	seq := uint32(100)
+	for _, payload := range payloads {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+			SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+		tcp := &layers.TCP{SrcPort: 40000, DstPort: 80, Seq: seq, PSH: true, ACK: true, Window: 1024}
+		tcp.SetNetworkLayerForChecksum(ip)
+		packets = append(packets, []gopacket.SerializableLayer{ip, tcp, gopacket.Payload(payload)})
+		seq += uint32(len(payload))
+	}
+	writeTestPackets(t, file, packets)
+}
+
+// writeTestLinkTypeFile writes an empty pcap file of the link type.
+func writeTestLinkTypeFile(t *testing.T, file string, linkType layers.LinkType) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := pcapgo.NewWriter(f).WriteFileHeader(65536, linkType); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestPackets writes the packets of the layers (above Ethernet)
+// into a pcap file.
+func writeTestPackets(t *testing.T, file string, packets [][]gopacket.SerializableLayer) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01},
+		DstMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+
+	for _, ls := range packets {
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, append([]gopacket.SerializableLayer{eth}, ls...)...); err != nil {
+			t.Fatal(err)
+		}
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(buf.Bytes()), Length: len(buf.Bytes())}
+		if err := w.WritePacket(ci, buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// doRequest serves a JSON request with the goners http api.
+func doRequest(t *testing.T, r *gin.Engine, method, url string, body any) *httptest.ResponseRecorder {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, url, &reqBody)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// waitSessionDone waits for the session to end, e.g. at EOF.
+func waitSessionDone(t *testing.T, id goners.SessionID) {
+	t.Helper()
+	done, err := goners.GetPcapSessionsManager().SessionDone(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("❌ session %v is not done", id)
+	}
+}
+
+func newTestHttp(config Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	RegisterHttpApi(r, config)
+	return r
+}
+
+func TestStartPcap(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	writeTestPcapFile(t, path.Join(tmpdir, "src.pcap"), 3)
+	src := "src.pcap" // in the CaptureDir
+	writeTestLinkTypeFile(t, path.Join(tmpdir, "wlan.pcap"), layers.LinkTypeIEEE802_11)
+	// 2 of the 4 packets match the displayFilter case
+	writeTestUdpPcapFile(t, path.Join(tmpdir, "mixed.pcap"),
+		[]string{"10.0.0.1:40000", "10.0.0.1:40000", "192.168.0.1:40000", "10.0.0.3:40000"},
+		[]int{9999, 53, 9999, 9999})
+
+	r := newTestHttp(Config{OutputDir: tmpdir, CaptureDir: tmpdir})
+
+	tests := []struct {
+		name       string
+		body       gin.H
+		wantStatus int
+	}{
+		{"filePcap", gin.H{"file": src, "output": "pcap", "output_file": "out.pcap", "snaplen": 1500}, http.StatusOK},
+		{"filePcapng", gin.H{"file": src, "output": "pcapng", "output_file": "out.pcapng", "count": 2}, http.StatusOK},
+		{"absOutputFile", gin.H{"file": src, "output": "pcap", "output_file": "/etc/cron.d/goners"}, http.StatusBadRequest},
+		{"dotdotOutputFile", gin.H{"file": src, "output": "pcap", "output_file": "../out.pcap"}, http.StatusBadRequest},
+		{"innerDotdotOutputFile", gin.H{"file": src, "output": "pcap", "output_file": "a/../../out.pcap"}, http.StatusBadRequest},
+		{"fileWs", gin.H{"file": src}, http.StatusOK},
+		{"noOutputFile", gin.H{"file": src, "output": "pcap"}, http.StatusInternalServerError},
+		{"badOutput", gin.H{"file": src, "output": "carrier-pigeon"}, http.StatusInternalServerError},
+		{"noFile", gin.H{"file": "noexists.pcap"}, http.StatusInternalServerError},
+		{"absFile", gin.H{"file": "/etc/shadow"}, http.StatusBadRequest},
+		{"dotdotFile", gin.H{"file": "../" + path.Base(tmpdir) + "/src.pcap"}, http.StatusBadRequest},
+		{"displayFilter", gin.H{"file": "mixed.pcap", "output": "pcap", "output_file": "filtered.pcap", "display_filter": "udp.dstport == 9999 && ip.src in 10.0.0.0/8"}, http.StatusOK},
+		{"badDisplayFilter", gin.H{"file": src, "display_filter": "udp.dstport =="}, http.StatusBadRequest},
+		{"badFilter", gin.H{"file": src, "filter": "udp dst port"}, http.StatusBadRequest},
+		{"linkTypeFilter", gin.H{"file": "wlan.pcap", "filter": "wlan type mgt"}, http.StatusOK},
+		{"badLinkTypeFilter", gin.H{"file": src, "filter": "wlan type mgt", "output": "pcap", "output_file": "bad.pcap"}, http.StatusBadRequest},
+		{"template", gin.H{"file": src, "format": "template", "template": "{{flow .}} {{field \"udp.dstport\" .}}"}, http.StatusOK},
+		{"badTemplate", gin.H{"file": src, "format": "template", "template": "{{flow ."}, http.StatusBadRequest},
+		{"fields", gin.H{"file": src, "format": "fields", "fields": gin.H{"fields": []string{"frame.time", "ip.src", "udp.dstport"}, "tsv": true}}, http.StatusOK},
+		{"badFields", gin.H{"file": src, "format": "fields", "fields": gin.H{"fields": []string{"udp.dstport =="}}}, http.StatusBadRequest},
+		{"unknownField", gin.H{"file": src, "format": "fields", "fields": gin.H{"fields": []string{"ip.src", "nope.x"}}}, http.StatusBadRequest},
+		{"noFields", gin.H{"file": src, "format": "fields"}, http.StatusBadRequest},
+		{"jsonProfile", gin.H{"file": src, "format": "json", "json": gin.H{"profile": "summary"}}, http.StatusOK},
+		{"jsonPayload", gin.H{"file": src, "json": gin.H{"profile": "fields", "max_payload": 16}}, http.StatusOK},
+		{"badJsonProfile", gin.H{"file": src, "json": gin.H{"profile": "verbose"}}, http.StatusBadRequest},
+	}
+	ids := make(map[string]goners.SessionID)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, r, http.MethodPost, "/pcap", tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("❌ POST /pcap: status = %v, want %v. body: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp StartPcapResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.SessionID == "" {
+				t.Errorf("❌ empty session_id")
+			}
+			ids[tt.name] = resp.SessionID
+		})
+	}
+
+	for _, id := range ids {
+		waitSessionDone(t, id) // sessions end at EOF
+	}
+	// deleted right after the session is done
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, ok := wssessions.Load(ids["fileWs"]); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("❌ the ws handler of the ended session is not deleted")
+			break
+		}
+	}
+	if _, err := os.Stat(path.Join(tmpdir, "bad.pcap")); !os.IsNotExist(err) {
+		t.Errorf("❌ the output file of a bad filter is created: %v", err)
+	}
+	for file, wantCount := range map[string]int{"out.pcap": 3, "out.pcapng": 2, "filtered.pcap": 2} {
+		packets, err := goners.CaptureFilePackets(context.Background(), path.Join(tmpdir, file), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for range packets {
+			count++
+		}
+		if count != wantCount {
+			t.Errorf("❌ %v: got %v packets, want %v", file, count, wantCount)
+		}
+	}
+
+	f, err := os.Open(path.Join(tmpdir, "out.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pr, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Snaplen() != 1500 {
+		t.Errorf("❌ out.pcap: snaplen = %v, want the session's 1500", pr.Snaplen())
+	}
+
+	// no OutputDir: no output files
+	r = newTestHttp(Config{CaptureDir: tmpdir})
+	w := doRequest(t, r, http.MethodPost, "/pcap", gin.H{"file": src, "output": "pcap", "output_file": "out.pcap"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("❌ POST /pcap without OutputDir: status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+	// no CaptureDir: no files to read
+	r = newTestHttp(Config{OutputDir: tmpdir})
+	w = doRequest(t, r, http.MethodPost, "/pcap", gin.H{"file": src})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("❌ POST /pcap without CaptureDir: status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestStopPcap(t *testing.T) {
+	r := newTestHttp(Config{})
+
+	w := doRequest(t, r, http.MethodDelete, "/pcap", StopPcapRequest{SessionID: "noexists"})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("❌ DELETE /pcap noexists: status = %v, want %v", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestListPcap(t *testing.T) {
+	r := newTestHttp(Config{})
+
+	w := doRequest(t, r, http.MethodGet, "/pcap", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("❌ GET /pcap: status = %v, want %v", w.Code, http.StatusOK)
+	}
+	var resp ListPcapResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("❌ GET /pcap: bad response %s: %v", w.Body, err)
+	}
+
+	w = doRequest(t, r, http.MethodGet, "/pcap/noexists/info", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("❌ GET /pcap/noexists/info: status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestGetPcapStats(t *testing.T) {
+	r := newTestHttp(Config{})
+
+	w := doRequest(t, r, http.MethodGet, "/pcap/noexists/stats", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("❌ GET /pcap/noexists/stats: status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestUpdatePcap(t *testing.T) {
+	r := newTestHttp(Config{})
+
+	w := doRequest(t, r, http.MethodPatch, "/pcap/noexists", UpdatePcapRequest{Filter: "tcp"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("❌ PATCH /pcap/noexists: status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestPausePcap(t *testing.T) {
+	r := newTestHttp(Config{})
+
+	for _, action := range []string{"pause", "resume"} {
+		w := doRequest(t, r, http.MethodPost, "/pcap/noexists/"+action, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("❌ POST /pcap/noexists/%v: status = %v, want %v", action, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestCompileBPF(t *testing.T) {
+	r := newTestHttp(Config{})
+
+	tests := []struct {
+		name       string
+		body       gin.H
+		wantStatus int
+	}{
+		{"badFilter", gin.H{"filter": "tcp port"}, http.StatusBadRequest},
+		{"badLinkType", gin.H{"filter": "tcp", "link_type": "carrier-pigeon"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(t, r, http.MethodPost, "/bpf/compile", tt.body)
+			if w.Code != tt.wantStatus {
+				t.Errorf("❌ POST /bpf/compile: status = %v, want %v. body: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestGetPcapStream(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	writeTestTcpPcapFile(t, path.Join(tmpdir, "http.pcap"), "GET / HTTP/1.1\r\n", "\r\n")
+
+	r := newTestHttp(Config{OutputDir: tmpdir, CaptureDir: tmpdir})
+
+	w := doRequest(t, r, http.MethodPost, "/pcap", gin.H{
+		"file": "http.pcap", "output": "pcap", "output_file": "out.pcap", "track_streams": true})
+	if w.Code != http.StatusOK {
+		t.Fatalf("❌ POST /pcap: status = %v: %s", w.Code, w.Body)
+	}
+	var resp StartPcapResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	waitSessionDone(t, resp.SessionID) // the session ends at EOF, its streams are kept
+
+	w = doRequest(t, r, http.MethodGet, "/pcap/"+string(resp.SessionID)+"/streams", nil)
+	var streams ListPcapStreamsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &streams); w.Code != http.StatusOK || err != nil ||
+		len(streams) != 1 || streams[0].Packets != 2 || streams[0].ClientBytes != 18 {
+		t.Errorf("❌ GET /pcap/{id}/streams: status = %v, streams = %s", w.Code, w.Body)
+	}
+	w = doRequest(t, r, http.MethodGet, "/pcap/"+string(resp.SessionID)+"/streams/0?mode=ascii", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "GET / HTTP/1.1") {
+		t.Errorf("❌ GET /pcap/{id}/streams/0: status = %v, body = %s", w.Code, w.Body)
+	}
+	w = doRequest(t, r, http.MethodGet, "/pcap/"+string(resp.SessionID)+"/streams/1", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("❌ GET /pcap/{id}/streams/1: status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	w = doRequest(t, r, http.MethodDelete, "/pcap", StopPcapRequest{SessionID: resp.SessionID})
+	if w.Code != http.StatusOK {
+		t.Errorf("❌ DELETE /pcap ended: status = %v, want %v", w.Code, http.StatusOK)
+	}
+	w = doRequest(t, r, http.MethodGet, "/pcap/"+string(resp.SessionID)+"/streams", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("❌ GET /pcap/{id}/streams after DELETE: status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	tests := []struct {
+		url        string
+		wantStatus int
+	}{
+		{"/pcap/noexists/streams", http.StatusNotFound},
+		{"/pcap/noexists/streams/0", http.StatusNotFound},
+		{"/pcap/noexists/streams/zero", http.StatusBadRequest},
+		{"/pcap/noexists/streams/0?mode=ebcdic", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := doRequest(t, r, http.MethodGet, tt.url, nil)
+		if w.Code != tt.wantStatus {
+			t.Errorf("❌ GET %v: status = %v, want %v", tt.url, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestGetPcapFlows(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	writeTestPcapFile(t, path.Join(tmpdir, "src.pcap"), 3)
+
+	r := newTestHttp(Config{OutputDir: tmpdir, CaptureDir: tmpdir})
+
+	sessions := map[bool]goners.SessionID{} // tracked or not
+	for _, tracked := range []bool{true, false} {
+		w := doRequest(t, r, http.MethodPost, "/pcap", gin.H{
+			"file": "src.pcap", "output": "pcap", "output_file": fmt.Sprintf("out-%v.pcap", tracked),
+			"track_flows": tracked, "track_protocols": tracked})
+		var resp StartPcapResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("❌ POST /pcap: status = %v: %s", w.Code, w.Body)
+		}
+		sessions[tracked] = resp.SessionID
+	}
+	for _, id := range sessions {
+		waitSessionDone(t, id) // sessions end at EOF
+	}
+
+	tests := []struct {
+		id         goners.SessionID
+		url        string
+		wantStatus int
+	}{
+		{sessions[true], "conversations", http.StatusOK},
+		{sessions[true], "endpoints?type=ip", http.StatusOK},
+		{sessions[true], "protocols", http.StatusOK},
+		{sessions[false], "conversations", http.StatusNotFound},
+		{sessions[false], "endpoints?type=ip", http.StatusNotFound},
+		{sessions[false], "protocols", http.StatusNotFound},
+		{"noexists", "conversations", http.StatusNotFound},
+		{"noexists", "endpoints?type=ip", http.StatusNotFound},
+		{"noexists", "protocols", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		url := "/pcap/" + string(tt.id) + "/" + tt.url
+		w := doRequest(t, r, http.MethodGet, url, nil)
+		if w.Code != tt.wantStatus {
+			t.Errorf("❌ GET %v: status = %v, want %v", url, w.Code, tt.wantStatus)
+		}
+	}
+
+	w := doRequest(t, r, http.MethodGet, "/pcap/"+string(sessions[true])+"/conversations?type=udp", nil)
+	var conversations GetPcapConversationsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &conversations); err != nil ||
+		len(conversations) != 1 || conversations[0].Packets != 3 {
+		t.Errorf("❌ GET /pcap/{id}/conversations?type=udp = %s", w.Body)
+	}
+}
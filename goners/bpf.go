@@ -0,0 +1,260 @@
+package goners
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// ErrBadFilter is returned (wrapped) by OpenPacketSource if the BPF
+// filter fails to compile for the link type of the source.
+var ErrBadFilter = errors.New("bad filter")
+
+// BPFProgram is a BPF filter compiled by libpcap.
+type BPFProgram struct {
+	Filter       string           `json:"filter"`
+	LinkType     layers.LinkType  `json:"link_type"`
+	Snaplen      int              `json:"snaplen"`
+	Instructions []BPFInstruction `json:"instructions"`
+}
+
+// BPFInstruction is a raw BPF instruction.
+type BPFInstruction struct {
+	Code uint16 `json:"code"`
+	Jt   uint8  `json:"jt"`
+	Jf   uint8  `json:"jf"`
+	K    uint32 `json:"k"`
+}
+
+// CompileBPF compiles the filter for the link type & snaplen, without
+// opening any device. It returns the syntax error reported by libpcap
+// if the filter is bad.
+func CompileBPF(filter string, linkType layers.LinkType, snaplen int) (*BPFProgram, error) {
+	if snaplen <= 0 {
+		snaplen = DefaultSnaplen
+	}
+	insns, err := pcap.CompileBPFFilter(linkType, snaplen, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	program := &BPFProgram{
+		Filter:       filter,
+		LinkType:     linkType,
+		Snaplen:      snaplen,
+		Instructions: make([]BPFInstruction, 0, len(insns)),
+	}
+	for _, insn := range insns {
+		program.Instructions = append(program.Instructions, BPFInstruction(insn))
+	}
+	return program, nil
+}
+
+// String disassembles the program like tcpdump -d.
+func (p BPFProgram) String() string {
+	var sb strings.Builder
+	for i, insn := range p.Instructions {
+		sb.WriteString(insn.Disassemble(i))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// Dump dumps the program as a C array like tcpdump -dd.
+func (p BPFProgram) Dump() string {
+	var sb strings.Builder
+	for _, insn := range p.Instructions {
+		fmt.Fprintf(&sb, "{ 0x%x, %d, %d, 0x%08x },\n", insn.Code, insn.Jt, insn.Jf, insn.K)
+	}
+	return sb.String()
+}
+
+// BPF instruction classes, sizes, modes & ops (see <pcap/bpf.h>).
+const (
+	bpfLD   = 0x00
+	bpfLDX  = 0x01
+	bpfST   = 0x02
+	bpfSTX  = 0x03
+	bpfALU  = 0x04
+	bpfJMP  = 0x05
+	bpfRET  = 0x06
+	bpfMISC = 0x07
+
+	bpfW = 0x00
+	bpfH = 0x08
+	bpfB = 0x10
+
+	bpfIMM = 0x00
+	bpfABS = 0x20
+	bpfIND = 0x40
+	bpfMEM = 0x60
+	bpfLEN = 0x80
+	bpfMSH = 0xa0
+
+	bpfK = 0x00
+	bpfX = 0x08
+	bpfA = 0x10 // RET A
+
+	bpfJA   = 0x00
+	bpfJEQ  = 0x10
+	bpfJGT  = 0x20
+	bpfJGE  = 0x30
+	bpfJSET = 0x40
+
+	bpfNEG = 0x80
+
+	bpfTAX = 0x00
+	bpfTXA = 0x80
+)
+
+var bpfALUOps = map[uint16]struct {
+	name string
+	hex  bool // K in hex
+}{
+	0x00: {"add", false},
+	0x10: {"sub", false},
+	0x20: {"mul", false},
+	0x30: {"div", false},
+	0x40: {"or", true},
+	0x50: {"and", true},
+	0x60: {"lsh", false},
+	0x70: {"rsh", false},
+	0x90: {"mod", false},
+	0xa0: {"xor", true},
+}
+
+var bpfJumpOps = map[uint16]string{
+	bpfJEQ:  "jeq",
+	bpfJGT:  "jgt",
+	bpfJGE:  "jge",
+	bpfJSET: "jset",
+}
+
+// Disassemble the n-th instruction of a program, like bpf_image of libpcap:
+//
+//	(001) jeq      #0x800           jt 2	jf 3
+func (insn BPFInstruction) Disassemble(n int) string {
+	op, operand := insn.image(n)
+	if insn.Code&0x07 == bpfJMP && insn.Code&0xf0 != bpfJA {
+		return fmt.Sprintf("(%03d) %-8s %-16s jt %d\tjf %d",
+			n, op, operand, n+1+int(insn.Jt), n+1+int(insn.Jf))
+	}
+	return strings.TrimRight(fmt.Sprintf("(%03d) %-8s %s", n, op, operand), " ")
+}
+
+// image returns the op & operand of the n-th instruction.
+func (insn BPFInstruction) image(n int) (op, operand string) {
+	code, k := insn.Code, insn.K
+
+	switch code & 0x07 {
+	case bpfRET:
+		switch code & 0x18 {
+		case bpfK:
+			return "ret", "#" + strconv.FormatUint(uint64(k), 10)
+		case bpfX:
+			return "ret", "x"
+		case bpfA:
+			return "ret", ""
+		}
+	case bpfLD, bpfLDX:
+		name := map[uint16]string{bpfW: "ld", bpfH: "ldh", bpfB: "ldb"}[code&0x18]
+		if code&0x07 == bpfLDX {
+			name = map[uint16]string{bpfW: "ldx", bpfB: "ldxb"}[code&0x18]
+		}
+		if name == "" {
+			break
+		}
+		switch code & 0xe0 {
+		case bpfIMM:
+			return name, fmt.Sprintf("#0x%x", k)
+		case bpfABS:
+			return name, fmt.Sprintf("[%d]", k)
+		case bpfIND:
+			return name, fmt.Sprintf("[x + %d]", k)
+		case bpfMEM:
+			return name, fmt.Sprintf("M[%d]", k)
+		case bpfLEN:
+			return name, "#pktlen"
+		case bpfMSH:
+			return name, fmt.Sprintf("4*([%d]&0xf)", k)
+		}
+	case bpfST:
+		return "st", fmt.Sprintf("M[%d]", k)
+	case bpfSTX:
+		return "stx", fmt.Sprintf("M[%d]", k)
+	case bpfJMP:
+		if code&0xf0 == bpfJA {
+			return "ja", strconv.Itoa(n + 1 + int(k))
+		}
+		name, ok := bpfJumpOps[code&0xf0]
+		if !ok {
+			break
+		}
+		if code&0x08 == bpfX {
+			return name, "x"
+		}
+		return name, fmt.Sprintf("#0x%x", k)
+	case bpfALU:
+		if code&0xf0 == bpfNEG {
+			return "neg", ""
+		}
+		alu, ok := bpfALUOps[code&0xf0]
+		if !ok {
+			break
+		}
+		if code&0x08 == bpfX {
+			return alu.name, "x"
+		}
+		if alu.hex {
+			return alu.name, fmt.Sprintf("#0x%x", k)
+		}
+		return alu.name, fmt.Sprintf("#%d", k)
+	case bpfMISC:
+		switch code & 0xf8 {
+		case bpfTAX:
+			return "tax", ""
+		case bpfTXA:
+			return "txa", ""
+		}
+	}
+	return "unimp", fmt.Sprintf("0x%x", code)
+}
+
+// linkTypeAliases are the DLT names used by tcpdump -y.
+var linkTypeAliases = map[string]layers.LinkType{
+	"null":             layers.LinkTypeNull,
+	"en10mb":           layers.LinkTypeEthernet,
+	"raw":              layers.LinkTypeRaw,
+	"loop":             layers.LinkTypeLoop,
+	"linux_sll":        layers.LinkTypeLinuxSLL,
+	"ieee802_11":       layers.LinkTypeIEEE802_11,
+	"ieee802_11_radio": layers.LinkTypeIEEE80211Radio,
+	"ipv4":             layers.LinkTypeIPv4,
+	"ipv6":             layers.LinkTypeIPv6,
+}
+
+// ParseLinkType parses the link type by its name ("Ethernet", "Raw"),
+// DLT name ("EN10MB", "LINUX_SLL") or number ("1").
+// An empty string is Ethernet.
+func ParseLinkType(s string) (layers.LinkType, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return layers.LinkTypeEthernet, nil
+	}
+	if n, err := strconv.ParseUint(s, 0, 8); err == nil {
+		return layers.LinkType(n), nil
+	}
+	if lt, ok := linkTypeAliases[s]; ok {
+		return lt, nil
+	}
+	for i := 0; i < 256; i++ {
+		if strings.ToLower(layers.LinkType(i).String()) == s {
+			return layers.LinkType(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown link type: %q", s)
+}
@@ -0,0 +1,113 @@
+package goners
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestBPFProgram_String(t *testing.T) {
+	// tcpdump -d 'ip and tcp dst port 80'
+	program := BPFProgram{Instructions: []BPFInstruction{
+		{0x28, 0, 0, 12},
+		{0x15, 0, 8, 0x800},
+		{0x30, 0, 0, 23},
+		{0x15, 0, 6, 0x6},
+		{0x28, 0, 0, 20},
+		{0x45, 4, 0, 0x1fff},
+		{0xb1, 0, 0, 14},
+		{0x48, 0, 0, 16},
+		{0x15, 0, 1, 0x50},
+		{0x6, 0, 0, 262144},
+		{0x6, 0, 0, 0},
+	}}
+	want := `(000) ldh      [12]
+(001) jeq      #0x800           jt 2	jf 10
+(002) ldb      [23]
+(003) jeq      #0x6             jt 4	jf 10
+(004) ldh      [20]
+(005) jset     #0x1fff          jt 10	jf 6
+(006) ldxb     4*([14]&0xf)
+(007) ldh      [x + 16]
+(008) jeq      #0x50            jt 9	jf 10
+(009) ret      #262144
+(010) ret      #0
+`
+	if got := program.String(); got != want {
+		t.Errorf("❌ String() =\n%v\nwant\n%v", got, want)
+	}
+
+	if got := program.Dump(); !strings.HasPrefix(got, "{ 0x28, 0, 0, 0x0000000c },\n{ 0x15, 0, 8, 0x00000800 },\n") {
+		t.Errorf("❌ Dump() =\n%v", got)
+	}
+}
+
+func TestBPFInstruction_Disassemble(t *testing.T) {
+	tests := []struct {
+		insn BPFInstruction
+		want string
+	}{
+		{BPFInstruction{0x00, 0, 0, 5}, "(003) ld       #0x5"},
+		{BPFInstruction{0x80, 0, 0, 0}, "(003) ld       #pktlen"},
+		{BPFInstruction{0x60, 0, 0, 1}, "(003) ld       M[1]"},
+		{BPFInstruction{0x02, 0, 0, 1}, "(003) st       M[1]"},
+		{BPFInstruction{0x05, 0, 0, 2}, "(003) ja       6"},
+		{BPFInstruction{0x1d, 1, 2, 0}, "(003) jeq      x                jt 5\tjf 6"},
+		{BPFInstruction{0x0c, 0, 0, 0}, "(003) add      x"},
+		{BPFInstruction{0x54, 0, 0, 0xff}, "(003) and      #0xff"},
+		{BPFInstruction{0x74, 0, 0, 4}, "(003) rsh      #4"},
+		{BPFInstruction{0x84, 0, 0, 0}, "(003) neg"},
+		{BPFInstruction{0x07, 0, 0, 0}, "(003) tax"},
+		{BPFInstruction{0x87, 0, 0, 0}, "(003) txa"},
+		{BPFInstruction{0x16, 0, 0, 0}, "(003) ret"},
+		{BPFInstruction{0xff, 0, 0, 0}, "(003) unimp    0xff"},
+	}
+	for _, tt := range tests {
+		if got := tt.insn.Disassemble(3); got != tt.want {
+			t.Errorf("❌ Disassemble(%+v) = %q, want %q", tt.insn, got, tt.want)
+		}
+	}
+}
+
+func TestParseLinkType(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    layers.LinkType
+		wantErr bool
+	}{
+		{"", layers.LinkTypeEthernet, false},
+		{"Ethernet", layers.LinkTypeEthernet, false},
+		{"EN10MB", layers.LinkTypeEthernet, false},
+		{"raw", layers.LinkTypeRaw, false},
+		{"linux_sll", layers.LinkTypeLinuxSLL, false},
+		{"113", layers.LinkTypeLinuxSLL, false},
+		{"carrier-pigeon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLinkType(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("❌ ParseLinkType(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+// requires libpcap
+func TestCompileBPF(t *testing.T) {
+	program, err := CompileBPF("ip", layers.LinkTypeEthernet, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `(000) ldh      [12]
+(001) jeq      #0x800           jt 2	jf 3
+(002) ret      #262144
+(003) ret      #0
+`
+	if got := program.String(); got != want {
+		t.Errorf("❌ CompileBPF(ip) =\n%v\nwant\n%v", got, want)
+	}
+
+	if _, err := CompileBPF("tcp port", layers.LinkTypeEthernet, 0); err == nil {
+		t.Errorf("❌ CompileBPF(bad filter) error = nil")
+	}
+}
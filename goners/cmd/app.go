@@ -6,6 +6,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/cdfmlr/goners"
@@ -28,17 +32,18 @@ func commandDevices() *cli.Command {
 				log.Fatalf("failed to lookup devices: %v.", err)
 			}
 
-			switch ctx.String("format") {
-			case "text":
-				for _, d := range devices {
-					fmt.Println(d.String())
-				}
-			case "json":
+			// the packet formats (e.g. template) fall back to text,
+			// like the follow & stats commands
+			if ctx.String("format") == "json" {
 				j, err := json.Marshal(devices)
 				if err != nil {
 					log.Fatalf("failed to marshal json: %v.", err)
 				}
 				fmt.Println(string(j))
+				return nil
+			}
+			for _, d := range devices {
+				fmt.Println(d.String())
 			}
 			return nil
 		},
@@ -46,40 +51,25 @@ func commandDevices() *cli.Command {
 }
 
 func commandPcap() *cli.Command {
-	flagCategoryOutput := `OUTPUT: outputs captured packets. 
-	    Default output is STDOUT. (require a tty with 96 chars width for pretty-print text format)
-	    (the requirement is satisfied if you can see above sentence in one line.)
-	    Use one of --output FILE or --ws ADDR to override it.`
-
 	flagCategoryConfig := `CONFIG: configures the pcap.`
 
 	return &cli.Command{
 		Name:  "pcap",
 		Usage: "Capture live packets from device. Root privilege is required.",
 		// 大名鼎鼎的 urfave/cli 居然不支持位置参数。。难怪斗不过 spf13/cobra。
-		ArgsUsage: "DEVICE\n\nARGUMENTS:\n\tDEVICE: name of the device to capture. Use \"goners devices\" to list available devices.",
-		Flags: []cli.Flag{
+		ArgsUsage: "DEVICE...\n\nARGUMENTS:\n\tDEVICE: name of the device to capture. Use \"goners devices\" to list available devices.\n\t        Multiple devices (\"eth0 tun0\" or \"eth0,tun0\") or \"any\" (all up devices) are captured into a single time-ordered stream.",
+
+		UseShortOptionHandling: true, // -vv
+		Flags: append([]cli.Flag{
 			flagFormat(),
-			&cli.StringFlag{
-				Name:     "output",
-				Aliases:  []string{"o"},
-				Usage:    "Output caputred packtes into `FILE`.",
-				Category: flagCategoryOutput,
-			},
-			&cli.StringFlag{
-				Name:     "ws",
-				Usage:    "Output caputred packtes by WebSocket (listen `ADDR` and serve ws at \"/\").",
-				Category: flagCategoryOutput,
-			},
-			&cli.StringFlag{
-				Name:     "filter",
-				Usage:    "sets a `BPF` filter for the pcap (syntax reference: https://biot.com/capstats/bpf.html).",
-				Category: flagCategoryConfig,
-			},
+			flagFilter(flagCategoryConfig),
+			flagDisplayFilter(flagCategoryConfig),
+			flagFast(flagCategoryConfig),
+			flagDefrag(flagCategoryConfig),
 			&cli.IntFlag{
 				Name:     "snaplen",
 				Aliases:  []string{"s"},
-				Value:    262144, // tcpdump default snaplen
+				Value:    goners.DefaultSnaplen,
 				Usage:    "Snarf snaplen `BYTES` of data from each packet. Packets will be truncated because of a limited snapshot",
 				Category: flagCategoryConfig,
 			},
@@ -91,12 +81,12 @@ func commandPcap() *cli.Command {
 			},
 			&cli.Int64Flag{
 				Name:        "timeout",
-				Usage:       "timeout in `SECONDS` to stop the capturing. <0 means block forever.",
+				Usage:       "libpcap read timeout in `SECONDS` (NOT the capturing duration, see --duration). <0 means block forever.",
 				Value:       int64(goners.BlockForever),
 				DefaultText: "BlockForever",
 				Category:    flagCategoryConfig,
 			},
-		},
+		}, append(flagsStop(), flagsOutput()...)...),
 		Action: func(ctx *cli.Context) error {
 			var timeout time.Duration
 			if ctx.Int64("timeout") < 0 {
@@ -105,56 +95,93 @@ func commandPcap() *cli.Command {
 				timeout = time.Second * time.Duration(ctx.Int64("timeout"))
 			}
 
-			packets, err := goners.CaptureLivePackets(
-				context.Background(),
-				ctx.Args().First(),
-				ctx.String("filter"),
-				int32(ctx.Int("snaplen")),
-				ctx.Bool("promisc"),
-				timeout,
-			)
+			c, cancel := context.WithCancel(signalContext())
+			devices := goners.ParseDevices(strings.Join(ctx.Args().Slice(), ","))
+			providers, err := goners.LiveSources(devices, int32(ctx.Int("snaplen")), ctx.Bool("promisc"), timeout)
 			if err != nil {
 				log.Fatalf("failed to capture live packets: %v", err)
 			}
-
-			var formater goners.PacketsFormater
-			switch ctx.String("format") {
-			case "text":
-				formater = goners.StringPacketsFormater
-			case "json":
-				formater = goners.JsonPacketsFormater
+			sources, providers, err := goners.OpenPacketSources(providers, ctx.String("filter"))
+			if err != nil {
+				log.Fatalf("failed to capture live packets: %v", err)
 			}
-
-			var out goners.Outputer
-			switch {
-			case ctx.String("output") != "":
-				f := ctx.String("output")
-				if out, err = goners.NewFileOutputer(f); err != nil {
+			if f := ctx.String("output-pcap"); f != "" {
+				if err := goners.PcapFileFormatOf(f).CheckLinkTypes(sources); err != nil {
 					log.Fatalf("failed to output into %v: %v", f, err)
 				}
-			case ctx.String("ws") != "":
-				addr := ctx.String("ws")
-
-				var ws websocket.Handler
-				out, ws = goners.NewWebSocketOutputer()
-
-				go func() {
-					mux := http.NewServeMux()
-					mux.Handle("/", ws)
-					slog.Info("Listen and serve http",
-						"addr", addr, "websocket", "/")
-					if err := http.ListenAndServe(addr, mux); err != nil {
-						log.Fatalf("failed to listen and serve ws: %v", err)
-					}
-				}()
-			default:
-				if out, err = goners.NewFileOutputer("/dev/stdout"); err != nil {
-					log.Fatalf("failed to output into /dev/stdout: %v", err)
-				}
 			}
 
-			out.Output(formater.FormatPackets(packets))
+			stats := goners.NewStatsCollector()
+			for i := range sources {
+				sources[i] = stats.WatchSource(sources[i])
+			}
+			sources = defragSources(ctx, sources)
+			packets := goners.CapturePacketsFromAll(c, sources, goners.DeviceNames(providers), captureFunc(ctx))
+			packets = filterPackets(ctx, packets)
+			packets = goners.LimitPackets(packets, stopConditions(ctx), cancel)
+			packets = stats.CountDecoded(packets)
+
+			err = outputPackets(ctx, stats, packets)
+
+			// summary on exit, like tcpdump
+			fmt.Fprint(os.Stderr, stats.Stats())
+
+			if err != nil {
+				return cli.Exit(err, 1)
+			}
+			return nil
+		},
+	}
+}
+
+func commandRead() *cli.Command {
+	flagCategoryConfig := `CONFIG: configures the reading.`
+
+	return &cli.Command{
+		Name:      "read",
+		Usage:     "Read packets from a saved pcap or pcapng file.",
+		ArgsUsage: "FILE\n\nARGUMENTS:\n\tFILE: path to the pcap/pcapng file to read (e.g. saved by tcpdump -w or Wireshark).",
+
+		UseShortOptionHandling: true, // -vv
+		Flags: append([]cli.Flag{
+			flagFormat(),
+			flagFilter(flagCategoryConfig),
+			flagDisplayFilter(flagCategoryConfig),
+			flagFast(flagCategoryConfig),
+			flagDefrag(flagCategoryConfig),
+			&cli.BoolFlag{
+				Name:     "realtime",
+				Usage:    "replay packets at the pace they were recorded (based on the timestamps in FILE). Required by --ws",
+				Value:    false,
+				Category: flagCategoryConfig,
+			},
+		}, append(flagsStop(), flagsOutput()...)...),
+		Action: func(ctx *cli.Context) error {
+			file := ctx.Args().First()
+			if file == "" {
+				return fmt.Errorf("missing argument FILE")
+			}
+			if ctx.String("ws") != "" && !ctx.Bool("realtime") {
+				// or the file is read through before any client connects
+				return fmt.Errorf("--ws requires --realtime")
+			}
+
+			c, cancel := context.WithCancel(signalContext())
+			source, err := goners.OpenPacketSource(goners.FileSource{Path: file}, ctx.String("filter"))
+			if err != nil {
+				log.Fatalf("failed to read packets from %v: %v", file, err)
+			}
+			packets := captureFunc(ctx)(c, defragSources(ctx, []goners.PacketSource{source})[0])
+			packets = filterPackets(ctx, packets)
+
+			if ctx.Bool("realtime") {
+				packets = goners.ReplayPackets(c, packets)
+			}
+			packets = goners.LimitPackets(packets, stopConditions(ctx), cancel)
 
+			if err := outputPackets(ctx, nil, packets); err != nil {
+				return cli.Exit(err, 1)
+			}
 			return nil
 		},
 	}
@@ -163,11 +190,24 @@ func commandPcap() *cli.Command {
 func commandHttp() *cli.Command {
 	apiUsage := `
 	devicse:
-		GET    /devices           lookup devices
+		GET    /devices                 lookup devices
 	pcap:
-		POST   /pcap              start a capturing session
-		DELETE /pcap              stop & close a capturing session
-		WS     /pcap/{sessionID}  get packets`
+		GET    /pcap                    list capturing sessions
+		POST   /pcap                    start a capturing session
+		DELETE /pcap                    stop & close a capturing session
+		WS     /pcap/{sessionID}        get packets
+		PATCH  /pcap/{sessionID}        change the filter of a capturing session
+		GET    /pcap/{sessionID}/info   get the info of a capturing session
+		GET    /pcap/{sessionID}/stats  get the statistics of a capturing session
+		POST   /pcap/{sessionID}/pause  pause a capturing session
+		POST   /pcap/{sessionID}/resume resume a paused capturing session
+		GET    /pcap/{sessionID}/conversations  get the conversations (track_flows, ?type=eth|ip|ipv6|tcp|udp)
+		GET    /pcap/{sessionID}/endpoints      get the endpoints (track_flows, ?type=eth|ip|ipv6|tcp|udp)
+		GET    /pcap/{sessionID}/protocols      get the protocol hierarchy (track_protocols)
+		GET    /pcap/{sessionID}/streams      list TCP streams (track_streams)
+		GET    /pcap/{sessionID}/streams/{id} follow a TCP stream (?mode=ascii|hex|raw)
+	bpf:
+		POST   /bpf/compile             validate & compile a BPF filter`
 
 	return &cli.Command{
 		Name:  "http",
@@ -178,9 +218,20 @@ func commandHttp() *cli.Command {
 				Value: "localhost:9800",
 				Usage: "start HTTP service on `HOST:PORT`",
 			},
+			&cli.PathFlag{
+				Name:  "output-dir",
+				Usage: "write the output_file of POST /pcap into `DIR` (relative paths in it only). Output files are disabled if unset.",
+			},
+			&cli.PathFlag{
+				Name:  "capture-dir",
+				Usage: "read the file of POST /pcap from `DIR` (relative paths in it only). Reading files is disabled if unset.",
+			},
 		},
 		Action: func(ctx *cli.Context) error {
-			r := api.NewHttp()
+			r := api.NewHttp(api.Config{
+				OutputDir:  ctx.Path("output-dir"),
+				CaptureDir: ctx.Path("capture-dir"),
+			})
 			if err := r.Run(ctx.String("addr")); err != nil {
 				log.Fatalf("Run HTTP failed with error: %v", err)
 			}
@@ -189,13 +240,317 @@ func commandHttp() *cli.Command {
 	}
 }
 
+func commandBpf() *cli.Command {
+	return &cli.Command{
+		Name:      "bpf",
+		Usage:     "Validate & compile a BPF filter, like tcpdump -d. No device or privilege is required.",
+		ArgsUsage: "FILTER\n\nARGUMENTS:\n\tFILTER: the BPF filter expression (e.g. \"tcp port 443\").",
+		Flags: []cli.Flag{
+			flagFormat(),
+			&cli.StringFlag{
+				Name:    "link-type",
+				Aliases: []string{"y"},
+				Value:   "ethernet",
+				Usage:   "compile for the link `TYPE`: name (ethernet, raw, linux_sll, ...) or number of the DLT",
+			},
+			&cli.IntFlag{
+				Name:    "snaplen",
+				Aliases: []string{"s"},
+				Value:   goners.DefaultSnaplen,
+				Usage:   "compile for the snaplen `BYTES`",
+			},
+			&cli.BoolFlag{
+				Name:  "dd",
+				Usage: "dump the instructions as a C array (like tcpdump -dd) instead of disassembling them",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			filter := strings.Join(ctx.Args().Slice(), " ")
+
+			linkType, err := goners.ParseLinkType(ctx.String("link-type"))
+			if err != nil {
+				return cli.Exit(err, 1)
+			}
+			program, err := goners.CompileBPF(filter, linkType, ctx.Int("snaplen"))
+			if err != nil {
+				return cli.Exit(fmt.Sprintf("bad filter %q: %v", filter, err), 1)
+			}
+
+			switch {
+			case ctx.String("format") == "json":
+				j, err := json.Marshal(program)
+				if err != nil {
+					log.Fatalf("failed to marshal json: %v.", err)
+				}
+				fmt.Println(string(j))
+			case ctx.Bool("dd"):
+				fmt.Print(program.Dump())
+			default:
+				fmt.Print(program)
+			}
+			return nil
+		},
+	}
+}
+
+func commandFollow() *cli.Command {
+	flagCategoryConfig := `CONFIG: configures the capturing.`
+	flagCategoryFollow := `FOLLOW: chooses the stream to follow. All streams are listed if none is chosen.`
+
+	return &cli.Command{
+		Name:      "follow",
+		Usage:     "Reassemble TCP streams and follow one of them, like \"Follow TCP Stream\" of Wireshark.",
+		ArgsUsage: argsUsageInput,
+		Flags: append([]cli.Flag{
+			flagFormat(),
+			&cli.IntFlag{
+				Name:     "stream",
+				Value:    -1,
+				Usage:    "follow the stream of the `INDEX` (tcp.stream of Wireshark).",
+				Category: flagCategoryFollow,
+			},
+			&cli.StringFlag{
+				Name:     "tuple",
+				Usage:    "follow the stream between the `ENDPOINTS`, e.g. \"10.0.0.1:40000,10.0.0.2:80\" (in any order).",
+				Category: flagCategoryFollow,
+			},
+			&cli.StringFlag{
+				Name:     "mode",
+				Value:    "ascii",
+				Usage:    "dump the stream in `MODE`: ascii | hex | raw (the bytes as they are, both directions)",
+				Category: flagCategoryFollow,
+			},
+		}, flagsInput(flagCategoryConfig)...),
+		Action: func(ctx *cli.Context) error {
+			mode, err := goners.ParseFollowMode(ctx.String("mode"))
+			if err != nil {
+				return cli.Exit(err, 1)
+			}
+
+			packets, err := inputPackets(ctx)
+			if err != nil {
+				return err
+			}
+			tracker := goners.NewStreamTracker()
+			for p := range tracker.Track(packets) {
+				p.Release()
+			}
+			if n := tracker.Untracked(); n > 0 {
+				slog.Warn("follow: packets of the streams beyond the limit are not tracked.",
+					"limit", tracker.Limit, "packets", n)
+			}
+
+			var stream goners.TCPStream
+			switch {
+			case ctx.String("tuple") != "":
+				endpoints := strings.Split(ctx.String("tuple"), ",")
+				if len(endpoints) != 2 {
+					return cli.Exit(fmt.Sprintf("bad tuple %q: want \"IP:PORT,IP:PORT\"", ctx.String("tuple")), 1)
+				}
+				stream, err = tracker.Lookup(strings.TrimSpace(endpoints[0]), strings.TrimSpace(endpoints[1]))
+			case ctx.Int("stream") >= 0:
+				stream, err = tracker.Stream(ctx.Int("stream"))
+			default:
+				printStreams(ctx, tracker.Streams())
+				return nil
+			}
+			if err != nil {
+				return cli.Exit(err, 1)
+			}
+
+			if ctx.String("format") == "json" {
+				j, err := json.Marshal(stream)
+				if err != nil {
+					log.Fatalf("failed to marshal json: %v.", err)
+				}
+				fmt.Println(string(j))
+				return nil
+			}
+			return goners.WriteFollow(os.Stdout, stream, mode)
+		},
+	}
+}
+
+func commandStats() *cli.Command {
+	return &cli.Command{
+		Name:  "stats",
+		Usage: "Statistics of packets from a saved file or devices, like the Statistics menu of Wireshark.",
+		Subcommands: []*cli.Command{
+			commandStatsFlows("conversations", "Traffic between each pair of endpoints (i.e. who is talking to whom the most)."),
+			commandStatsFlows("endpoints", "Traffic of each endpoint."),
+			commandStatsProtocols(),
+		},
+	}
+}
+
+// commandStatsFlows is the conversations or endpoints command.
+func commandStatsFlows(name, usage string) *cli.Command {
+	flagCategoryConfig := `CONFIG: configures the capturing.`
+	flagCategoryStats := `STATS: configures the statistics.`
+
+	return &cli.Command{
+		Name:      name,
+		Usage:     usage,
+		ArgsUsage: argsUsageInput,
+		Flags: append([]cli.Flag{
+			flagFormat(),
+			flagDisplayFilter(flagCategoryConfig),
+			&cli.StringFlag{
+				Name:     "type",
+				Usage:    "only the `LAYER`: eth, ip, ipv6, tcp, udp, ... (all by default)",
+				Category: flagCategoryStats,
+			},
+			&cli.IntFlag{
+				Name:     "top",
+				Usage:    "only the top `N` (the most bytes). 0 means all.",
+				Category: flagCategoryStats,
+			},
+		}, flagsInput(flagCategoryConfig)...),
+		Action: func(ctx *cli.Context) error {
+			packets, err := inputPackets(ctx)
+			if err != nil {
+				return err
+			}
+			flows := goners.NewFlowTracker()
+			for p := range flows.Track(filterPackets(ctx, packets)) {
+				p.Release()
+			}
+
+			top := func(n int) int {
+				if ctx.Int("top") > 0 && ctx.Int("top") < n {
+					return ctx.Int("top")
+				}
+				return n
+			}
+			var table any
+			switch name {
+			case "conversations":
+				conversations := flows.Conversations(ctx.String("type"))
+				conversations = conversations[:top(len(conversations))]
+				if ctx.String("format") != "json" {
+					return goners.WriteConversations(os.Stdout, conversations)
+				}
+				table = conversations
+			case "endpoints":
+				endpoints := flows.Endpoints(ctx.String("type"))
+				endpoints = endpoints[:top(len(endpoints))]
+				if ctx.String("format") != "json" {
+					return goners.WriteEndpoints(os.Stdout, endpoints)
+				}
+				table = endpoints
+			}
+
+			j, err := json.Marshal(table)
+			if err != nil {
+				log.Fatalf("failed to marshal json: %v.", err)
+			}
+			fmt.Println(string(j))
+			return nil
+		},
+	}
+}
+
+func commandStatsProtocols() *cli.Command {
+	flagCategoryConfig := `CONFIG: configures the capturing.`
+
+	return &cli.Command{
+		Name:      "protocols",
+		Usage:     "Protocol hierarchy: packets & bytes of each chain of layers (e.g. Ethernet > IPv4 > TCP > TLS).",
+		ArgsUsage: argsUsageInput,
+		Flags: append([]cli.Flag{
+			flagFormat(),
+			flagDisplayFilter(flagCategoryConfig),
+		}, flagsInput(flagCategoryConfig)...),
+		Action: func(ctx *cli.Context) error {
+			packets, err := inputPackets(ctx)
+			if err != nil {
+				return err
+			}
+			protocols := goners.NewProtocolTracker()
+			for p := range protocols.Track(filterPackets(ctx, packets)) {
+				p.Release()
+			}
+
+			if ctx.String("format") != "json" {
+				return goners.WriteProtocolHierarchy(os.Stdout, protocols.Hierarchy())
+			}
+			j, err := json.Marshal(protocols.Hierarchy())
+			if err != nil {
+				log.Fatalf("failed to marshal json: %v.", err)
+			}
+			fmt.Println(string(j))
+			return nil
+		},
+	}
+}
+
+const argsUsageInput = "FILE\n\nARGUMENTS:\n\tFILE: path to the pcap/pcapng file to read. Use --device to capture live packets instead."
+
+// flagsInput are flags for inputPackets.
+func flagsInput(category string) []cli.Flag {
+	return append([]cli.Flag{
+		flagFilter(category),
+		flagFast(category),
+		flagDefrag(category),
+		&cli.StringFlag{
+			Name:     "device",
+			Aliases:  []string{"i"},
+			Usage:    "capture live packets from the `DEVICE`s (\"eth0,tun0\" or \"any\") instead of reading a FILE, until Ctrl-C or a stop condition.",
+			Category: category,
+		},
+	}, flagsStop()...)
+}
+
+// inputPackets captures packets from the FILE argument or the --device,
+// until EOF, Ctrl-C or a stop condition.
+func inputPackets(ctx *cli.Context) (chan *goners.Packet, error) {
+	var providers []goners.PacketSourceProvider
+	switch {
+	case ctx.String("device") != "":
+		var err error
+		providers, err = goners.LiveSources(goners.ParseDevices(ctx.String("device")), goners.DefaultSnaplen, false, goners.BlockForever)
+		if err != nil {
+			return nil, fmt.Errorf("failed to capture live packets: %w", err)
+		}
+	case ctx.Args().First() != "":
+		providers = []goners.PacketSourceProvider{goners.FileSource{Path: ctx.Args().First()}}
+	default:
+		return nil, fmt.Errorf("missing argument FILE (or --device)")
+	}
+	sources, providers, err := goners.OpenPacketSources(providers, ctx.String("filter"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture packets: %w", err)
+	}
+	sources = defragSources(ctx, sources)
+
+	c, cancel := context.WithCancel(signalContext())
+	packets := goners.CapturePacketsFromAll(c, sources, goners.DeviceNames(providers), captureFunc(ctx))
+	return goners.LimitPackets(packets, stopConditions(ctx), cancel), nil
+}
+
+// printStreams lists the streams in the --format.
+func printStreams(ctx *cli.Context, streams []goners.TCPStream) {
+	if ctx.String("format") == "json" {
+		j, err := json.Marshal(streams)
+		if err != nil {
+			log.Fatalf("failed to marshal json: %v.", err)
+		}
+		fmt.Println(string(j))
+		return
+	}
+	fmt.Printf("%-6s %-45s %-45s %8s %12s %12s\n", "STREAM", "CLIENT", "SERVER", "PACKETS", "CLIENT_BYTES", "SERVER_BYTES")
+	for _, s := range streams {
+		fmt.Printf("%-6d %-45s %-45s %8d %12d %12d\n", s.Index, s.Client, s.Server, s.Packets, s.ClientBytes, s.ServerBytes)
+	}
+}
+
 func flagFormat() *cli.StringFlag {
 	return &cli.StringFlag{
 		Name:  "format",
 		Value: "text",
-		Usage: "Output `FORMAT`: text | json\n\ttext: our human preferred text.\n\tjson: the JSON format (more readable for machines)\n",
+		Usage: "Output `FORMAT`: text | summary | tcpdump | template | fields | json\n\ttext: our human preferred text.\n\tsummary: one line per packet, like tshark.\n\ttcpdump: one line per packet, like tcpdump (see -v, -x).\n\ttemplate: your own Go text/template (see --template).\n\tfields: CSV (or TSV) rows of the fields, like tshark -T fields (see --fields).\n\tjson: the JSON format, one line per packet (more readable for machines, see --json-profile).\n",
 		Action: func(ctx *cli.Context, s string) error {
-			available := []string{"text", "json"}
+			available := []string{"text", "summary", "tcpdump", "template", "fields", "json"}
 			for _, a := range available {
 				if s == a {
 					return nil
@@ -206,13 +561,387 @@ func flagFormat() *cli.StringFlag {
 	}
 }
 
+const flagCategoryStop = `STOP: stops the capturing when any of the conditions is hit.`
+
+// flagsStop are flags for stopConditions.
+func flagsStop() []cli.Flag {
+	return []cli.Flag{
+		&cli.Int64Flag{
+			Name:     "count",
+			Aliases:  []string{"c"},
+			Usage:    "Stop after `N` packets. 0 means no limit.",
+			Category: flagCategoryStop,
+		},
+		&cli.DurationFlag{
+			Name:     "duration",
+			Usage:    "Stop after `DURATION` (e.g. 30s, 5m, 1h). 0 means no limit.",
+			Category: flagCategoryStop,
+		},
+		&cli.Int64Flag{
+			Name:     "max-bytes",
+			Usage:    "Stop after `BYTES` of packets captured. 0 means no limit.",
+			Category: flagCategoryStop,
+		},
+	}
+}
+
+// stopConditions returns the StopConditions set by flagsStop.
+func stopConditions(ctx *cli.Context) goners.StopConditions {
+	return goners.StopConditions{
+		Count:    ctx.Int64("count"),
+		Duration: ctx.Duration("duration"),
+		MaxBytes: ctx.Int64("max-bytes"),
+	}
+}
+
+const flagCategoryOutput = `OUTPUT: outputs captured packets. 
+	    Default output is STDOUT. (require a tty with 96 chars width for pretty-print text format)
+	    (the requirement is satisfied if you can see above sentence in one line.)
+	    Use one of --output FILE or --ws ADDR to override it.
+	    Use --output-pcap FILE to save raw packets (alone, or along with the above).
+	    Use -C, -G and -W to rotate the FILEs (like tcpdump).`
+
+// flagsOutput are flags for newOutputer.
+func flagsOutput() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "output",
+			Aliases:  []string{"o"},
+			Usage:    "Output caputred packtes into `FILE`.",
+			Category: flagCategoryOutput,
+		},
+		&cli.StringFlag{
+			Name:     "ws",
+			Usage:    "Output caputred packtes by WebSocket (listen `ADDR` and serve ws at \"/\").",
+			Category: flagCategoryOutput,
+		},
+		&cli.StringFlag{
+			Name:     "output-pcap",
+			Aliases:  []string{"w"},
+			Usage:    "Write raw packets into pcap `FILE` (pcapng if FILE ends with .pcapng), which can be opened by goners read, tcpdump or Wireshark.",
+			Category: flagCategoryOutput,
+		},
+		&cli.Int64Flag{
+			Name:     "file-size",
+			Aliases:  []string{"C"},
+			Usage:    "Rotate to a new output file after writing `MB` (millions of bytes) into the current one. 0 means no limit.",
+			Category: flagCategoryOutput,
+		},
+		&cli.Int64Flag{
+			Name:     "rotate-seconds",
+			Aliases:  []string{"G"},
+			Usage:    "Rotate to a new output file every `SECONDS`. 0 means no limit.",
+			Category: flagCategoryOutput,
+		},
+		&cli.IntFlag{
+			Name:     "file-count",
+			Aliases:  []string{"W"},
+			Usage:    "Keep only the last `N` rotated output files. 0 means keeping all.",
+			Category: flagCategoryOutput,
+		},
+		&cli.BoolFlag{
+			Name:     "verbose",
+			Aliases:  []string{"v"},
+			Usage:    "more details in the tcpdump format: -v for IP headers, -vv for link layer headers as well.",
+			Count:    new(int),
+			Category: flagCategoryOutput,
+		},
+		&cli.BoolFlag{
+			Name:     "hex",
+			Aliases:  []string{"x"},
+			Usage:    "hex dump each packet in the tcpdump format, like tcpdump -xx.",
+			Category: flagCategoryOutput,
+		},
+		&cli.StringFlag{
+			Name:     "template",
+			Usage:    "Go text/template `TEXT` of each packet in the template format, e.g. '{{.Timestamp | time \"15:04:05\"}} {{flow .}} {{.Info}}'.",
+			Category: flagCategoryOutput,
+		},
+		&cli.PathFlag{
+			Name:     "template-file",
+			Usage:    "read the template of the template format from `FILE`.",
+			Category: flagCategoryOutput,
+		},
+		&cli.StringSliceFlag{
+			Name:     "fields",
+			Aliases:  []string{"e"},
+			Usage:    "`FIELDS` of the fields format, like the display filter fields, e.g. frame.time,ip.src,ip.dst,tcp.dstport,frame.len.",
+			Category: flagCategoryOutput,
+		},
+		&cli.BoolFlag{
+			Name:     "tsv",
+			Usage:    "tab separated fields format, instead of CSV.",
+			Category: flagCategoryOutput,
+		},
+		&cli.BoolFlag{
+			Name:     "no-header",
+			Usage:    "no header line of the field names in the fields format.",
+			Category: flagCategoryOutput,
+		},
+		&cli.StringFlag{
+			Name:     "json-profile",
+			Value:    "full",
+			Usage:    "`PROFILE` of the json format: summary (flow, type, length & info) | fields (layers without hex dumps) | full (everything).",
+			Category: flagCategoryOutput,
+			Action: func(ctx *cli.Context, s string) error {
+				return goners.JsonOptions{Profile: goners.JsonProfile(s)}.Validate()
+			},
+		},
+		&cli.BoolFlag{
+			Name:     "no-payload",
+			Usage:    "omit the payloads of layers in the json format.",
+			Category: flagCategoryOutput,
+		},
+		&cli.IntFlag{
+			Name:     "max-payload",
+			Usage:    "truncate the payloads of layers to `BYTES` in the json format. 0 means no limit.",
+			Category: flagCategoryOutput,
+		},
+	}
+}
+
+// rotateOptions returns the RotateOptions for output files set by flagsOutput.
+func rotateOptions(ctx *cli.Context) goners.RotateOptions {
+	return goners.RotateOptions{
+		FileSize:  ctx.Int64("file-size") * 1000 * 1000,
+		Interval:  time.Second * time.Duration(ctx.Int64("rotate-seconds")),
+		FileCount: ctx.Int("file-count"),
+	}
+}
+
+func flagFilter(category string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:     "filter",
+		Usage:    "sets a `BPF` filter for the pcap (syntax reference: https://biot.com/capstats/bpf.html).",
+		Category: category,
+	}
+}
+
+func flagDisplayFilter(category string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:     "display-filter",
+		Aliases:  []string{"Y"},
+		Usage:    "filters decoded packets by a Wireshark style display filter `EXPR`, e.g. 'tcp.dstport == 443 && ip.src in 10.0.0.0/8'.",
+		Category: category,
+	}
+}
+
+// filterPackets applies the --display-filter (if any) to the packets.
+func filterPackets(ctx *cli.Context, packets chan *goners.Packet) chan *goners.Packet {
+	expr := ctx.String("display-filter")
+	if strings.TrimSpace(expr) == "" {
+		return packets
+	}
+	filter, err := goners.CompileDisplayFilter(expr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return goners.FilterPackets(packets, filter)
+}
+
+func flagFast(category string) *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:     "fast",
+		Usage:    "decode only Ethernet/IPv4/IPv6/TCP/UDP/ICMP layers with the fast path, for busy links.",
+		Value:    false,
+		Category: category,
+	}
+}
+
+func flagDefrag(category string) *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:     "defrag",
+		Usage:    "reassemble IPv4/IPv6 fragments before decoding, so that fragmented UDP (e.g. large DNS responses) is decoded.",
+		Value:    false,
+		Category: category,
+	}
+}
+
+// defragSources wraps the sources with Defragment if --defrag.
+func defragSources(ctx *cli.Context, sources []goners.PacketSource) []goners.PacketSource {
+	if ctx.Bool("defrag") {
+		for i := range sources {
+			sources[i] = goners.Defragment(sources[i])
+		}
+	}
+	return sources
+}
+
+// captureFunc returns the CaptureFunc chosen by the --fast flag.
+func captureFunc(ctx *cli.Context) goners.CaptureFunc {
+	if ctx.Bool("fast") {
+		return goners.CapturePacketsFastFrom
+	}
+	return goners.CapturePacketsFrom
+}
+
+// newFormater returns the PacketsFormater chosen by the --format flag.
+func newFormater(ctx *cli.Context) goners.PacketsFormater {
+	var formater goners.PacketsFormater
+	switch ctx.String("format") {
+	case "text":
+		formater = goners.StringPacketsFormater
+	case "summary":
+		formater = goners.SummaryPacketsFormater
+	case "tcpdump":
+		formater = goners.NewTcpdumpPacketsFormater(goners.TcpdumpOptions{
+			Verbose: ctx.Count("verbose"),
+			Hex:     ctx.Bool("hex"),
+		})
+	case "template":
+		text := ctx.String("template")
+		if f := ctx.Path("template-file"); f != "" {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				log.Fatalf("failed to read template: %v", err)
+			}
+			text = string(b)
+		}
+		var err error
+		if formater, err = goners.NewTemplatePacketsFormater(text); err != nil {
+			log.Fatalf("bad template: %v", err)
+		}
+	case "fields":
+		opts := goners.FieldsOptions{
+			Fields:   ctx.StringSlice("fields"),
+			TSV:      ctx.Bool("tsv"),
+			NoHeader: ctx.Bool("no-header"),
+		}
+		var err error
+		if formater, err = goners.NewFieldsPacketsFormater(opts); err != nil {
+			log.Fatalf("bad fields: %v", err)
+		}
+	case "json":
+		var err error
+		formater, err = goners.NewJsonProfilePacketsFormater(goners.JsonOptions{
+			Profile:     goners.JsonProfile(ctx.String("json-profile")),
+			OmitPayload: ctx.Bool("no-payload"),
+			MaxPayload:  ctx.Int("max-payload"),
+		})
+		if err != nil {
+			log.Fatalf("bad json options: %v", err)
+		}
+	}
+	return formater
+}
+
+// outputPackets formats & outputs packets as the flagsOutput say.
+// It blocks until packets is closed and all outputs are done.
+// The stats (optional) collects the stats of formatting & outputs.
+//
+// The error is the first one of the packets failed to format
+// (e.g. by a template), which are skipped.
+func outputPackets(ctx *cli.Context, stats *goners.StatsCollector, packets <-chan *goners.Packet) error {
+	var formater goners.PacketsFormater
+	output := func(packets <-chan *goners.Packet) {
+		out := newOutputer(ctx)
+		formater = newFormater(ctx)
+		formatted := goners.FormatPacketsFor(out, formater, packets)
+		if stats != nil {
+			stats.AddFormater(formater)
+			stats.AddOutput(out)
+			formatted = stats.CountFormatted(formatted)
+		}
+		out.Output(formatted)
+	}
+	formatError := func() error {
+		f, ok := formater.(interface {
+			goners.FailingFormater
+			Err() error
+		})
+		if ok && f.Err() != nil {
+			return fmt.Errorf("failed to format %v packets: %w", f.Failed(), f.Err())
+		}
+		return nil
+	}
+
+	f := ctx.String("output-pcap")
+	if f == "" {
+		output(packets)
+		return formatError()
+	}
+
+	// snaplen of the pcap command, or 0 (the default) for the others
+	pcapOut, err := goners.NewRotatingPcapOutputer(f, goners.PcapFileFormatOf(f), ctx.Int("snaplen"), rotateOptions(ctx))
+	if err != nil {
+		log.Fatalf("failed to output into %v: %v", f, err)
+	}
+	if stats != nil {
+		stats.AddOutput(pcapOut)
+	}
+
+	if ctx.String("output") == "" && ctx.String("ws") == "" {
+		// --output-pcap only: save packets quietly, like tcpdump -w
+		pcapOut.OutputPackets(packets)
+		return nil
+	}
+
+	tee := goners.TeePackets(packets, 2)
+	done := make(chan struct{})
+	go func() {
+		pcapOut.OutputPackets(tee[1])
+		close(done)
+	}()
+	output(tee[0])
+	<-done
+	return formatError()
+}
+
+// signalContext is done on SIGINT or SIGTERM, so that the capturing
+// stops and the outputs get flushed. A second signal kills the process.
+func signalContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx
+}
+
+// newOutputer returns the Outputer chosen by the flagsOutput.
+func newOutputer(ctx *cli.Context) goners.Outputer {
+	var out goners.Outputer
+	var err error
+	switch {
+	case ctx.String("output") != "":
+		f := ctx.String("output")
+		if out, err = goners.NewRotatingFileOutputer(f, rotateOptions(ctx)); err != nil {
+			log.Fatalf("failed to output into %v: %v", f, err)
+		}
+	case ctx.String("ws") != "":
+		addr := ctx.String("ws")
+
+		var ws websocket.Handler
+		out, ws = goners.NewWebSocketOutputer()
+
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/", ws)
+			slog.Info("Listen and serve http",
+				"addr", addr, "websocket", "/")
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				log.Fatalf("failed to listen and serve ws: %v", err)
+			}
+		}()
+	default:
+		if out, err = goners.NewFileOutputer("/dev/stdout"); err != nil {
+			log.Fatalf("failed to output into /dev/stdout: %v", err)
+		}
+	}
+	return out
+}
+
 var app = &cli.App{
 	Name:  "goners",
 	Usage: "goner's oafish network explorer & reliable sniffer",
 	Commands: []*cli.Command{
 		commandDevices(),
 		commandPcap(),
+		commandRead(),
 		commandHttp(),
+		commandBpf(),
+		commandFollow(),
+		commandStats(),
 	},
 	Action: func(ctx *cli.Context) error {
 		cli.ShowAppHelp(ctx)
@@ -0,0 +1,345 @@
+package goners
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/ip4defrag"
+	"github.com/google/gopacket/layers"
+)
+
+// IPReassembly annotates the Packets that are part of an IP reassembly
+// (see Defragment).
+type IPReassembly struct {
+	ID     uint32 `json:"id"`     // identification of the datagram
+	Offset int    `json:"offset"` // of the fragment, in bytes
+
+	// Reassembled is true for the Packet reassembled from Fragments
+	// fragments, in place of the last one received.
+	Reassembled bool `json:"reassembled"`
+	Fragments   int  `json:"fragments,omitempty"`
+
+	frame []byte // the last fragment as captured, for Reassembled
+}
+
+func (r IPReassembly) String() string {
+	if r.Reassembled {
+		return fmt.Sprintf("reassembled from %v fragments (ID %v)", r.Fragments, r.ID)
+	}
+	return fmt.Sprintf("fragment (ID %v) at offset %v", r.ID, r.Offset)
+}
+
+// reassemblyOf returns the IPReassembly annotated by Defragment (if any).
+func reassemblyOf(ci gopacket.CaptureInfo) *IPReassembly {
+	for _, data := range ci.AncillaryData {
+		if r, ok := data.(*IPReassembly); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+const (
+	// fragments of an incomplete datagram are forgotten after defragTimeout
+	// (in the time of packets), like ipfrag_time of Linux.
+	defragTimeout = 30 * time.Second
+
+	ipv6MaxFragments = 1024 // of a datagram
+	ipv6MaxPayload   = 65535
+)
+
+// Defragment reassembles the IPv4 & IPv6 fragments read from the source,
+// so that the higher layers are decoded (instead of a Fragment layer).
+//
+// Fragments are read as they are, except the last one of a datagram,
+// whose data is replaced by the reassembled packet for the decoders.
+// Its CaptureInfo (lengths) is kept, and so is its frame for the
+// PacketsOutputers, which write the packets as captured. Fragments
+// are annotated with an IPReassembly (see Packet.Reassembly).
+//
+// Only Ethernet (with VLANs), Linux SLL, Null, Loop and raw IP link types
+// are supported. Other packets are read as they are.
+func Defragment(source PacketSource) PacketSource {
+	return &defragSource{
+		PacketSource: source,
+		v4:           ip4defrag.NewIPv4Defragmenter(),
+		v4Fragments:  make(map[fragKey]*fragCount),
+		v6:           make(map[fragKey]*ipv6Datagram),
+	}
+}
+
+type defragSource struct {
+	PacketSource
+
+	v4          *ip4defrag.IPv4Defragmenter
+	v4Fragments map[fragKey]*fragCount // ip4defrag keeps no count
+	v6          map[fragKey]*ipv6Datagram
+
+	lastDiscard time.Time
+}
+
+// fragKey identifies a datagram.
+type fragKey struct {
+	src, dst netip.Addr
+	id       uint32
+}
+
+type fragCount struct {
+	count int
+	last  time.Time
+}
+
+// ipv6Datagram is the fragments received of an IPv6 datagram.
+type ipv6Datagram struct {
+	fragments  []ipv6Fragment // sorted by offset
+	total      int            // length of the payload: known after the last fragment
+	nextHeader uint8          // of the fragmentable part
+	last       time.Time
+}
+
+type ipv6Fragment struct {
+	offset int
+	data   []byte
+}
+
+func (s *defragSource) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	data, ci, err = s.PacketSource.ReadPacketData()
+	if err != nil {
+		return data, ci, err
+	}
+
+	if ci.Timestamp.Sub(s.lastDiscard) > time.Second {
+		s.discardOlderThan(ci.Timestamp.Add(-defragTimeout))
+		s.lastDiscard = ci.Timestamp
+	}
+
+	off, ok := ipOffset(s.LinkType(), data)
+	if !ok {
+		return data, ci, nil
+	}
+	var reassembled []byte
+	var r *IPReassembly
+	switch data[off] >> 4 {
+	case 4:
+		reassembled, r = s.defragIPv4(data, off, ci.Timestamp)
+	case 6:
+		reassembled, r = s.defragIPv6(data, off, ci.Timestamp)
+	}
+	if r == nil {
+		return data, ci, nil
+	}
+
+	if reassembled != nil {
+		r.frame = data
+		data = reassembled
+	}
+	ci.AncillaryData = append(ci.AncillaryData, r)
+	return data, ci, nil
+}
+
+// defragIPv4 returns the reassembled frame if the datagram is completed
+// by the fragment, and the IPReassembly if data is a fragment.
+func (s *defragSource) defragIPv4(data []byte, off int, ts time.Time) ([]byte, *IPReassembly) {
+	if len(data) < off+20 {
+		return nil, nil
+	}
+	flags := binary.BigEndian.Uint16(data[off+6:])
+	if flags&0x2000 == 0 && flags&0x1fff == 0 { // !MF && offset == 0
+		return nil, nil
+	}
+
+	// ip4defrag keeps the fragments: decode from a copy
+	ip := &layers.IPv4{}
+	if err := ip.DecodeFromBytes(append([]byte(nil), data[off:]...), gopacket.NilDecodeFeedback); err != nil {
+		return nil, nil
+	}
+	src, _ := netip.AddrFromSlice(ip.SrcIP.To4())
+	dst, _ := netip.AddrFromSlice(ip.DstIP.To4())
+	key := fragKey{src, dst, uint32(ip.Id)}
+	r := &IPReassembly{ID: uint32(ip.Id), Offset: int(ip.FragOffset) * 8}
+
+	count, ok := s.v4Fragments[key]
+	if !ok {
+		count = &fragCount{}
+		s.v4Fragments[key] = count
+	}
+	count.count++
+	count.last = ts
+
+	out, err := s.v4.DefragIPv4WithTimestamp(ip, ts)
+	if err != nil || out == nil {
+		if err != nil { // dropped by ip4defrag
+			delete(s.v4Fragments, key)
+		}
+		return nil, r
+	}
+	delete(s.v4Fragments, key)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, out, gopacket.Payload(out.Payload)); err != nil {
+		return nil, r
+	}
+	r.Reassembled, r.Fragments = true, count.count
+	return append(append([]byte(nil), data[:off]...), buf.Bytes()...), r
+}
+
+// defragIPv6 is defragIPv4 for IPv6. Only the Fragment header right after
+// the IPv6 header (i.e. no other extension header in the unfragmentable
+// part) is supported.
+func (s *defragSource) defragIPv6(data []byte, off int, ts time.Time) ([]byte, *IPReassembly) {
+	if len(data) < off+48 || data[off+6] != uint8(layers.IPProtocolIPv6Fragment) {
+		return nil, nil
+	}
+	end := off + 40 + int(binary.BigEndian.Uint16(data[off+4:]))
+	if end > len(data) || end < off+48 {
+		return nil, nil
+	}
+	frag := data[off+40:]
+	fragOffset := int(binary.BigEndian.Uint16(frag[2:]) &^ 0x7)
+	more := frag[3]&0x1 == 1
+	id := binary.BigEndian.Uint32(frag[4:])
+	if fragOffset == 0 && !more { // atomic fragment
+		return nil, nil
+	}
+
+	src, _ := netip.AddrFromSlice(data[off+8 : off+24])
+	dst, _ := netip.AddrFromSlice(data[off+24 : off+40])
+	key := fragKey{src, dst, id}
+	r := &IPReassembly{ID: id, Offset: fragOffset}
+
+	d, ok := s.v6[key]
+	if !ok {
+		d = &ipv6Datagram{total: -1}
+		s.v6[key] = d
+	}
+	d.last = ts
+	payload := append([]byte(nil), data[off+48:end]...)
+	if fragOffset == 0 {
+		d.nextHeader = frag[0]
+	}
+	if !more {
+		d.total = fragOffset + len(payload)
+	}
+	if !d.add(ipv6Fragment{fragOffset, payload}) {
+		delete(s.v6, key) // overlapping or too many: drop the datagram (RFC 5722)
+		return nil, r
+	}
+
+	reassembled, complete := d.reassemble()
+	if !complete {
+		return nil, r
+	}
+	delete(s.v6, key)
+
+	out := make([]byte, 0, off+40+len(reassembled))
+	out = append(out, data[:off+40]...)
+	out = append(out, reassembled...)
+	out[off+6] = d.nextHeader
+	binary.BigEndian.PutUint16(out[off+4:], uint16(len(reassembled)))
+
+	r.Reassembled, r.Fragments = true, len(d.fragments)
+	return out, r
+}
+
+// add inserts the fragment in order. It returns false if the fragment
+// overlaps with others, or the datagram has too many fragments.
+func (d *ipv6Datagram) add(f ipv6Fragment) bool {
+	if len(d.fragments) >= ipv6MaxFragments || f.offset+len(f.data) > ipv6MaxPayload {
+		return false
+	}
+	i := sort.Search(len(d.fragments), func(i int) bool {
+		return d.fragments[i].offset >= f.offset
+	})
+	if i < len(d.fragments) && d.fragments[i].offset == f.offset &&
+		bytes.Equal(d.fragments[i].data, f.data) { // retransmitted
+		return true
+	}
+	if i > 0 {
+		prev := d.fragments[i-1]
+		if prev.offset+len(prev.data) > f.offset {
+			return false
+		}
+	}
+	if i < len(d.fragments) && f.offset+len(f.data) > d.fragments[i].offset {
+		return false
+	}
+	d.fragments = append(d.fragments, ipv6Fragment{})
+	copy(d.fragments[i+1:], d.fragments[i:])
+	d.fragments[i] = f
+	return true
+}
+
+// reassemble returns the payload if all the fragments are received.
+func (d *ipv6Datagram) reassemble() ([]byte, bool) {
+	if d.total < 0 {
+		return nil, false
+	}
+	payload := make([]byte, 0, d.total)
+	for _, f := range d.fragments {
+		if f.offset != len(payload) { // a hole
+			return nil, false
+		}
+		payload = append(payload, f.data...)
+	}
+	return payload, len(payload) == d.total
+}
+
+// discardOlderThan forgets the incomplete datagrams idle since t.
+func (s *defragSource) discardOlderThan(t time.Time) {
+	s.v4.DiscardOlderThan(t)
+	for key, count := range s.v4Fragments {
+		if count.last.Before(t) {
+			delete(s.v4Fragments, key)
+		}
+	}
+	for key, d := range s.v6 {
+		if d.last.Before(t) {
+			delete(s.v6, key)
+		}
+	}
+}
+
+// ipOffset returns the offset of the IP header in the frame.
+func ipOffset(linkType layers.LinkType, data []byte) (int, bool) {
+	isIP := func(off int) bool {
+		etherType := layers.EthernetType(binary.BigEndian.Uint16(data[off-2:]))
+		return etherType == layers.EthernetTypeIPv4 || etherType == layers.EthernetTypeIPv6
+	}
+
+	off := 0
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		off = 14
+		for len(data) >= off {
+			etherType := layers.EthernetType(binary.BigEndian.Uint16(data[off-2:]))
+			if etherType != layers.EthernetTypeDot1Q && etherType != layers.EthernetTypeQinQ {
+				break
+			}
+			off += 4 // VLAN tag
+		}
+		if len(data) < off || !isIP(off) {
+			return 0, false
+		}
+	case layers.LinkTypeLinuxSLL:
+		off = 16
+		if len(data) < off || !isIP(off) {
+			return 0, false
+		}
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		off = 4
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4, layers.LinkTypeIPv6:
+		off = 0
+	default:
+		return 0, false
+	}
+	if len(data) <= off {
+		return 0, false
+	}
+	return off, true
+}
@@ -0,0 +1,226 @@
+package goners
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// craftDNSResponse crafts a (unfragmented) DNS response of n answers,
+// over IPv4 or IPv6.
+func craftDNSResponse(t testing.TB, v6 bool, n int) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02},
+		DstMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	udp := &layers.UDP{SrcPort: 53, DstPort: 40000}
+	var ip gopacket.SerializableLayer
+	if v6 {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP,
+			SrcIP: net.ParseIP("2001:db8::53"), DstIP: net.ParseIP("2001:db8::1")}
+		udp.SetNetworkLayerForChecksum(ip6)
+		ip = ip6
+	} else {
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, Id: 0x1234,
+			SrcIP: net.IP{10, 0, 0, 53}, DstIP: net.IP{10, 0, 0, 1}}
+		udp.SetNetworkLayerForChecksum(ip4)
+		ip = ip4
+	}
+
+	dns := &layers.DNS{ID: 42, QR: true, RD: true, RA: true,
+		Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}}}
+	for i := 0; i < n; i++ {
+		dns.Answers = append(dns.Answers, layers.DNSResourceRecord{
+			Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN,
+			TTL: 300, IP: net.IP{192, 0, 2, byte(i)},
+		})
+	}
+	return serializeLayers(t, eth, ip, udp, dns)
+}
+
+// fragment splits the packet (crafted by craftDNSResponse) into IP
+// fragments of size bytes of payload, in the order.
+func fragment(t testing.TB, packet []byte, v6 bool, size int, order ...int) [][]byte {
+	headerLen := 14 + 20
+	if v6 {
+		headerLen = 14 + 40
+	}
+	header, payload := packet[:headerLen], packet[headerLen:]
+
+	var fragments [][]byte
+	for offset := 0; offset < len(payload); offset += size {
+		end := offset + size
+		more := end < len(payload)
+		if !more {
+			end = len(payload)
+		}
+
+		frag := append([]byte(nil), header...)
+		if v6 {
+			frag[14+6] = uint8(layers.IPProtocolIPv6Fragment)
+			binary.BigEndian.PutUint16(frag[14+4:], uint16(8+end-offset))
+			fh := []byte{uint8(layers.IPProtocolUDP), 0, 0, 0, 0xca, 0xfe, 0xba, 0xbe}
+			flags := uint16(offset)
+			if more {
+				flags |= 1
+			}
+			binary.BigEndian.PutUint16(fh[2:], flags)
+			frag = append(frag, fh...)
+		} else {
+			binary.BigEndian.PutUint16(frag[14+2:], uint16(20+end-offset))
+			flags := uint16(offset / 8)
+			if more {
+				flags |= 0x2000
+			}
+			binary.BigEndian.PutUint16(frag[14+6:], flags)
+			binary.BigEndian.PutUint16(frag[14+10:], 0)
+			binary.BigEndian.PutUint16(frag[14+10:], ipv4Checksum(frag[14:34]))
+		}
+		fragments = append(fragments, append(frag, payload[offset:end]...))
+	}
+
+	ordered := make([][]byte, 0, len(order))
+	for _, i := range order {
+		ordered = append(ordered, fragments[i])
+	}
+	return ordered
+}
+
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+func TestDefragment(t *testing.T) {
+	for _, v6 := range []bool{false, true} {
+		response := craftDNSResponse(t, v6, 100) // ~2.8k bytes
+		fragments := fragment(t, response, v6, 1200, 1, 0, 2)
+
+		source := SyntheticSource{Link: layers.LinkTypeEthernet}
+		for i, data := range append(fragments, craftDNSQueryPacket(t, "example.com")) {
+			source.Packets = append(source.Packets, SyntheticPacket{
+				Data:        data,
+				CaptureInfo: gopacket.CaptureInfo{Timestamp: time.Unix(1678000000, int64(i))},
+			})
+		}
+
+		for _, capture := range []CaptureFunc{CapturePacketsFrom, CapturePacketsFastFrom} {
+			var packets []*Packet
+			for p := range capture(context.Background(), Defragment(mustOpen(t, source))) {
+				packets = append(packets, p)
+			}
+			if len(packets) != 4 {
+				t.Fatalf("❌ v6=%v: got %v packets, want 4", v6, len(packets))
+			}
+
+			for i, offset := range []int{1200, 0} {
+				if r := packets[i].Reassembly; r == nil || r.Reassembled || r.Offset != offset {
+					t.Errorf("❌ v6=%v: fragment %v Reassembly = %+v, want offset %v", v6, i, r, offset)
+				}
+			}
+
+			p := packets[2]
+			if r := p.Reassembly; r == nil || !r.Reassembled || r.Fragments != 3 {
+				t.Errorf("❌ v6=%v: reassembled Reassembly = %+v", v6, r)
+			}
+			if !bytes.Equal(p.Data(), response) {
+				t.Errorf("❌ v6=%v: reassembled data of %v bytes, want %v", v6, len(p.Data()), len(response))
+			}
+			if last := source.Packets[2].Data; p.Length != len(last) || p.CaptureLength != len(last) || !bytes.Equal(p.frame(), last) {
+				t.Errorf("❌ v6=%v: reassembled length = %v, want the last fragment's %v", v6, p.Length, len(last))
+			}
+			if port, ok := p.Field("udp.src_port"); !ok || port != uint64(53) {
+				t.Errorf("❌ v6=%v: reassembled UDP src_port = %v, %v: %v", v6, port, ok, p)
+			}
+			if p.fast == nil { // DNS is decoded by the slow path only
+				if n, _ := p.Field("dns.an_count"); n != uint64(100) {
+					t.Errorf("❌ v6=%v: reassembled dns answers = %v, want 100", v6, n)
+				}
+			}
+
+			reassembled, _ := CompileDisplayFilter("frame.reassembled == true")
+			for i, want := range []bool{false, false, true, false} {
+				if got := reassembled.Match(packets[i]); got != want {
+					t.Errorf("❌ v6=%v: frame.reassembled of packet %v = %v, want %v", v6, i, got, want)
+				}
+			}
+
+			if packets[3].Reassembly != nil {
+				t.Errorf("❌ unfragmented packet is annotated: %+v", packets[3].Reassembly)
+			}
+			for _, p := range packets {
+				p.Release()
+			}
+		}
+	}
+}
+
+func TestDefragment_pcapOutput(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	response := craftDNSResponse(t, false, 100)
+	source := SyntheticSource{Link: layers.LinkTypeEthernet}
+	for _, data := range fragment(t, response, false, 1200, 0, 1, 2) {
+		source.Packets = append(source.Packets, SyntheticPacket{Data: data})
+	}
+
+	file := path.Join(tmpdir, "out.pcap")
+	o, err := NewPcapOutputer(file, PcapFormat, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.OutputPackets(CapturePacketsFrom(context.Background(), Defragment(mustOpen(t, source))))
+
+	// written as captured: the fragments, not the reassembled packet
+	h, err := openFileHandle(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	for i, want := range source.Packets {
+		data, ci, err := h.ReadPacketData()
+		if err != nil {
+			t.Fatalf("❌ read packet %v: %v", i, err)
+		}
+		if !bytes.Equal(data, want.Data) || ci.Length != len(want.Data) {
+			t.Errorf("❌ packet %v: got %v bytes (frame.len %v), want the fragment of %v bytes", i, len(data), ci.Length, len(want.Data))
+		}
+	}
+}
+
+func TestDefragment_overlap(t *testing.T) {
+	response := craftDNSResponse(t, true, 100)
+	fragments := fragment(t, response, true, 1200, 0, 1)
+	overlapping := fragment(t, response, true, 800, 2)[0] // [1600, 2400)
+	fragments = append(fragments, overlapping)
+
+	source := SyntheticSource{Link: layers.LinkTypeEthernet}
+	for _, data := range fragments {
+		source.Packets = append(source.Packets, SyntheticPacket{Data: data})
+	}
+	for p := range CapturePacketsFrom(context.Background(), Defragment(mustOpen(t, source))) {
+		if p.Reassembly == nil || p.Reassembly.Reassembled {
+			t.Errorf("❌ Reassembly = %+v, want an unreassembled fragment", p.Reassembly)
+		}
+	}
+}
@@ -0,0 +1,759 @@
+package goners
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DisplayFilter is a Wireshark style display filter: unlike the BPF filter,
+// it's evaluated on the decoded Packets, so it works on any layer, on live
+// captures and on saved files alike.
+//
+//	tcp.dstport == 443 && ip.src in 10.0.0.0/8 && frame.len > 1000
+//	dns.qry.name contains "example"
+//	tcp.port in {80 443 8080} or !(udp)
+//
+// Fields are referenced by the field paths of the layers (e.g. tcp.dst_port,
+// dns.questions.name, see Packet.Field) or by the common Wireshark names
+// (tcp.dstport, dns.qry.name, ...; see displayFilterAliases). A protocol or
+// field alone tests its presence.
+//
+// Operators: == != > < >= <= (or eq ne gt lt ge le), contains, matches
+// (case-insensitive regexp), in {set} or in CIDR; && || ! (or and or not)
+// and parentheses.
+//
+// A field may have multiple values (e.g. ip.addr, dns.qry.name): a comparison
+// is true if any of the values matches, except != which is true if all of
+// them differ. Comparisons on absent fields are false.
+type DisplayFilter struct {
+	expr string
+	root dfNode
+}
+
+// CompileDisplayFilter parses the display filter expression.
+func CompileDisplayFilter(expr string) (*DisplayFilter, error) {
+	tokens, err := lexDisplayFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("display filter: empty expression")
+	}
+
+	p := &dfParser{expr: expr, tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != dfEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.text)
+	}
+
+	return &DisplayFilter{expr: expr, root: root}, nil
+}
+
+// Match reports whether the packet passes the filter.
+func (f *DisplayFilter) Match(p *Packet) bool {
+	return f.root.match(p)
+}
+
+func (f *DisplayFilter) String() string {
+	return f.expr
+}
+
+// FilterPackets forwards the packets that pass the display filter,
+// the others are dropped (and Released).
+func FilterPackets(in <-chan *Packet, filter *DisplayFilter) chan *Packet {
+	out := make(chan *Packet, ChanBufSize)
+	go func() {
+		defer close(out)
+		for p := range in {
+			if !filter.Match(p) {
+				p.Release()
+				continue
+			}
+			out <- p
+		}
+	}()
+	return out
+}
+
+// displayFilterAliases maps Wireshark field names to the field paths
+// of the layers (any of them).
+var displayFilterAliases = map[string][]string{
+	"eth.src":  {"eth.src_mac"},
+	"eth.dst":  {"eth.dst_mac"},
+	"eth.addr": {"eth.src_mac", "eth.dst_mac"},
+	"eth.type": {"eth.ethernet_type"},
+
+	"ip.src":     {"ip.src_ip"},
+	"ip.dst":     {"ip.dst_ip"},
+	"ip.addr":    {"ip.src_ip", "ip.dst_ip"},
+	"ip.proto":   {"ip.protocol"},
+	"ip.len":     {"ip.length"},
+	"ip.hdr_len": {"ip.ihl"},
+
+	"ipv6.src":  {"ipv6.src_ip"},
+	"ipv6.dst":  {"ipv6.dst_ip"},
+	"ipv6.addr": {"ipv6.src_ip", "ipv6.dst_ip"},
+	"ipv6.nxt":  {"ipv6.next_header"},
+	"ipv6.hlim": {"ipv6.hop_limit"},
+	"ipv6.plen": {"ipv6.length"},
+
+	"tcp.srcport":     {"tcp.src_port"},
+	"tcp.dstport":     {"tcp.dst_port"},
+	"tcp.port":        {"tcp.src_port", "tcp.dst_port"},
+	"tcp.ack":         {"tcp.ack_num"},
+	"tcp.window_size": {"tcp.window"},
+	"tcp.flags.fin":   {"tcp.fin"},
+	"tcp.flags.syn":   {"tcp.syn"},
+	"tcp.flags.reset": {"tcp.rst"},
+	"tcp.flags.push":  {"tcp.psh"},
+	"tcp.flags.ack":   {"tcp.ack"},
+	"tcp.flags.urg":   {"tcp.urg"},
+	"tcp.flags.ece":   {"tcp.ece"},
+	"tcp.flags.cwr":   {"tcp.cwr"},
+	"tcp.flags.ns":    {"tcp.ns"},
+
+	"udp.srcport": {"udp.src_port"},
+	"udp.dstport": {"udp.dst_port"},
+	"udp.port":    {"udp.src_port", "udp.dst_port"},
+
+	"dns.qry.name":       {"dns.questions.name"},
+	"dns.qry.type":       {"dns.questions.type"},
+	"dns.resp.name":      {"dns.answers.name"},
+	"dns.resp.type":      {"dns.answers.type"},
+	"dns.a":              {"dns.answers.ip"},
+	"dns.aaaa":           {"dns.answers.ip"},
+	"dns.cname":          {"dns.answers.cname"},
+	"dns.flags.response": {"dns.qr"},
+	"dns.flags.rcode":    {"dns.response_code"},
+}
+
+// displayFilterFields are the fields computed from the Packet
+// instead of the field trees of the layers.
+var displayFilterFields = map[string]func(p *Packet) []any{
+	"frame.len":            func(p *Packet) []any { return []any{int64(p.Length)} },
+	"frame.cap_len":        func(p *Packet) []any { return []any{int64(p.CaptureLength)} },
+	"frame.time":           func(p *Packet) []any { return []any{p.Timestamp.Format(time.RFC3339Nano)} },
+	"frame.time_epoch":     func(p *Packet) []any { return []any{float64(p.Timestamp.UnixNano()) / 1e9} },
+	"frame.interface_id":   func(p *Packet) []any { return []any{int64(p.DeviceIndex)} },
+	"frame.interface_name": func(p *Packet) []any { return []any{p.Device} },
+	"frame.reassembled":    reassembled,
+	"tcp.len":              payloadLen("tcp"),
+	"udp.len":              payloadLen("udp"),
+	"icmp.type":            icmpTypeCode("icmp", 8),
+	"icmp.code":            icmpTypeCode("icmp", 0),
+	"icmpv6.type":          icmpTypeCode("icmpv6", 8),
+	"icmpv6.code":          icmpTypeCode("icmpv6", 0),
+}
+
+// reassembled is true for packets reassembled from IP fragments,
+// false for the fragments, and absent for the others.
+func reassembled(p *Packet) []any {
+	if p.Reassembly == nil {
+		return nil
+	}
+	return []any{p.Reassembly.Reassembled}
+}
+
+func payloadLen(abbr string) func(p *Packet) []any {
+	return func(p *Packet) []any {
+		var values []any
+		for _, l := range p.Layers {
+			if l.Abbr() == abbr {
+				values = append(values, int64(len(l.Payload)))
+			}
+		}
+		return values
+	}
+}
+
+// icmpTypeCode returns the type (shift 8) or code (shift 0) of the type_code.
+func icmpTypeCode(abbr string, shift int) func(p *Packet) []any {
+	return func(p *Packet) []any {
+		var values []any
+		for _, l := range p.Layers {
+			if l.Abbr() != abbr {
+				continue
+			}
+			if tc, ok := l.Fields()["type_code"].(uint64); ok {
+				values = append(values, (tc>>shift)&0xff)
+			}
+		}
+		return values
+	}
+}
+
+// dfField is a field reference: a protocol (e.g. "tcp"),
+// a field path (e.g. "tcp.dst_port") or an alias (e.g. "tcp.dstport").
+type dfField struct {
+	name string
+}
+
+// values returns the values of the field in the packet, nil if absent.
+func (f dfField) values(p *Packet) []any {
+	if computed, ok := displayFilterFields[f.name]; ok {
+		return computed(p)
+	}
+	paths, ok := displayFilterAliases[f.name]
+	if !ok {
+		paths = []string{f.name}
+	}
+
+	var values []any
+	for _, path := range paths {
+		abbr, rest, _ := strings.Cut(path, ".")
+		for _, l := range p.Layers {
+			if l.Abbr() != abbr {
+				continue
+			}
+			switch rest {
+			case "":
+				values = append(values, true) // the protocol
+			case "payload":
+				values = append(values, string(l.Payload))
+			default:
+				values = collectFieldValues(values, l.Fields(), strings.Split(rest, "."))
+			}
+		}
+	}
+	return values
+}
+
+// collectFieldValues appends the values at the path parts of the value.
+// Arrays without indexes in the path are walked through:
+// "questions.name" is the names of all the questions.
+func collectFieldValues(values []any, value any, parts []string) []any {
+	if len(parts) == 0 {
+		if array, ok := value.([]any); ok {
+			return append(values, array...)
+		}
+		return append(values, value)
+	}
+
+	switch v := value.(type) {
+	case []any:
+		for _, elem := range v {
+			values = collectFieldValues(values, elem, parts)
+		}
+	case Fields:
+		name, indexes, ok := parseFieldPathPart(parts[0])
+		if !ok {
+			return values
+		}
+		child, ok := v[name]
+		if !ok {
+			return values
+		}
+		for _, i := range indexes {
+			array, isArray := child.([]any)
+			if !isArray || i < 0 || i >= len(array) {
+				return values
+			}
+			child = array[i]
+		}
+		values = collectFieldValues(values, child, parts[1:])
+	}
+	return values
+}
+
+// dfNode is a node of the parsed display filter.
+type dfNode interface {
+	match(p *Packet) bool
+}
+
+type dfAnd struct{ left, right dfNode }
+
+func (n dfAnd) match(p *Packet) bool { return n.left.match(p) && n.right.match(p) }
+
+type dfOr struct{ left, right dfNode }
+
+func (n dfOr) match(p *Packet) bool { return n.left.match(p) || n.right.match(p) }
+
+type dfNot struct{ node dfNode }
+
+func (n dfNot) match(p *Packet) bool { return !n.node.match(p) }
+
+// dfExists tests the presence of a protocol or field.
+type dfExists struct{ field dfField }
+
+func (n dfExists) match(p *Packet) bool { return len(n.field.values(p)) > 0 }
+
+// dfCompare compares the values of a field with the literal(s).
+type dfCompare struct {
+	field  dfField
+	op     string
+	values []dfValue // 1 value, or the set of "in"
+}
+
+func (n dfCompare) match(p *Packet) bool {
+	values := n.field.values(p)
+	if len(values) == 0 {
+		return false
+	}
+
+	if n.op == "!=" { // all of them differ
+		for _, v := range values {
+			if n.values[0].equal(v) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, v := range values {
+		for _, lit := range n.values {
+			if lit.test(n.op, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dfValue is a literal: number, string, bool, IP, CIDR or regexp.
+type dfValue struct {
+	text   string
+	number float64
+	isNum  bool
+	isBool bool
+	b      bool
+	ip     net.IP
+	ipNet  *net.IPNet
+	mac    net.HardwareAddr
+	re     *regexp.Regexp // for matches
+}
+
+// newDfValue parses the literal. Quoted strings are always strings.
+func newDfValue(text string, quoted bool) dfValue {
+	v := dfValue{text: text}
+	if quoted {
+		return v
+	}
+
+	if i, err := strconv.ParseInt(text, 0, 64); err == nil {
+		v.number, v.isNum = float64(i), true
+	} else if u, err := strconv.ParseUint(text, 0, 64); err == nil {
+		v.number, v.isNum = float64(u), true
+	} else if f, err := strconv.ParseFloat(text, 64); err == nil && !math.IsNaN(f) {
+		v.number, v.isNum = f, true
+	}
+
+	switch text {
+	case "true":
+		v.isBool, v.b = true, true
+	case "false":
+		v.isBool, v.b = true, false
+	}
+
+	if ip := net.ParseIP(text); ip != nil {
+		v.ip = ip
+	} else if _, ipNet, err := net.ParseCIDR(text); err == nil {
+		v.ipNet = ipNet
+	} else if mac, err := net.ParseMAC(text); err == nil {
+		v.mac = mac
+	}
+	return v
+}
+
+func (lit dfValue) test(op string, v any) bool {
+	switch op {
+	case "contains":
+		s, ok := v.(string)
+		return ok && strings.Contains(s, lit.text)
+	case "matches":
+		s, ok := v.(string)
+		return ok && lit.re.MatchString(s)
+	case "==":
+		return lit.equal(v)
+	}
+
+	c, ok := lit.compare(v)
+	if !ok {
+		return false
+	}
+	switch op {
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+// equal reports whether the value v equals to (or is in the CIDR of) the literal.
+func (lit dfValue) equal(v any) bool {
+	if lit.ipNet != nil {
+		s, ok := v.(string)
+		ip := net.ParseIP(s)
+		return ok && ip != nil && lit.ipNet.Contains(ip)
+	}
+	if lit.isBool {
+		if b, ok := v.(bool); ok {
+			return b == lit.b
+		}
+	}
+	c, ok := lit.compare(v)
+	return ok && c == 0
+}
+
+// compare returns the order of the value v to the literal: -1, 0 or 1.
+// ok is false if they are not comparable.
+func (lit dfValue) compare(v any) (c int, ok bool) {
+	switch v := v.(type) {
+	case int64:
+		return compareNumber(float64(v), lit)
+	case uint64:
+		return compareNumber(float64(v), lit)
+	case float64:
+		return compareNumber(v, lit)
+	case bool: // flags: tcp.flags.syn == 1
+		n := 0.0
+		if v {
+			n = 1
+		}
+		return compareNumber(n, lit)
+	case string:
+		switch {
+		case lit.ip != nil:
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return 0, false
+			}
+			if ip4 := ip.To4(); ip4 != nil && lit.ip.To4() != nil {
+				return bytes.Compare(ip4, lit.ip.To4()), true
+			}
+			return bytes.Compare(ip.To16(), lit.ip.To16()), true
+		case lit.mac != nil:
+			mac, err := net.ParseMAC(v)
+			if err != nil {
+				return 0, false
+			}
+			return bytes.Compare(mac, lit.mac), true
+		}
+		return strings.Compare(v, lit.text), true
+	}
+	return 0, false
+}
+
+func compareNumber(n float64, lit dfValue) (int, bool) {
+	if !lit.isNum {
+		return 0, false
+	}
+	switch {
+	case n < lit.number:
+		return -1, true
+	case n > lit.number:
+		return 1, true
+	}
+	return 0, true
+}
+
+// dfOperators are the comparison operators, with their word forms.
+var dfOperators = map[string]string{
+	"==": "==", "eq": "==",
+	"!=": "!=", "ne": "!=",
+	">": ">", "gt": ">",
+	"<": "<", "lt": "<",
+	">=": ">=", "ge": ">=",
+	"<=": "<=", "le": "<=",
+	"contains": "contains",
+	"matches":  "matches", "~": "matches",
+	"in": "in",
+}
+
+type dfTokenKind int
+
+const (
+	dfEOF    dfTokenKind = iota
+	dfWord               // field, literal or keyword
+	dfString             // "quoted"
+	dfPunct              // ( ) { } , && || ! and the operators
+)
+
+type dfToken struct {
+	kind dfTokenKind
+	text string // unquoted for dfString
+	pos  int    // offset in the expression
+}
+
+// lexDisplayFilter splits the expression into tokens.
+func lexDisplayFilter(expr string) ([]dfToken, error) {
+	var tokens []dfToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("display filter: unterminated string at offset %d", i)
+			}
+			s, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("display filter: bad string at offset %d: %w", i, err)
+			}
+			tokens = append(tokens, dfToken{kind: dfString, text: s, pos: i})
+			i = end + 1
+		case strings.ContainsRune("(){},", rune(c)):
+			tokens = append(tokens, dfToken{kind: dfPunct, text: string(c), pos: i})
+			i++
+		case strings.ContainsRune("=!<>&|~", rune(c)):
+			op := string(c)
+			if i+1 < len(expr) {
+				if two := expr[i : i+2]; two == "==" || two == "!=" || two == ">=" ||
+					two == "<=" || two == "&&" || two == "||" {
+					op = two
+				}
+			}
+			if op == "=" || op == "&" || op == "|" {
+				return nil, fmt.Errorf("display filter: unexpected %q at offset %d", op, i)
+			}
+			tokens = append(tokens, dfToken{kind: dfPunct, text: op, pos: i})
+			i += len(op)
+		case isDfWordChar(rune(c)):
+			end := i
+			for end < len(expr) && isDfWordChar(rune(expr[end])) {
+				end++
+			}
+			tokens = append(tokens, dfToken{kind: dfWord, text: expr[i:end], pos: i})
+			i = end
+		default:
+			return nil, fmt.Errorf("display filter: unexpected %q at offset %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+// isDfWordChar reports whether r is a char of fields, numbers, IPs, CIDRs
+// and MACs: tcp.options[0].kind, 0x0800, 10.0.0.0/8, fe80::1, 00:00:5e:00:53:01.
+func isDfWordChar(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) ||
+		strings.ContainsRune("_.:/-[]", r))
+}
+
+// dfParser is a recursive descent parser:
+//
+//	or      = and { ("||" | "or") and }
+//	and     = not { ("&&" | "and") not }
+//	not     = ("!" | "not") not | primary
+//	primary = "(" or ")" | field [ op value | "in" set ]
+type dfParser struct {
+	expr   string
+	tokens []dfToken
+	i      int
+}
+
+func (p *dfParser) peek() dfToken {
+	if p.i >= len(p.tokens) {
+		return dfToken{kind: dfEOF, pos: len(p.expr)}
+	}
+	return p.tokens[p.i]
+}
+
+func (p *dfParser) next() dfToken {
+	tok := p.peek()
+	if tok.kind != dfEOF {
+		p.i++
+	}
+	return tok
+}
+
+// accept consumes the next token if it's one of the texts.
+func (p *dfParser) accept(texts ...string) bool {
+	tok := p.peek()
+	if tok.kind != dfWord && tok.kind != dfPunct {
+		return false
+	}
+	for _, text := range texts {
+		if tok.text == text {
+			p.i++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *dfParser) errorf(tok dfToken, format string, args ...any) error {
+	if tok.kind == dfEOF {
+		return fmt.Errorf("display filter: "+format+" at the end", args...)
+	}
+	return fmt.Errorf("display filter: "+format+" at offset %d", append(args, tok.pos)...)
+}
+
+func (p *dfParser) parseOr() (dfNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||", "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = dfOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *dfParser) parseAnd() (dfNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&", "and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = dfAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *dfParser) parseNot() (dfNode, error) {
+	if p.accept("!", "not") {
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return dfNot{node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *dfParser) parsePrimary() (dfNode, error) {
+	if p.accept("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			tok := p.peek()
+			return nil, p.errorf(tok, "missing \")\"")
+		}
+		return node, nil
+	}
+
+	tok := p.next()
+	if tok.kind != dfWord || isDfKeyword(tok.text) || !isDfFieldName(tok.text) {
+		if tok.kind == dfEOF {
+			return nil, p.errorf(tok, "missing field")
+		}
+		return nil, p.errorf(tok, "%q is not a field", tok.text)
+	}
+	field := dfField{name: tok.text}
+
+	opTok := p.peek()
+	op, isOp := dfOperators[opTok.text]
+	if !isOp || opTok.kind == dfString {
+		return dfExists{field: field}, nil
+	}
+	p.next()
+
+	if op == "in" {
+		values, err := p.parseSet()
+		if err != nil {
+			return nil, err
+		}
+		return dfCompare{field: field, op: "==", values: values}, nil
+	}
+
+	value, err := p.parseValue(op)
+	if err != nil {
+		return nil, err
+	}
+	return dfCompare{field: field, op: op, values: []dfValue{value}}, nil
+}
+
+// parseSet parses the {v1 v2, ...} (commas optional) or a single CIDR.
+func (p *dfParser) parseSet() ([]dfValue, error) {
+	if !p.accept("{") {
+		tok := p.peek()
+		value, err := p.parseValue("in")
+		if err != nil {
+			return nil, err
+		}
+		if value.ipNet == nil {
+			return nil, p.errorf(tok, "\"in\" expects a set {...} or a CIDR, got %q", value.text)
+		}
+		return []dfValue{value}, nil
+	}
+
+	var values []dfValue
+	for !p.accept("}") {
+		if p.accept(",") {
+			continue
+		}
+		value, err := p.parseValue("in")
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return nil, p.errorf(p.tokens[p.i-1], "empty set")
+	}
+	return values, nil
+}
+
+func (p *dfParser) parseValue(op string) (dfValue, error) {
+	tok := p.next()
+	if tok.kind != dfWord && tok.kind != dfString || isDfKeyword(tok.text) && tok.kind == dfWord {
+		if tok.kind == dfEOF {
+			return dfValue{}, p.errorf(tok, "missing value after %q", op)
+		}
+		return dfValue{}, p.errorf(tok, "unexpected %q after %q", tok.text, op)
+	}
+
+	value := newDfValue(tok.text, tok.kind == dfString)
+	if op == "matches" {
+		re, err := regexp.Compile("(?i)" + tok.text)
+		if err != nil {
+			return dfValue{}, p.errorf(tok, "bad regexp %q: %v", tok.text, err)
+		}
+		value.re = re
+	}
+	return value, nil
+}
+
+// isDfKeyword reports whether the word is a logical or comparison keyword.
+func isDfKeyword(word string) bool {
+	switch word {
+	case "and", "or", "not":
+		return true
+	}
+	_, ok := dfOperators[word]
+	return ok
+}
+
+// isDfFieldName reports whether the word looks like a field: "tcp",
+// "tcp.dst_port", "dns.questions[0].name". Numbers & addresses are not.
+func isDfFieldName(word string) bool {
+	if word == "" || !unicode.IsLetter(rune(word[0])) {
+		return false
+	}
+	if _, err := net.ParseMAC(word); err == nil || net.ParseIP(word) != nil {
+		return false // e.g. fe80::1, de:ad:be:ef:00:01
+	}
+	return !strings.ContainsAny(word, ":/")
+}
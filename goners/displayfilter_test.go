@@ -0,0 +1,142 @@
+package goners
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestDisplayFilter_Match(t *testing.T) {
+	decode := func(data []byte) *Packet {
+		packet := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+		packet.Metadata().Length = len(data)
+		return NewPacket(packet)
+	}
+	tcp := decode(craftTCPPacket(t, 40000, 443, bytes.Repeat([]byte("GET / "), 200)))
+	dns := decode(craftDNSQueryPacket(t, "www.example.com"))
+	udp6 := decode(craftUDPPacket(t, true, []byte("hello")))
+	icmp := decode(craftICMPPacket(t, 1, 1, "ping"))
+
+	tests := []struct {
+		expr   string
+		packet *Packet
+		want   bool
+	}{
+		{"tcp", tcp, true},
+		{"udp", tcp, false},
+		{"!udp", tcp, true},
+		{"not tcp", tcp, false},
+		{"tcp.dstport == 443 && ip.src in 10.0.0.0/8 && frame.len > 1000", tcp, true},
+		{"tcp.dstport == 443 && ip.src in 10.0.0.0/8 && frame.len > 2000", tcp, false},
+		{"tcp.dst_port eq 443 and ip.src_ip == 10.0.0.1", tcp, true},
+		{"ip.src == 10.0.0.0/8", tcp, true},
+		{"ip.src in 192.168.0.0/16", tcp, false},
+		{"ip.addr == 10.0.0.2", tcp, true},
+		{"ip.addr != 10.0.0.2", tcp, false},
+		{"ip.addr != 10.0.0.3", tcp, true},
+		{"ip.src > 10.0.0.0 && ip.src < 10.0.0.255", tcp, true},
+		{"tcp.port in {80 443 8080}", tcp, true},
+		{"tcp.port in {80, 8080}", tcp, false},
+		{"tcp.flags.push == 1 && tcp.flags.syn == 0", tcp, true},
+		{"tcp.flags.ack == true", tcp, true},
+		{"tcp.ack == 0 && tcp.seq == 1", tcp, true},
+		{"tcp.len == 1200", tcp, true},
+		{"tcp.payload contains \"GET /\"", tcp, true},
+		{"tcp.payload matches \"^get\"", tcp, true},
+		{"eth.src == 00:00:5e:00:53:01", tcp, true},
+		{"eth.type == 0x0800", tcp, true},
+		{"frame.len >= 1254 || udp", tcp, true},
+		{"(udp || dns) && frame.len > 0", tcp, false},
+		{"nope.field == 1", tcp, false},
+		{"nope.field != 1", tcp, false},
+
+		{"dns.qry.name contains \"example\"", dns, true},
+		{"dns.qry.name == \"www.example.com\"", dns, true},
+		{"dns.qry.name matches \"EXAMPLE\\\\.(com|org)$\"", dns, true},
+		{"dns.questions[0].name contains \"nope\"", dns, false},
+		{"dns && udp.dstport == 53 && dns.flags.response == 0", dns, true},
+		{"!dns.flags.response", dns, false}, // presence, like Wireshark
+		{"dns.flags.response == false", dns, true},
+
+		{"ipv6.src == 2001:db8::1 && udp.port == 9999", udp6, true},
+		{"ipv6.addr in 2001:db8::/32", udp6, true},
+		{"ip", udp6, false},
+
+		{"icmp.type == 8 && icmp.code == 0", icmp, true},
+		{"icmp.type == 0", icmp, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := CompileDisplayFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("❌ CompileDisplayFilter(%q) error = %v", tt.expr, err)
+			}
+			if got := f.Match(tt.packet); got != tt.want {
+				t.Errorf("❌ Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileDisplayFilter_errors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{"", "empty expression"},
+		{"tcp.port ==", "missing value after \"==\" at the end"},
+		{"(tcp", "missing \")\" at the end"},
+		{"tcp udp", "unexpected \"udp\" at offset 4"},
+		{"tcp.port = 80", "unexpected \"=\" at offset 9"},
+		{"ip.src in 10.0.0.1", "\"in\" expects a set {...} or a CIDR"},
+		{"tcp.port in {}", "empty set"},
+		{"dns.qry.name contains \"example", "unterminated string at offset 22"},
+		{"dns.qry.name matches \"(\"", "bad regexp"},
+		{"443 == tcp.port", "\"443\" is not a field at offset 0"},
+		{"tcp && ", "missing field at the end"},
+		{"tcp.port == $1", "unexpected '$' at offset 12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := CompileDisplayFilter(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("❌ CompileDisplayFilter(%q) error = %v, want %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterPackets(t *testing.T) {
+	source := SyntheticSource{Link: layers.LinkTypeEthernet}
+	for i := 0; i < 10; i++ {
+		dstPort := uint16(443)
+		if i%2 == 0 {
+			dstPort = 80
+		}
+		source.Packets = append(source.Packets, SyntheticPacket{
+			Data: craftTCPPacket(t, 40000, dstPort, []byte("hello")),
+		})
+	}
+
+	filter, err := CompileDisplayFilter("tcp.dstport == 80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, capture := range []CaptureFunc{CapturePacketsFrom, CapturePacketsFastFrom} {
+		count := 0
+		for p := range FilterPackets(capture(context.Background(), mustOpen(t, source)), filter) {
+			if port, _ := p.Field("tcp.dst_port"); port != uint64(80) {
+				t.Errorf("❌ got packet to port %v", port)
+			}
+			p.Release()
+			count++
+		}
+		if count != 5 {
+			t.Errorf("❌ got %v packets, want 5", count)
+		}
+	}
+}
@@ -2,7 +2,7 @@
 //
 // - [x] devices 获取设备列表
 // - [ ] capture 实时抓取数据包
-// - [ ] file 从保存的 pcap 文件中读取数据包
+// - [x] file 从保存的 pcap 文件中读取数据包
 // - [ ] filter BPF 过滤
 // ...
 package goners
@@ -0,0 +1,173 @@
+package goners
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+)
+
+// FieldsOptions configures the FieldsPacketsFormater.
+type FieldsOptions struct {
+	// Fields are the fields of the columns: the display filter fields
+	// (e.g. "ip.src", "tcp.dstport", "frame.len") or the field paths of
+	// the layers (e.g. "dns.questions.name"), plus "frame.number".
+	Fields []string `json:"fields"`
+
+	// TSV separates the columns with tabs instead of commas (CSV).
+	TSV bool `json:"tsv"`
+	// NoHeader omits the header line (the field names).
+	NoHeader bool `json:"no_header"`
+}
+
+// fieldAggregator joins the values of a field with multiple values
+// (e.g. ip.addr, dns.qry.name) in a column, like tshark.
+const fieldAggregator = ","
+
+// NewFieldsPacketsFormater formats each packet into a CSV (or TSV) row
+// of the fields, like tshark -T fields -E header=y. The header is not
+// one of the rows: it is written at the head of each output file and
+// to each WebSocket client (see HeaderFormater & FormatPacketsFor).
+//
+// Absent fields are empty. A field with multiple values gets them
+// joined with ",", quoted as CSV does.
+func NewFieldsPacketsFormater(opts FieldsOptions) (HeaderFormater, error) {
+	if len(opts.Fields) == 0 {
+		return nil, fmt.Errorf("fields: no fields")
+	}
+	for _, name := range opts.Fields {
+		if err := validateExportField(name); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := make([]dfField, len(opts.Fields))
+	for i, name := range opts.Fields {
+		fields[i] = dfField{name: name}
+	}
+	f := &fieldsPacketsFormater{opts: opts, fields: fields}
+	if !opts.NoHeader {
+		f.header = newCsvRower(opts.TSV).row(opts.Fields)
+	}
+	return f, nil
+}
+
+type fieldsPacketsFormater struct {
+	opts   FieldsOptions
+	fields []dfField
+	header []byte // nil for NoHeader
+}
+
+func (f *fieldsPacketsFormater) Header() []byte {
+	return f.header
+}
+
+func (f *fieldsPacketsFormater) FormatPackets(in <-chan *Packet) <-chan []byte {
+	out := make(chan []byte, ChanBufSize)
+	go func() {
+		defer close(out)
+
+		rower := newCsvRower(f.opts.TSV)
+		record := make([]string, len(f.fields))
+		var number int64
+		for p := range in {
+			number++
+			for i, field := range f.fields {
+				if field.name == "frame.number" {
+					record[i] = strconv.FormatInt(number, 10)
+					continue
+				}
+				record[i] = exportFieldValues(field.values(p))
+			}
+			p.Release()
+			out <- rower.row(record)
+		}
+	}()
+	return out
+}
+
+// csvRower formats records into CSV (or TSV) lines.
+type csvRower struct {
+	buf bytes.Buffer
+	w   *csv.Writer
+}
+
+func newCsvRower(tsv bool) *csvRower {
+	r := &csvRower{}
+	r.w = csv.NewWriter(&r.buf)
+	if tsv {
+		r.w.Comma = '\t'
+	}
+	return r
+}
+
+// row returns the line of the record, without the trailing newline.
+func (r *csvRower) row(record []string) []byte {
+	r.buf.Reset()
+	r.w.Write(record)
+	r.w.Flush()
+	line := bytes.TrimSuffix(r.buf.Bytes(), []byte("\n"))
+	return append([]byte(nil), line...) // buf is reused
+}
+
+// validateExportField checks the name is a field (or protocol) name
+// as in the display filters: a field computed from the packet, an
+// alias, or a path in a layer of a known type (e.g. "dns.questions.name").
+func validateExportField(name string) error {
+	f, err := CompileDisplayFilter(name)
+	if err != nil {
+		return fmt.Errorf("fields: bad field %q: %w", name, err)
+	}
+	if _, ok := f.root.(dfExists); !ok {
+		return fmt.Errorf("fields: bad field %q: not a field", name)
+	}
+
+	if name == "frame.number" {
+		return nil
+	}
+	if _, ok := displayFilterFields[name]; ok {
+		return nil
+	}
+	if _, ok := displayFilterAliases[name]; ok {
+		return nil
+	}
+	abbr, _, _ := strings.Cut(name, ".")
+	if !isLayerAbbr(abbr) {
+		return fmt.Errorf("fields: bad field %q: unknown protocol %q", name, abbr)
+	}
+	return nil
+}
+
+// isLayerAbbr reports whether abbr is the LayerAbbr of a layer type
+// registered to gopacket.
+func isLayerAbbr(abbr string) bool {
+	for name := range gopacket.DecodersByLayerName {
+		if LayerAbbr(name) == abbr {
+			return true
+		}
+	}
+	return false
+}
+
+// exportFieldValues joins the values of a field into a column.
+func exportFieldValues(values []any) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case float64:
+			s[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool: // tshark prints 1 or 0
+			if v {
+				s[i] = "1"
+			} else {
+				s[i] = "0"
+			}
+		default:
+			s[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(s, fieldAggregator)
+}
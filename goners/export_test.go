@@ -0,0 +1,73 @@
+package goners
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestNewFieldsPacketsFormater(t *testing.T) {
+	ts := time.Date(2023, 3, 5, 15, 4, 5, 500000000, time.UTC)
+	var packets []SyntheticPacket
+	for _, data := range [][]byte{
+		craftDNSQueryPacket(t, "example.com"),
+		craftTCPPacket(t, 40000, 443, []byte("hello")),
+	} {
+		packets = append(packets, SyntheticPacket{
+			Data: data,
+			CaptureInfo: gopacket.CaptureInfo{
+				Timestamp:     ts,
+				CaptureLength: len(data),
+				Length:        len(data),
+			},
+		})
+	}
+	source := SyntheticSource{Link: layers.LinkTypeEthernet, Packets: packets}
+
+	tests := []struct {
+		name string
+		opts FieldsOptions
+		want []string
+	}{
+		{"csv", FieldsOptions{Fields: []string{"frame.number", "frame.time", "ip.src", "ip.dst", "tcp.dstport", "frame.len"}}, []string{
+			"frame.number,frame.time,ip.src,ip.dst,tcp.dstport,frame.len",
+			"1,2023-03-05T15:04:05.5Z,10.0.0.1,10.0.0.53,,71",
+			"2,2023-03-05T15:04:05.5Z,10.0.0.1,10.0.0.2,443,60",
+		}},
+		{"tsv", FieldsOptions{Fields: []string{"ip.addr", "dns.qry.name", "udp"}, TSV: true}, []string{
+			"ip.addr\tdns.qry.name\tudp",
+			"10.0.0.1,10.0.0.53\texample.com\t1",
+			"10.0.0.1,10.0.0.2\t\t",
+		}},
+		{"quote multiple values", FieldsOptions{Fields: []string{"tcp.port", "frame.time_epoch"}, NoHeader: true}, []string{
+			",1678028645.5",
+			`"40000,443",1678028645.5`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formater, err := NewFieldsPacketsFormater(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lines []string
+			packets := CapturePacketsFrom(context.Background(), mustOpen(t, source))
+			for line := range FormatPacketsFor(nil, formater, packets) { // header first
+				lines = append(lines, string(line))
+			}
+			if got, want := strings.Join(lines, "\n"), strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("❌ got:\n%v\nwant:\n%v", got, want)
+			}
+		})
+	}
+
+	for _, fields := range [][]string{nil, {"ip.src", ""}, {"ip.src == 1.1.1.1"}, {"tcp or udp"}, {"nope.x"}, {"frame.nope"}} {
+		if _, err := NewFieldsPacketsFormater(FieldsOptions{Fields: fields}); err == nil {
+			t.Errorf("❌ bad fields %q: error = nil", fields)
+		}
+	}
+}
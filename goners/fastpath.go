@@ -0,0 +1,264 @@
+package goners
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// The fast path decodes packets with a gopacket.DecodingLayerParser into
+// preallocated Ethernet/IPv4/IPv6/TCP/UDP/ICMP layers of pooled Packets,
+// instead of allocating a gopacket.Packet and all its layers for each one.
+//
+// It gives the same Packet/Layer view for the supported layers: decoding
+// stops at the first unsupported layer (e.g. DNS, TLS or IPv6 extension
+// headers), whose data are left as a Payload layer.
+//
+// Fast path Packets are reused after they are Released: their Layers,
+// Payloads and Fields must not be kept.
+
+// fastDecoder is the preallocated storage of a fast path Packet.
+type fastDecoder struct {
+	loopback layers.Loopback
+	sll      layers.LinuxSLL
+	eth      layers.Ethernet
+	ip4      layers.IPv4
+	ip6      layers.IPv6
+	tcp      layers.TCP
+	udp      layers.UDP
+	icmp4    layers.ICMPv4
+	icmp6    layers.ICMPv6
+	payload  gopacket.Payload
+
+	parsers map[gopacket.LayerType]*gopacket.DecodingLayerParser // by the first layer
+	decoded []gopacket.LayerType
+	caches  [8]layerCache
+
+	data []byte // copy of the packet data
+	refs atomic.Int32
+}
+
+// fastPackets is the pool of fast path Packets.
+var fastPackets = sync.Pool{
+	New: func() any {
+		return &Packet{fast: &fastDecoder{
+			parsers: make(map[gopacket.LayerType]*gopacket.DecodingLayerParser),
+		}}
+	},
+}
+
+// parser returns the DecodingLayerParser starts from the first layer type.
+func (d *fastDecoder) parser(first gopacket.LayerType) *gopacket.DecodingLayerParser {
+	if parser, ok := d.parsers[first]; ok {
+		return parser
+	}
+	parser := gopacket.NewDecodingLayerParser(first,
+		&d.loopback, &d.sll, &d.eth, &d.ip4, &d.ip6,
+		&d.tcp, &d.udp, &d.icmp4, &d.icmp6, &d.payload)
+	parser.IgnoreUnsupported = true
+	d.parsers[first] = parser
+	return parser
+}
+
+func (d *fastDecoder) layer(t gopacket.LayerType) gopacket.Layer {
+	switch t {
+	case layers.LayerTypeLoopback:
+		return &d.loopback
+	case layers.LayerTypeLinuxSLL:
+		return &d.sll
+	case layers.LayerTypeEthernet:
+		return &d.eth
+	case layers.LayerTypeIPv4:
+		return &d.ip4
+	case layers.LayerTypeIPv6:
+		return &d.ip6
+	case layers.LayerTypeTCP:
+		return &d.tcp
+	case layers.LayerTypeUDP:
+		return &d.udp
+	case layers.LayerTypeICMPv4:
+		return &d.icmp4
+	case layers.LayerTypeICMPv6:
+		return &d.icmp6
+	case gopacket.LayerTypePayload:
+		return &d.payload
+	}
+	return nil
+}
+
+// fastFirstLayer returns the first layer type of the link type
+// for the fast path, or false if the link type is not supported.
+func fastFirstLayer(linkType layers.LinkType, data []byte) (gopacket.LayerType, bool) {
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		return layers.LayerTypeEthernet, true
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		return layers.LayerTypeLoopback, true
+	case layers.LinkTypeLinuxSLL:
+		return layers.LayerTypeLinuxSLL, true
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4, layers.LinkTypeIPv6:
+		if len(data) > 0 && data[0]>>4 == 6 {
+			return layers.LayerTypeIPv6, true
+		}
+		return layers.LayerTypeIPv4, true
+	}
+	return gopacket.LayerTypeZero, false
+}
+
+// NewFastPacket decodes the data with the fast path into a pooled Packet.
+// The data is copied: it can be reused after NewFastPacket returns.
+//
+// Call Release when done with the Packet. Packets of link types not
+// supported by the fast path are decoded by NewPacket.
+func NewFastPacket(data []byte, ci gopacket.CaptureInfo, linkType layers.LinkType) *Packet {
+	first, ok := fastFirstLayer(linkType, data)
+	if !ok {
+		copied := make([]byte, len(data))
+		copy(copied, data)
+		packet := gopacket.NewPacket(copied, linkType, gopacket.Default)
+		packet.Metadata().CaptureInfo = ci
+		p := NewPacket(packet)
+		p.linkType = linkType
+		return p
+	}
+
+	p := fastPackets.Get().(*Packet)
+	d := p.fast
+	d.refs.Store(1)
+	d.data = append(d.data[:0], data...)
+
+	// like NewPacket, keep the layers decoded before an error (if any)
+	_ = d.parser(first).DecodeLayers(d.data, &d.decoded)
+	if n := len(d.decoded); n > 0 && d.decoded[n-1] != gopacket.LayerTypePayload {
+		if rest := d.layer(d.decoded[n-1]).LayerPayload(); len(rest) > 0 {
+			d.payload = rest
+			d.decoded = append(d.decoded, gopacket.LayerTypePayload)
+		}
+	}
+
+	layerViews := p.Layers[:0]
+	for i, t := range d.decoded {
+		l := newLayer(d.layer(t))
+		if i < len(d.caches) {
+			d.caches[i] = layerCache{}
+			l.cache = &d.caches[i]
+		}
+		layerViews = append(layerViews, l)
+	}
+
+	*p = Packet{
+		DeviceIndex:   ci.InterfaceIndex,
+		Timestamp:     ci.Timestamp,
+		Length:        ci.Length,
+		CaptureLength: ci.CaptureLength,
+		Reassembly:    reassemblyOf(ci),
+		Layers:        layerViews,
+
+		data:     d.data,
+		ci:       ci,
+		linkType: linkType,
+		fast:     d,
+	}
+	return p
+}
+
+// Release returns a fast path Packet to the pool after all its users
+// are done with it (see TeePackets). It's a no-op for other Packets.
+//
+// The Packet, its Layers and Fields must not be used after Release:
+// nor by a pipeline stage after sending it downstream, where it may
+// be Released at any time.
+func (p *Packet) Release() {
+	if p == nil || p.fast == nil {
+		return
+	}
+	if p.fast.refs.Add(-1) > 0 {
+		return
+	}
+	fastPackets.Put(p)
+}
+
+// retain adds n users of a fast path Packet: each of them calls Release.
+func (p *Packet) retain(n int) {
+	if p.fast != nil {
+		p.fast.refs.Add(int32(n))
+	}
+}
+
+// CaptureLiveFastPackets is CaptureLivePackets with the fast path.
+func CaptureLiveFastPackets(ctx context.Context,
+	device string, bpf string, snaplen int32, promisc bool, timeout time.Duration,
+) (chan *Packet, error) {
+	source, err := OpenPacketSource(LiveSource{
+		Device:  device,
+		Snaplen: snaplen,
+		Promisc: promisc,
+		Timeout: timeout,
+	}, bpf)
+	if err != nil {
+		return nil, err
+	}
+	return CapturePacketsFastFrom(ctx, source), nil
+}
+
+// CapturePacketsFastFrom is CapturePacketsFrom with the fast path:
+// packets are read by ZeroCopyReadPacketData (if the source supports it)
+// and decoded by NewFastPacket.
+//
+// The source is closed once ctx is done, which ends a read blocking on
+// it (e.g. a live source opened with pcap.BlockForever).
+func CapturePacketsFastFrom(ctx context.Context, source PacketSource) chan *Packet {
+	chOut := make(chan *Packet, ChanBufSize)
+
+	read := source.ReadPacketData
+	if zc, ok := source.(gopacket.ZeroCopyPacketDataSource); ok {
+		read = zc.ZeroCopyReadPacketData
+	}
+
+	go func() {
+		defer close(chOut)
+		defer source.Close()
+
+		stopped := make(chan struct{})
+		defer close(stopped)
+		go func() {
+			select {
+			case <-ctx.Done():
+				source.Close() // unblocks the read
+			case <-stopped:
+			}
+		}()
+
+		linkType := source.LinkType()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			data, ci, err := read()
+			if err == pcap.NextErrorTimeoutExpired {
+				continue
+			}
+			if err != nil { // EOF or unrecoverable error
+				return
+			}
+
+			p := NewFastPacket(data, ci, linkType)
+			select {
+			case chOut <- p:
+			case <-ctx.Done():
+				p.Release()
+				return
+			}
+		}
+	}()
+
+	return chOut
+}
@@ -0,0 +1,258 @@
+package goners
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// serializeLayers crafts a packet of the layers, with lengths & checksums fixed.
+func serializeLayers(t testing.TB, ls ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func craftUDPPacket(t testing.TB, v6 bool, payload []byte) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01},
+		DstMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	udp := &layers.UDP{SrcPort: 40000, DstPort: 9999}
+
+	var ip gopacket.SerializableLayer
+	if v6 {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolUDP,
+			SrcIP:      net.ParseIP("2001:db8::1"),
+			DstIP:      net.ParseIP("2001:db8::2"),
+		}
+		udp.SetNetworkLayerForChecksum(ip6)
+		ip = ip6
+	} else {
+		ip4 := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    net.IP{10, 0, 0, 1},
+			DstIP:    net.IP{10, 0, 0, 2},
+		}
+		udp.SetNetworkLayerForChecksum(ip4)
+		ip = ip4
+	}
+	return serializeLayers(t, eth, ip, udp, gopacket.Payload(payload))
+}
+
+// craftICMPPacket crafts an ICMPv4 echo request from 10.0.0.1 to 10.0.0.2.
+func craftICMPPacket(t testing.TB, id, seq uint16, payload string) []byte {
+	return serializeLayers(t,
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01},
+			DstMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		&layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolICMPv4,
+			SrcIP:    net.IP{10, 0, 0, 1},
+			DstIP:    net.IP{10, 0, 0, 2},
+		},
+		&layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0),
+			Id:       id,
+			Seq:      seq,
+		},
+		gopacket.Payload(payload),
+	)
+}
+
+func TestNewFastPacket(t *testing.T) {
+	ci := gopacket.CaptureInfo{Timestamp: time.Unix(1678000000, 0), InterfaceIndex: 1}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"tcp", craftTCPPacket(t, 40000, 443, []byte("hello"))},
+		{"tcpNoPayload", craftTCPPacket(t, 40000, 443, nil)},
+		{"udp", craftUDPPacket(t, false, []byte("hello"))},
+		{"udp6", craftUDPPacket(t, true, []byte("hello"))},
+		{"icmp", craftICMPPacket(t, 1, 1, "ping")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ci := ci
+			ci.Length, ci.CaptureLength = len(tt.data), len(tt.data)
+
+			packet := gopacket.NewPacket(tt.data, layers.LinkTypeEthernet, gopacket.Default)
+			packet.Metadata().CaptureInfo = ci
+			want := NewPacket(packet)
+
+			got := NewFastPacket(tt.data, ci, layers.LinkTypeEthernet)
+			defer got.Release()
+
+			if len(got.Layers) != len(want.Layers) {
+				t.Fatalf("❌ got %v layers, want %v: %v", len(got.Layers), len(want.Layers), got)
+			}
+			for i := range want.Layers {
+				g, w := got.Layers[i], want.Layers[i]
+				if g.LayerType != w.LayerType || g.Src != w.Src || g.Dst != w.Dst ||
+					!bytes.Equal(g.Payload, w.Payload) {
+					t.Errorf("❌ layer %v = %+v, want %+v", i, g, w)
+				}
+				if !reflect.DeepEqual(g.Fields(), w.Fields()) {
+					t.Errorf("❌ layer %v Fields() = %v, want %v", i, g.Fields(), w.Fields())
+				}
+			}
+
+			gj, err := got.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wj, err := want.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(gj, wj) {
+				t.Errorf("❌ MarshalJSON() = %s\nwant %s", gj, wj)
+			}
+			if !bytes.Equal(got.Data(), tt.data) || !reflect.DeepEqual(got.CaptureInfo(), ci) {
+				t.Errorf("❌ Data() or CaptureInfo() is not kept")
+			}
+		})
+	}
+}
+
+func TestNewFastPacket_unsupported(t *testing.T) {
+	// DNS is not decoded by the fast path: left as a Payload layer
+	data := craftDNSQueryPacket(t, "example.com")
+	p := NewFastPacket(data, gopacket.CaptureInfo{}, layers.LinkTypeEthernet)
+
+	udp, last := p.Layers[len(p.Layers)-2], p.Layers[len(p.Layers)-1]
+	if udp.LayerType != "UDP" || last.LayerType != "Payload" ||
+		last.Fields()["value"] != hex.EncodeToString(udp.Payload) {
+		t.Errorf("❌ last layers = %+v, %+v, want UDP & Payload of DNS", udp, last)
+	}
+	p.Release()
+
+	// unsupported link type: decoded by NewPacket
+	p = NewFastPacket(data[14:], gopacket.CaptureInfo{}, layers.LinkTypeFDDI)
+	if p.packet == nil || p.fast != nil {
+		t.Errorf("❌ unsupported link type not decoded by NewPacket")
+	}
+	p.Release() // no-op
+}
+
+func TestCapturePacketsFastFrom(t *testing.T) {
+	const n = 200
+	source := newTestSyntheticSource(t, n)
+
+	// the slow path, as the reference
+	var want [][]byte
+	for p := range CapturePacketsFrom(context.Background(), mustOpen(t, source)) {
+		j, err := p.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, j)
+	}
+
+	// pooled Packets are released by both formaters of the tee,
+	// and reused: outputs must not be corrupted.
+	packets := CapturePacketsFastFrom(context.Background(), mustOpen(t, source))
+	tee := TeePackets(packets, 2)
+	outs := []<-chan []byte{
+		JsonPacketsFormater.FormatPackets(tee[0]),
+		JsonPacketsFormater.FormatPackets(tee[1]),
+	}
+
+	got := make([][][]byte, len(outs))
+	done := make(chan int)
+	for i, out := range outs {
+		i, out := i, out
+		go func() {
+			for j := range out {
+				got[i] = append(got[i], j)
+			}
+			done <- i
+		}()
+	}
+	<-done
+	<-done
+
+	for i := range got {
+		if len(got[i]) != n {
+			t.Fatalf("❌ tee[%v] got %v packets, want %v", i, len(got[i]), n)
+		}
+		for k := range want {
+			if !bytes.Equal(got[i][k], want[k]) {
+				t.Errorf("❌ tee[%v] packet %v = %s\nwant %s", i, k, got[i][k], want[k])
+			}
+		}
+	}
+}
+
+// blockingSource blocks reading until it is closed,
+// like a live source opened with pcap.BlockForever.
+type blockingSource struct {
+	reading, closed chan struct{}
+	once, closeOnce sync.Once
+}
+
+func newBlockingSource() *blockingSource {
+	return &blockingSource{reading: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (s *blockingSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	s.once.Do(func() { close(s.reading) })
+	<-s.closed
+	return nil, gopacket.CaptureInfo{}, io.EOF
+}
+
+func (s *blockingSource) LinkType() layers.LinkType      { return layers.LinkTypeEthernet }
+func (s *blockingSource) SetBPFFilter(expr string) error { return nil }
+func (s *blockingSource) Close()                         { s.closeOnce.Do(func() { close(s.closed) }) }
+
+func TestCapturePacketsFastFrom_cancel(t *testing.T) {
+	for i, capture := range []CaptureFunc{CapturePacketsFrom, CapturePacketsFastFrom} {
+		ctx, cancel := context.WithCancel(context.Background())
+		source := newBlockingSource()
+		packets := capture(ctx, source)
+
+		<-source.reading
+		cancel()
+		select {
+		case _, ok := <-packets:
+			if ok {
+				t.Errorf("❌ capture[%v]: got a packet from a blocking source", i)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("❌ capture[%v]: blocking read not canceled", i)
+		}
+	}
+}
+
+func mustOpen(t testing.TB, provider PacketSourceProvider) PacketSource {
+	source, err := OpenPacketSource(provider, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
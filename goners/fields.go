@@ -0,0 +1,436 @@
+package goners
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Fields is the field tree of a layer: typed values (numbers, bools,
+// strings, arrays and nested Fields) that can be marshaled into JSON.
+//
+// IPs and MACs are strings, byte slices are hex strings.
+// Keys are the snake_cased field names of the gopacket layer:
+//
+//	{"src_port": 443, "options": [{"kind": 2, "length": 4, "data": "05b4"}], ...}
+type Fields map[string]any
+
+// maxFieldsDepth limits the nesting of Fields.
+const maxFieldsDepth = 8
+
+// NewFields builds the field tree of the gopacket layer.
+func NewFields(layer gopacket.Layer) Fields {
+	v := reflect.ValueOf(layer)
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return Fields{}
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return Fields{"value": fieldValue(v, 0)}
+	}
+	return structFields(v, 0)
+}
+
+// fieldOverride renames a field and/or marks a []byte field as text.
+type fieldOverride struct {
+	name string
+	text bool
+}
+
+// fieldOverrides for the gopacket types whose field names stutter
+// or collide, or whose byte slices are text.
+//
+// Names colliding in snake_case without an override get numbered
+// (see uniqueName): add an override for a better name.
+var fieldOverrides = map[reflect.Type]map[string]fieldOverride{
+	reflect.TypeOf(layers.TCP{}): {
+		"Ack": {name: "ack_num"}, // vs the ACK flag, which would be "ack_2"
+	},
+	reflect.TypeOf(layers.TCPOption{}): {
+		"OptionType":   {name: "kind"},
+		"OptionLength": {name: "length"},
+		"OptionData":   {name: "data"},
+	},
+	reflect.TypeOf(layers.IPv4Option{}): {
+		"OptionType":   {name: "kind"},
+		"OptionLength": {name: "length"},
+		"OptionData":   {name: "data"},
+	},
+	reflect.TypeOf(layers.DNSQuestion{}): {
+		"Name": {name: "name", text: true},
+	},
+	reflect.TypeOf(layers.DNSResourceRecord{}): {
+		"Name":  {name: "name", text: true},
+		"NS":    {name: "ns", text: true},
+		"CNAME": {name: "cname", text: true},
+		"PTR":   {name: "ptr", text: true},
+		"TXTs":  {name: "txts", text: true},
+		"TXT":   {name: "txt", text: true},
+	},
+}
+
+var (
+	typeBaseLayer    = reflect.TypeOf(layers.BaseLayer{})
+	typeIP           = reflect.TypeOf(net.IP{})
+	typeHardwareAddr = reflect.TypeOf(net.HardwareAddr{})
+	typeTime         = reflect.TypeOf(time.Time{})
+)
+
+// structPlan is how to walk the fields of a struct type: it's built once
+// for each reflect.Type and cached in structPlans, to avoid reflecting
+// on the type (names, tags, overrides, ...) for every packet.
+type structPlan struct {
+	fields []fieldPlan
+	size   int // number of fields in the tree, including promoted ones
+}
+
+// fieldPlan is the accessor of a field.
+type fieldPlan struct {
+	index int    // in the struct
+	name  string // snake_cased or overridden
+	text  bool   // []byte as string
+
+	// embedded is the plan of an embedded struct (or pointer to struct),
+	// whose fields are promoted. name & text are unused then.
+	embedded *structPlan
+}
+
+var structPlans sync.Map // map[reflect.Type]*structPlan
+
+// planOf returns the cached structPlan of the struct type t.
+func planOf(t reflect.Type) *structPlan {
+	if plan, ok := structPlans.Load(t); ok {
+		return plan.(*structPlan)
+	}
+	plan, _ := structPlans.LoadOrStore(t, newStructPlan(t))
+	return plan.(*structPlan)
+}
+
+// newStructPlan plans to walk exported fields of the struct type t.
+// Fields of embedded structs are promoted, except the gopacket BaseLayer
+// (Contents & Payload).
+func newStructPlan(t reflect.Type) *structPlan {
+	plan := &structPlan{}
+	overrides := fieldOverrides[t]
+	seen := make(map[string]bool) // names in the tree
+
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+
+		if ft.Anonymous {
+			et := ft.Type
+			if et.Kind() == reflect.Ptr {
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct && et != typeBaseLayer && et != t {
+				embedded := planOf(et)
+				plan.fields = append(plan.fields, fieldPlan{index: i, embedded: embedded})
+				plan.size += embedded.size
+				embedded.eachName(func(name string) { seen[name] = true })
+			}
+			continue
+		}
+		if !ft.IsExported() {
+			continue
+		}
+
+		fp := fieldPlan{index: i, name: snakeCase(ft.Name)}
+		if override, ok := overrides[ft.Name]; ok {
+			if override.name != "" {
+				fp.name = override.name
+			}
+			fp.text = override.text
+		}
+		fp.name = uniqueName(seen, fp.name)
+		plan.fields = append(plan.fields, fp)
+		plan.size++
+	}
+
+	return plan
+}
+
+// uniqueName returns the name, or name_2, name_3, ... if it's seen
+// already, so that fields colliding in snake_case (e.g. Ack & ACK)
+// don't overwrite each other. The returned name is marked seen.
+func uniqueName(seen map[string]bool, name string) string {
+	unique := name
+	for n := 2; seen[unique]; n++ {
+		unique = name + "_" + strconv.Itoa(n)
+	}
+	seen[unique] = true
+	return unique
+}
+
+// eachName calls f with the name of each field in the tree,
+// including the promoted ones.
+func (p *structPlan) eachName(f func(name string)) {
+	for _, fp := range p.fields {
+		if fp.embedded != nil {
+			fp.embedded.eachName(f)
+			continue
+		}
+		f(fp.name)
+	}
+}
+
+// structFields walks the struct v as its structPlan says.
+func structFields(v reflect.Value, depth int) Fields {
+	plan := planOf(v.Type())
+	fields := make(Fields, plan.size)
+	fillFields(fields, v, plan, depth)
+	return fields
+}
+
+func fillFields(fields Fields, v reflect.Value, plan *structPlan, depth int) {
+	for _, fp := range plan.fields {
+		fv := v.Field(fp.index)
+
+		if fp.embedded != nil {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			fillFields(fields, fv, fp.embedded, depth)
+			continue
+		}
+
+		if fp.text {
+			fields[fp.name] = textValue(fv)
+		} else {
+			fields[fp.name] = fieldValue(fv, depth+1)
+		}
+	}
+}
+
+// fieldValue converts v into a typed value of Fields.
+func fieldValue(v reflect.Value, depth int) any {
+	if depth > maxFieldsDepth {
+		return nil
+	}
+
+	switch v.Type() {
+	case typeIP:
+		if v.Len() == 0 {
+			return ""
+		}
+		return net.IP(v.Bytes()).String()
+	case typeHardwareAddr:
+		if v.Len() == 0 {
+			return ""
+		}
+		return net.HardwareAddr(v.Bytes()).String()
+	case typeTime:
+		if !v.CanInterface() {
+			return nil
+		}
+		return v.Interface().(time.Time).Format(time.RFC3339Nano)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 { // bytes
+			if v.Kind() == reflect.Array {
+				b := make([]byte, v.Len())
+				reflect.Copy(reflect.ValueOf(b), v)
+				return hex.EncodeToString(b)
+			}
+			return hex.EncodeToString(v.Bytes())
+		}
+		values := make([]any, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, fieldValue(v.Index(i), depth+1))
+		}
+		return values
+	case reflect.Struct:
+		return structFields(v, depth)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return fieldValue(v.Elem(), depth)
+	case reflect.Map:
+		fields := make(Fields)
+		iter := v.MapRange()
+		for iter.Next() {
+			fields[fmt.Sprint(iter.Key())] = fieldValue(iter.Value(), depth+1)
+		}
+		return fields
+	}
+	return nil // chan, func, ...
+}
+
+// textValue converts []byte (or [][]byte) v into string(s).
+func textValue(v reflect.Value) any {
+	if v.Kind() != reflect.Slice {
+		return fieldValue(v, 0)
+	}
+	if v.Type().Elem().Kind() == reflect.Uint8 {
+		return string(v.Bytes())
+	}
+	values := make([]any, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		values = append(values, textValue(v.Index(i)))
+	}
+	return values
+}
+
+// snakeCase converts Go names into snake_case: SrcIP -> src_ip,
+// QDCount -> qd_count, IPv4 -> ipv4.
+func snakeCase(name string) string {
+	runes := []rune(name)
+
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1]) &&
+				!isVersionSuffix(runes[i+1:]) // IPv4, ICMPv6
+
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
+
+// isVersionSuffix reports whether the runes start with "v<digit>".
+func isVersionSuffix(runes []rune) bool {
+	return len(runes) >= 2 && runes[0] == 'v' && unicode.IsDigit(runes[1])
+}
+
+// Flatten returns the leaf values of the tree by their dotted paths,
+// prefixed with prefix (if not empty): "tcp.options[0].kind".
+func (f Fields) Flatten(prefix string) map[string]any {
+	flat := make(map[string]any)
+	flattenField(flat, prefix, f)
+	return flat
+}
+
+func flattenField(flat map[string]any, path string, value any) {
+	switch v := value.(type) {
+	case Fields:
+		for k, child := range v {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			flattenField(flat, p, child)
+		}
+	case []any:
+		for i, child := range v {
+			flattenField(flat, fmt.Sprintf("%s[%d]", path, i), child)
+		}
+	default:
+		flat[path] = value
+	}
+}
+
+// Paths returns the sorted dotted paths of the leaf values.
+func (f Fields) Paths() []string {
+	flat := f.Flatten("")
+	paths := make([]string, 0, len(flat))
+	for p := range flat {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Get returns the value (leaf or subtree) at the dotted path,
+// e.g. "options[0].kind", "options[0]" or "options".
+func (f Fields) Get(path string) (any, bool) {
+	var value any = f
+	for _, part := range strings.Split(path, ".") {
+		name, indexes, ok := parseFieldPathPart(part)
+		if !ok {
+			return nil, false
+		}
+
+		fields, isFields := value.(Fields)
+		if !isFields {
+			return nil, false
+		}
+		if value, ok = fields[name]; !ok {
+			return nil, false
+		}
+
+		for _, i := range indexes {
+			values, isSlice := value.([]any)
+			if !isSlice || i < 0 || i >= len(values) {
+				return nil, false
+			}
+			value = values[i]
+		}
+	}
+	return value, true
+}
+
+// parseFieldPathPart parses "options[0]" into "options", [0].
+func parseFieldPathPart(part string) (name string, indexes []int, ok bool) {
+	name, rest, _ := strings.Cut(part, "[")
+	if name == "" {
+		return "", nil, false
+	}
+	for rest != "" {
+		index, after, found := strings.Cut(rest, "]")
+		if !found {
+			return "", nil, false
+		}
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			return "", nil, false
+		}
+		indexes = append(indexes, i)
+		rest = strings.TrimPrefix(after, "[")
+	}
+	return name, indexes, true
+}
+
+// layerAbbrs are the Wireshark style abbreviations of layer types,
+// used as the first part of field paths: "ip.src_ip".
+var layerAbbrs = map[string]string{
+	"Ethernet":  "eth",
+	"IPv4":      "ip",
+	"IPv6":      "ipv6",
+	"ICMPv4":    "icmp",
+	"ICMPv6":    "icmpv6",
+	"Dot1Q":     "vlan",
+	"Loopback":  "null",
+	"Linux SLL": "sll",
+}
+
+// LayerAbbr returns the abbreviation of the layer type for field paths:
+// "ip" for IPv4, "tcp" for TCP, ...
+func LayerAbbr(layerType string) string {
+	if abbr, ok := layerAbbrs[layerType]; ok {
+		return abbr
+	}
+	return strings.ToLower(strings.ReplaceAll(layerType, " ", "_"))
+}
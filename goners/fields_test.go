@@ -0,0 +1,217 @@
+package goners
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func Test_snakeCase(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"SrcIP", "src_ip"},
+		{"QDCount", "qd_count"},
+		{"IPv4", "ipv4"},
+		{"TTL", "ttl"},
+		{"FragOffset", "frag_offset"},
+		{"DstMAC", "dst_mac"},
+	}
+	for _, tt := range tests {
+		if got := snakeCase(tt.name); got != tt.want {
+			t.Errorf("❌ snakeCase(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// craftDNSQueryPacket crafts a DNS query for the name:
+// 10.0.0.1:40000 -> 10.0.0.53:53.
+func craftDNSQueryPacket(t testing.TB, name string) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01},
+		DstMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 53},
+	}
+	udp := &layers.UDP{SrcPort: 40000, DstPort: 53}
+	if err := udp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+	dns := &layers.DNS{
+		ID:      42,
+		RD:      true,
+		QDCount: 1,
+		Questions: []layers.DNSQuestion{
+			{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN},
+		},
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, dns); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPacket_Field(t *testing.T) {
+	tcp := &layers.TCP{
+		SrcPort: 40000,
+		DstPort: 443,
+		Ack:     1000,
+		SYN:     true,
+		ACK:     true,
+		Window:  65535,
+		Options: []layers.TCPOption{
+			{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+		},
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01},
+		DstMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp); err != nil {
+		t.Fatal(err)
+	}
+
+	tcpPacket := NewPacket(gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default))
+	dnsPacket := NewPacket(gopacket.NewPacket(craftDNSQueryPacket(t, "example.com"), layers.LinkTypeEthernet, gopacket.Default))
+
+	tests := []struct {
+		name   string
+		packet *Packet
+		path   string
+		want   any
+		wantOk bool
+	}{
+		{"mac", tcpPacket, "eth.src_mac", "00:00:5e:00:53:01", true},
+		{"ip", tcpPacket, "ip.dst_ip", "10.0.0.2", true},
+		{"uint", tcpPacket, "ip.ttl", uint64(64), true},
+		{"port", tcpPacket, "tcp.dst_port", uint64(443), true},
+		{"bool", tcpPacket, "tcp.syn", true, true},
+		{"renamed", tcpPacket, "tcp.ack_num", uint64(1000), true}, // Ack
+		{"collided", tcpPacket, "tcp.ack", true, true},            // ACK
+		{"nested", tcpPacket, "tcp.options[0].kind", uint64(layers.TCPOptionKindMSS), true},
+		{"bytes", tcpPacket, "tcp.options[0].data", "05b4", true},
+		{"text", dnsPacket, "dns.questions[0].name", "example.com", true},
+		{"noIndex", tcpPacket, "tcp.options[9].kind", nil, false},
+		{"noField", tcpPacket, "tcp.nope", nil, false},
+		{"noLayer", tcpPacket, "udp.dst_port", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.packet.Field(tt.path)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("❌ Field(%q) = %#v, %v, want %#v, %v", tt.path, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+
+	// JSON: typed values & nested objects
+	j, err := json.Marshal(tcpPacket.Layers[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var layer struct {
+		Fields struct {
+			DstPort int  `json:"dst_port"`
+			SYN     bool `json:"syn"`
+			Options []struct {
+				Kind int    `json:"kind"`
+				Data string `json:"data"`
+			} `json:"options"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(j, &layer); err != nil {
+		t.Fatalf("❌ unexpected JSON %s: %v", j, err)
+	}
+	if layer.Fields.DstPort != 443 || !layer.Fields.SYN ||
+		len(layer.Fields.Options) != 1 || layer.Fields.Options[0].Kind != 2 {
+		t.Errorf("❌ unexpected JSON fields: %s", j)
+	}
+}
+
+func TestLayer_Fields_cached(t *testing.T) {
+	p := NewPacket(gopacket.NewPacket(craftTCPPacket(t, 40000, 443, []byte("hello")), layers.LinkTypeEthernet, gopacket.Default))
+	for _, l := range p.Layers {
+		if reflect.ValueOf(l.Fields()).Pointer() != reflect.ValueOf(l.Fields()).Pointer() {
+			t.Errorf("❌ %v: Fields() is not cached", l.LayerType)
+		}
+	}
+	if planOf(reflect.TypeOf(layers.TCP{})) != planOf(reflect.TypeOf(layers.TCP{})) {
+		t.Errorf("❌ structPlan is not cached")
+	}
+
+	// opt-in details
+	j, err := p.MarshalJSONDetail(LayerNoDetail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(j), `"dump"`) || strings.Contains(string(j), `"fields"`) {
+		t.Errorf("❌ MarshalJSONDetail(LayerNoDetail) = %s", j)
+	}
+	j, err = json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(j), `"dump"`) || !strings.Contains(string(j), `"fields"`) {
+		t.Errorf("❌ MarshalJSON() = %s, want all details", j)
+	}
+}
+
+func Test_newStructPlan_collisions(t *testing.T) {
+	type inner struct {
+		Seq uint32
+	}
+	type collide struct {
+		inner
+		Ack uint32
+		ACK bool
+		SEQ bool // vs the promoted Seq
+	}
+	got := structFields(reflect.ValueOf(collide{inner: inner{Seq: 1}, Ack: 2, ACK: true, SEQ: true}), 0)
+	want := Fields{"seq": uint64(1), "ack": uint64(2), "ack_2": true, "seq_2": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("❌ got %v, want %v", got, want)
+	}
+
+	// the layers decoded should have overrides for their collisions
+	for _, typ := range []reflect.Type{
+		reflect.TypeOf(layers.Ethernet{}), reflect.TypeOf(layers.ARP{}),
+		reflect.TypeOf(layers.IPv4{}), reflect.TypeOf(layers.IPv6{}),
+		reflect.TypeOf(layers.TCP{}), reflect.TypeOf(layers.UDP{}),
+		reflect.TypeOf(layers.ICMPv4{}), reflect.TypeOf(layers.ICMPv6{}),
+		reflect.TypeOf(layers.DNS{}), reflect.TypeOf(layers.Dot1Q{}),
+	} {
+		planOf(typ).eachName(func(name string) {
+			if strings.HasSuffix(name, "_2") {
+				t.Errorf("❌ %v: field %q collides, add a fieldOverride", typ, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package goners
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// Conversation is the traffic between two endpoints at a layer,
+// like the "Conversations" of Wireshark.
+type Conversation struct {
+	Type string `json:"type"` // abbreviation of the layer: eth, ip, ipv6, tcp, udp, ...
+	A    string `json:"a"`    // address ("ip:port" for transport layers) of the first sender
+	B    string `json:"b"`
+
+	Packets     int64 `json:"packets"`
+	Bytes       int64 `json:"bytes"` // frame lengths
+	PacketsAToB int64 `json:"packets_a_to_b"`
+	BytesAToB   int64 `json:"bytes_a_to_b"`
+	PacketsBToA int64 `json:"packets_b_to_a"`
+	BytesBToA   int64 `json:"bytes_b_to_a"`
+
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	RelStart float64   `json:"rel_start"` // seconds since the first packet tracked
+	Duration float64   `json:"duration"`  // seconds
+	BpsAToB  float64   `json:"bps_a_to_b"`
+	BpsBToA  float64   `json:"bps_b_to_a"`
+}
+
+// Endpoint is the traffic of an address at a layer,
+// like the "Endpoints" of Wireshark.
+type Endpoint struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+
+	Packets   int64 `json:"packets"`
+	Bytes     int64 `json:"bytes"`
+	TxPackets int64 `json:"tx_packets"`
+	TxBytes   int64 `json:"tx_bytes"`
+	RxPackets int64 `json:"rx_packets"`
+	RxBytes   int64 `json:"rx_bytes"`
+}
+
+var ErrFlowsUntracked = errors.New("conversations & endpoints are not tracked (track_flows is off)")
+
+// DefaultFlowLimit is the number of conversations (and endpoints)
+// tracked by a FlowTracker.
+const DefaultFlowLimit = 1 << 16
+
+// FlowTracker maintains the Conversations & Endpoints of the link,
+// network and transport layers of the packets passed through.
+//
+// Use Track to plug it into the pipeline, or Add packets one by one.
+type FlowTracker struct {
+	// Limit is the number of conversations (and endpoints) tracked.
+	// Beyond that, packets of new ones are counted in Untracked.
+	Limit int
+
+	conversations map[flowKey]*Conversation
+	endpoints     map[flowKey]*Endpoint
+	first         time.Time
+	untracked     int64
+
+	mu sync.Mutex // protects all the above
+}
+
+// flowKey identifies a conversation (a < b) or an endpoint (b == "").
+type flowKey struct {
+	typ  string
+	a, b string
+}
+
+func NewFlowTracker() *FlowTracker {
+	return &FlowTracker{
+		Limit:         DefaultFlowLimit,
+		conversations: make(map[flowKey]*Conversation),
+		endpoints:     make(map[flowKey]*Endpoint),
+	}
+}
+
+// Track adds the packets passed through.
+func (t *FlowTracker) Track(in <-chan *Packet) chan *Packet {
+	return trackPackets(in, t.Add, nil)
+}
+
+// flowAddrs is the src & dst of a layer.
+type flowAddrs struct {
+	typ      string
+	src, dst string
+}
+
+// packetFlows returns the src & dst of the link, network and transport
+// layers of the packet. Ports of transport layers are joined with the
+// addresses of the network layer below.
+func packetFlows(p *Packet) []flowAddrs {
+	var flows []flowAddrs
+	var network *Layer
+	for i, l := range p.Layers {
+		if l.Src == "" && l.Dst == "" {
+			continue
+		}
+		switch l.layer.(type) {
+		case gopacket.LinkLayer:
+			flows = append(flows, flowAddrs{l.Abbr(), l.Src, l.Dst})
+		case gopacket.NetworkLayer:
+			flows = append(flows, flowAddrs{l.Abbr(), l.Src, l.Dst})
+			network = &p.Layers[i]
+		case gopacket.TransportLayer:
+			if network == nil {
+				continue
+			}
+			flows = append(flows, flowAddrs{l.Abbr(),
+				net.JoinHostPort(network.Src, l.Src),
+				net.JoinHostPort(network.Dst, l.Dst)})
+		}
+	}
+	return flows
+}
+
+// Add adds a packet.
+func (t *FlowTracker) Add(p *Packet) {
+	flows := packetFlows(p)
+	size := int64(p.Length)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.first.IsZero() || p.Timestamp.Before(t.first) {
+		t.first = p.Timestamp
+	}
+
+	for _, f := range flows {
+		t.addConversation(f, p.Timestamp, size)
+		t.addEndpoint(f.typ, f.src, size, true)
+		if f.dst != f.src {
+			t.addEndpoint(f.typ, f.dst, size, false)
+		}
+	}
+}
+
+func (t *FlowTracker) addConversation(f flowAddrs, ts time.Time, size int64) {
+	key := flowKey{f.typ, f.src, f.dst}
+	if key.a > key.b {
+		key.a, key.b = key.b, key.a
+	}
+	c, ok := t.conversations[key]
+	if !ok {
+		if len(t.conversations) >= t.Limit {
+			t.untracked++
+			return
+		}
+		c = &Conversation{Type: f.typ, A: f.src, B: f.dst, Start: ts, End: ts}
+		t.conversations[key] = c
+	}
+
+	c.Packets++
+	c.Bytes += size
+	if f.src == c.A {
+		c.PacketsAToB++
+		c.BytesAToB += size
+	} else {
+		c.PacketsBToA++
+		c.BytesBToA += size
+	}
+	if ts.Before(c.Start) {
+		c.Start = ts
+	}
+	if ts.After(c.End) {
+		c.End = ts
+	}
+}
+
+func (t *FlowTracker) addEndpoint(typ, addr string, size int64, tx bool) {
+	key := flowKey{typ: typ, a: addr}
+	e, ok := t.endpoints[key]
+	if !ok {
+		if len(t.endpoints) >= t.Limit {
+			t.untracked++
+			return
+		}
+		e = &Endpoint{Type: typ, Address: addr}
+		t.endpoints[key] = e
+	}
+
+	e.Packets++
+	e.Bytes += size
+	if tx {
+		e.TxPackets++
+		e.TxBytes += size
+	} else {
+		e.RxPackets++
+		e.RxBytes += size
+	}
+}
+
+// Untracked returns the number of conversations & endpoints updates
+// dropped because of the Limit.
+func (t *FlowTracker) Untracked() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.untracked
+}
+
+// Conversations returns the conversations of the layer type
+// (e.g. "tcp", or "" for all), the most bytes first.
+func (t *FlowTracker) Conversations(typ string) []Conversation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conversations := make([]Conversation, 0, len(t.conversations))
+	for _, c := range t.conversations {
+		if typ != "" && !strings.EqualFold(c.Type, typ) {
+			continue
+		}
+		conv := *c
+		conv.RelStart = conv.Start.Sub(t.first).Seconds()
+		conv.Duration = conv.End.Sub(conv.Start).Seconds()
+		if conv.Duration > 0 {
+			conv.BpsAToB = float64(conv.BytesAToB*8) / conv.Duration
+			conv.BpsBToA = float64(conv.BytesBToA*8) / conv.Duration
+		}
+		conversations = append(conversations, conv)
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		ci, cj := conversations[i], conversations[j]
+		if ci.Bytes != cj.Bytes {
+			return ci.Bytes > cj.Bytes
+		}
+		return ci.Type+" "+ci.A+" "+ci.B < cj.Type+" "+cj.A+" "+cj.B
+	})
+	return conversations
+}
+
+// Endpoints returns the endpoints of the layer type
+// (e.g. "ip", or "" for all), the most bytes first.
+func (t *FlowTracker) Endpoints(typ string) []Endpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	endpoints := make([]Endpoint, 0, len(t.endpoints))
+	for _, e := range t.endpoints {
+		if typ != "" && !strings.EqualFold(e.Type, typ) {
+			continue
+		}
+		endpoints = append(endpoints, *e)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		ei, ej := endpoints[i], endpoints[j]
+		if ei.Bytes != ej.Bytes {
+			return ei.Bytes > ej.Bytes
+		}
+		return ei.Type+" "+ei.Address < ej.Type+" "+ej.Address
+	})
+	return endpoints
+}
+
+// WriteConversations writes the conversations as a table.
+func WriteConversations(w io.Writer, conversations []Conversation) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "TYPE\tA\t\tB\tPACKETS\tBYTES\tA->B PACKETS\tA->B BYTES\tB->A PACKETS\tB->A BYTES\tREL START\tDURATION\tA->B BPS\tB->A BPS\t")
+	for _, c := range conversations {
+		fmt.Fprintf(tw, "%v\t%v\t<->\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%.6f\t%.6f\t%.0f\t%.0f\t\n",
+			c.Type, c.A, c.B, c.Packets, c.Bytes,
+			c.PacketsAToB, c.BytesAToB, c.PacketsBToA, c.BytesBToA,
+			c.RelStart, c.Duration, c.BpsAToB, c.BpsBToA)
+	}
+	return tw.Flush()
+}
+
+// WriteEndpoints writes the endpoints as a table.
+func WriteEndpoints(w io.Writer, endpoints []Endpoint) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "TYPE\tADDRESS\tPACKETS\tBYTES\tTX PACKETS\tTX BYTES\tRX PACKETS\tRX BYTES\t")
+	for _, e := range endpoints {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n",
+			e.Type, e.Address, e.Packets, e.Bytes, e.TxPackets, e.TxBytes, e.RxPackets, e.RxBytes)
+	}
+	return tw.Flush()
+}
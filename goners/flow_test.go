@@ -0,0 +1,83 @@
+package goners
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestFlowTracker(t *testing.T) {
+	source := tcpSegmentsSource(t, []tcpSegment{
+		{false, "S", 100, 0, ""},
+		{true, "SA", 500, 101, ""},
+		{false, "PA", 101, 501, "hello"},
+	})
+	lengths := make([]int64, len(source.Packets))
+	for i, p := range source.Packets {
+		lengths[i] = int64(p.CaptureInfo.Length)
+	}
+	clientBytes, serverBytes := lengths[0]+lengths[2], lengths[1]
+
+	for _, capture := range []CaptureFunc{CapturePacketsFrom, CapturePacketsFastFrom} {
+		flows := NewFlowTracker()
+		for p := range flows.Track(capture(context.Background(), mustOpen(t, source))) {
+			p.Release()
+		}
+
+		conversations := flows.Conversations("")
+		if len(conversations) != 3 {
+			t.Fatalf("❌ got %v conversations, want 3 (eth, ip, tcp): %+v", len(conversations), conversations)
+		}
+		tcp := flows.Conversations("TCP")
+		if len(tcp) != 1 {
+			t.Fatalf("❌ got %v tcp conversations, want 1", len(tcp))
+		}
+		if c := tcp[0]; c.A != "10.0.0.1:40000" || c.B != "10.0.0.2:80" ||
+			c.Packets != 3 || c.PacketsAToB != 2 || c.PacketsBToA != 1 ||
+			c.BytesAToB != clientBytes || c.BytesBToA != serverBytes ||
+			c.RelStart != 0 || c.Duration != 0.002 {
+			t.Errorf("❌ tcp conversation = %+v", c)
+		}
+
+		endpoints := flows.Endpoints("ip")
+		if len(endpoints) != 2 {
+			t.Fatalf("❌ got %v ip endpoints, want 2: %+v", len(endpoints), endpoints)
+		}
+		for _, e := range endpoints {
+			if e.Packets != 3 || e.Bytes != clientBytes+serverBytes {
+				t.Errorf("❌ endpoint = %+v", e)
+			}
+			if e.Address == "10.0.0.1" && (e.TxPackets != 2 || e.RxBytes != serverBytes) {
+				t.Errorf("❌ client endpoint = %+v", e)
+			}
+		}
+	}
+}
+
+func TestFlowTracker_limit(t *testing.T) {
+	flows := NewFlowTracker()
+	flows.Limit = 2
+	for p := range flows.Track(CapturePacketsFrom(context.Background(), mustOpen(t, newTestSyntheticSource(t, 3)))) {
+		p.Release()
+	}
+	if n := len(flows.Conversations("")); n != 2 {
+		t.Errorf("❌ got %v conversations, want 2", n)
+	}
+	if flows.Untracked() == 0 {
+		t.Errorf("❌ Untracked() = 0, want > 0")
+	}
+}
+
+func TestWriteConversations(t *testing.T) {
+	var sb strings.Builder
+	err := WriteConversations(&sb, []Conversation{
+		{Type: "udp", A: "10.0.0.1:40000", B: "10.0.0.2:53", Packets: 2, Bytes: 180},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "10.0.0.1:40000  <->  10.0.0.2:53") {
+		t.Errorf("❌ WriteConversations() =\n%v", sb.String())
+	}
+}
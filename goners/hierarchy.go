@@ -0,0 +1,129 @@
+package goners
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// ProtocolNode is a node of the protocol hierarchy: the packets whose
+// layers start with the chain of LayerTypes from the root to the node,
+// like the "Protocol Hierarchy" of Wireshark.
+type ProtocolNode struct {
+	Protocol string `json:"protocol"` // LayerType, e.g. "IPv4". "Frame" for the root.
+
+	Packets int64 `json:"packets"`
+	Bytes   int64 `json:"bytes"` // frame lengths
+
+	// PacketsPercent & BytesPercent are of all the packets (i.e. the root).
+	PacketsPercent float64 `json:"packets_percent"`
+	BytesPercent   float64 `json:"bytes_percent"`
+
+	Children []*ProtocolNode `json:"children,omitempty"` // the most bytes first
+}
+
+var ErrProtocolsUntracked = errors.New("protocols are not tracked (track_protocols is off)")
+
+// ProtocolTracker aggregates the Layers of the packets passed through
+// into the protocol hierarchy.
+//
+// Packets are added in a pipeline by Track, or by calling Add directly.
+type ProtocolTracker struct {
+	root protocolCount
+	mu   sync.Mutex // protects root
+}
+
+// protocolCount is the mutable ProtocolNode.
+type protocolCount struct {
+	packets, bytes int64
+	children       map[string]*protocolCount
+}
+
+func NewProtocolTracker() *ProtocolTracker {
+	return &ProtocolTracker{}
+}
+
+// Track adds the packets passed through.
+func (t *ProtocolTracker) Track(in <-chan *Packet) chan *Packet {
+	return trackPackets(in, t.Add, nil)
+}
+
+// Add adds a packet.
+func (t *ProtocolTracker) Add(p *Packet) {
+	size := int64(p.Length)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	node := &t.root
+	node.packets++
+	node.bytes += size
+	for _, l := range p.Layers {
+		if node.children == nil {
+			node.children = make(map[string]*protocolCount)
+		}
+		child, ok := node.children[l.LayerType]
+		if !ok {
+			child = &protocolCount{}
+			node.children[l.LayerType] = child
+		}
+		child.packets++
+		child.bytes += size
+		node = child
+	}
+}
+
+// Hierarchy returns a snapshot of the protocol hierarchy.
+func (t *ProtocolTracker) Hierarchy() *ProtocolNode {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.root.node("Frame", t.root.packets, t.root.bytes)
+}
+
+func (c *protocolCount) node(protocol string, totalPackets, totalBytes int64) *ProtocolNode {
+	n := &ProtocolNode{
+		Protocol: protocol,
+		Packets:  c.packets,
+		Bytes:    c.bytes,
+	}
+	if totalPackets > 0 {
+		n.PacketsPercent = float64(c.packets) * 100 / float64(totalPackets)
+	}
+	if totalBytes > 0 {
+		n.BytesPercent = float64(c.bytes) * 100 / float64(totalBytes)
+	}
+	for protocol, child := range c.children {
+		n.Children = append(n.Children, child.node(protocol, totalPackets, totalBytes))
+	}
+	sort.Slice(n.Children, func(i, j int) bool {
+		ci, cj := n.Children[i], n.Children[j]
+		if ci.Bytes != cj.Bytes {
+			return ci.Bytes > cj.Bytes
+		}
+		return ci.Protocol < cj.Protocol
+	})
+	return n
+}
+
+// WriteProtocolHierarchy writes the hierarchy as an indented table.
+func WriteProtocolHierarchy(w io.Writer, root *ProtocolNode) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROTOCOL\tPACKETS %\tPACKETS\tBYTES %\tBYTES\t")
+
+	var write func(n *ProtocolNode, depth int)
+	write = func(n *ProtocolNode, depth int) {
+		fmt.Fprintf(tw, "%v%v\t%.2f\t%v\t%.2f\t%v\t\n",
+			strings.Repeat("  ", depth), n.Protocol,
+			n.PacketsPercent, n.Packets, n.BytesPercent, n.Bytes)
+		for _, child := range n.Children {
+			write(child, depth+1)
+		}
+	}
+	write(root, 0)
+	return tw.Flush()
+}
@@ -0,0 +1,65 @@
+package goners
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestProtocolTracker(t *testing.T) {
+	source := SyntheticSource{Link: layers.LinkTypeEthernet}
+	for _, data := range [][]byte{
+		craftTCPPacket(t, 40000, 443, []byte("hello")),
+		craftTCPPacket(t, 40000, 443, []byte("world")),
+		craftDNSQueryPacket(t, "example.com"),
+	} {
+		source.Packets = append(source.Packets, SyntheticPacket{
+			Data:        data,
+			CaptureInfo: gopacket.CaptureInfo{CaptureLength: len(data), Length: len(data)},
+		})
+	}
+	tcpBytes := int64(source.Packets[0].CaptureInfo.Length + source.Packets[1].CaptureInfo.Length)
+	allBytes := tcpBytes + int64(source.Packets[2].CaptureInfo.Length)
+
+	protocols := NewProtocolTracker()
+	for p := range protocols.Track(CapturePacketsFrom(context.Background(), mustOpen(t, source))) {
+		p.Release()
+	}
+
+	root := protocols.Hierarchy()
+	if root.Protocol != "Frame" || root.Packets != 3 || root.Bytes != allBytes ||
+		root.PacketsPercent != 100 || root.BytesPercent != 100 {
+		t.Errorf("❌ root = %+v", root)
+	}
+	if len(root.Children) != 1 || root.Children[0].Protocol != "Ethernet" {
+		t.Fatalf("❌ root.Children = %+v, want [Ethernet]", root.Children)
+	}
+	ip := root.Children[0].Children
+	if len(ip) != 1 || ip[0].Protocol != "IPv4" || ip[0].Packets != 3 {
+		t.Fatalf("❌ Ethernet.Children = %+v, want [IPv4]", ip)
+	}
+
+	transport := ip[0].Children
+	if len(transport) != 2 {
+		t.Fatalf("❌ IPv4.Children = %+v, want [TCP UDP]", transport)
+	}
+	if tcp := transport[0]; tcp.Protocol != "TCP" || tcp.Packets != 2 || tcp.Bytes != tcpBytes ||
+		tcp.PacketsPercent != float64(2)*100/3 {
+		t.Errorf("❌ TCP = %+v", tcp)
+	}
+	if udp := transport[1]; udp.Protocol != "UDP" || udp.Packets != 1 ||
+		len(udp.Children) != 1 || udp.Children[0].Protocol != "DNS" {
+		t.Errorf("❌ UDP = %+v", udp)
+	}
+
+	var sb strings.Builder
+	if err := WriteProtocolHierarchy(&sb, root); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "\n        DNS ") {
+		t.Errorf("❌ WriteProtocolHierarchy() =\n%v", sb.String())
+	}
+}
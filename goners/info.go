@@ -0,0 +1,347 @@
+package goners
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// infoSummarizers summarize the layers of the LayerTypes into the Info of
+// the packet, given the packet and the index of the layer. The highest
+// layer summarized (with a non-empty string) wins.
+var infoSummarizers = map[gopacket.LayerType]func(p *Packet, i int) string{
+	layers.LayerTypeEthernet:                    ethernetInfo,
+	layers.LayerTypeARP:                         arpInfo,
+	layers.LayerTypeIPv4:                        ipv4Info,
+	layers.LayerTypeIPv6Fragment:                ipv6FragmentInfo,
+	layers.LayerTypeICMPv4:                      icmpv4Info,
+	layers.LayerTypeICMPv6:                      icmpv6Info,
+	layers.LayerTypeICMPv6Echo:                  icmpv6EchoInfo,
+	layers.LayerTypeICMPv6NeighborSolicitation:  icmpv6NeighborInfo,
+	layers.LayerTypeICMPv6NeighborAdvertisement: icmpv6NeighborInfo,
+	layers.LayerTypeTCP:                         tcpInfo,
+	layers.LayerTypeUDP:                         udpInfo,
+	layers.LayerTypeDNS:                         dnsInfo,
+	layers.LayerTypeDHCPv4:                      dhcpv4Info,
+	layers.LayerTypeTLS:                         tlsInfo,
+}
+
+// Info returns a one-line summary of the packet, like the "Info" column
+// of Wireshark, e.g.
+//
+//	Standard query 0x1a2b A example.com
+//	40000 → 80 [SYN, ACK] Seq=500 Ack=101 Win=65535 Len=0
+//	GET /index.html HTTP/1.1
+//	Who has 10.0.0.1? Tell 10.0.0.2
+//
+// It's made of the packet alone: the TCP Seq & Ack are absolute,
+// not relative to the stream.
+func (p Packet) Info() string {
+	info := ""
+	for i := len(p.Layers) - 1; i >= 0 && info == ""; i-- {
+		if p.Layers[i].layer == nil {
+			continue
+		}
+		if summarize, ok := infoSummarizers[p.Layers[i].layer.LayerType()]; ok {
+			info = summarize(&p, i)
+		}
+	}
+	if info == "" {
+		info = p.Protocol()
+	}
+	if n := len(p.Layers); n > 0 && p.Layers[n-1].LayerType == gopacket.LayerTypeDecodeFailure.String() {
+		info += " [Malformed Packet]"
+	}
+	return info
+}
+
+// Protocol returns the highest protocol of the packet, like the "Protocol"
+// column of Wireshark: unlike PacketType, the Payload, Fragment and
+// DecodeFailure layers are skipped, and HTTP over TCP is recognized.
+func (p Packet) Protocol() string {
+	for i := len(p.Layers) - 1; i >= 0; i-- {
+		switch t := p.Layers[i].LayerType; {
+		case t == gopacket.LayerTypePayload.String(),
+			t == gopacket.LayerTypeFragment.String(),
+			t == gopacket.LayerTypeDecodeFailure.String():
+			continue
+		case t == layers.LayerTypeTCP.String() && httpLine(p.Layers[i].Payload) != "":
+			return "HTTP"
+		case strings.HasPrefix(t, "ICMPv6"): // ICMPv6Echo, ICMPv6NeighborSolicitation, ...
+			return "ICMPv6"
+		case strings.HasPrefix(t, "IPv6"): // IPv6Fragment, IPv6HopByHop, ...
+			return "IPv6"
+		default:
+			return t
+		}
+	}
+	return "UNK"
+}
+
+// summary is the n-th packet in one line, like tshark.
+func (p Packet) summary(n int, first time.Time) string {
+	src, dst := p.addrs()
+	return fmt.Sprintf("%6d %11.6f %v → %v %v %v %v",
+		n, p.Timestamp.Sub(first).Seconds(), src, dst, p.Protocol(), p.Length, p.Info())
+}
+
+// addrs returns the src & dst of the highest network layer,
+// or the link layer if there's none, like the columns of Wireshark.
+func (p Packet) addrs() (src, dst string) {
+	for _, l := range p.Layers {
+		switch l.layer.(type) {
+		case gopacket.LinkLayer, gopacket.NetworkLayer:
+			src, dst = l.Src, l.Dst
+		}
+	}
+	return src, dst
+}
+
+var httpMethods = []string{"GET ", "POST ", "PUT ", "DELETE ", "HEAD ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+// httpLine returns the request (or status) line if the payload looks like
+// the start of an HTTP/1.x message.
+func httpLine(payload []byte) string {
+	line, _, found := bytes.Cut(payload, []byte("\r\n"))
+	if !found || len(line) > 1024 {
+		return ""
+	}
+	if bytes.HasPrefix(line, []byte("HTTP/1.")) {
+		return string(line)
+	}
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(line, []byte(m)) && bytes.Contains(line, []byte(" HTTP/1.")) {
+			return string(line)
+		}
+	}
+	return ""
+}
+
+// ethernetInfo summarizes the frames whose higher layers are not decoded
+// (e.g. by the fast path).
+func ethernetInfo(p *Packet, i int) string {
+	eth, ok := p.Layers[i].layer.(*layers.Ethernet)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("Ethernet II, Type %v (0x%04x), Len=%v", eth.EthernetType, uint16(eth.EthernetType), len(eth.Payload))
+}
+
+func arpInfo(p *Packet, i int) string {
+	arp, ok := p.Layers[i].layer.(*layers.ARP)
+	if !ok {
+		return ""
+	}
+	senderIP := net.IP(arp.SourceProtAddress)
+	switch arp.Operation {
+	case layers.ARPRequest:
+		return fmt.Sprintf("Who has %v? Tell %v", net.IP(arp.DstProtAddress), senderIP)
+	case layers.ARPReply:
+		return fmt.Sprintf("%v is at %v", senderIP, net.HardwareAddr(arp.SourceHwAddress))
+	}
+	return fmt.Sprintf("ARP opcode %v", arp.Operation)
+}
+
+// ipv4Info summarizes the fragments (not reassembled).
+func ipv4Info(p *Packet, i int) string {
+	ip, ok := p.Layers[i].layer.(*layers.IPv4)
+	if !ok || (ip.Flags&layers.IPv4MoreFragments == 0 && ip.FragOffset == 0) {
+		return ""
+	}
+	return fmt.Sprintf("Fragmented IP protocol (proto=%v %d, off=%v, ID=%04x)",
+		ip.Protocol, uint8(ip.Protocol), int(ip.FragOffset)*8, ip.Id)
+}
+
+func ipv6FragmentInfo(p *Packet, i int) string {
+	frag, ok := p.Layers[i].layer.(*layers.IPv6Fragment)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("IPv6 fragment (off=%v more=%v ident=0x%08x nxt=%d)",
+		int(frag.FragmentOffset)*8, frag.MoreFragments, frag.Identification, uint8(frag.NextHeader))
+}
+
+func icmpv4Info(p *Packet, i int) string {
+	icmp, ok := p.Layers[i].layer.(*layers.ICMPv4)
+	if !ok {
+		return ""
+	}
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv4TypeEchoRequest:
+		return fmt.Sprintf("Echo (ping) request id=0x%04x, seq=%v", icmp.Id, icmp.Seq)
+	case layers.ICMPv4TypeEchoReply:
+		return fmt.Sprintf("Echo (ping) reply id=0x%04x, seq=%v", icmp.Id, icmp.Seq)
+	}
+	return icmp.TypeCode.String()
+}
+
+func icmpv6Info(p *Packet, i int) string {
+	icmp, ok := p.Layers[i].layer.(*layers.ICMPv6)
+	if !ok {
+		return ""
+	}
+	return icmp.TypeCode.String()
+}
+
+func icmpv6EchoInfo(p *Packet, i int) string {
+	echo, ok := p.Layers[i].layer.(*layers.ICMPv6Echo)
+	if !ok || i == 0 {
+		return ""
+	}
+	kind := "request"
+	if icmp, ok := p.Layers[i-1].layer.(*layers.ICMPv6); ok && icmp.TypeCode.Type() == layers.ICMPv6TypeEchoReply {
+		kind = "reply"
+	}
+	return fmt.Sprintf("Echo (ping) %v id=0x%04x, seq=%v", kind, echo.Identifier, echo.SeqNumber)
+}
+
+func icmpv6NeighborInfo(p *Packet, i int) string {
+	switch l := p.Layers[i].layer.(type) {
+	case *layers.ICMPv6NeighborSolicitation:
+		return fmt.Sprintf("Neighbor Solicitation for %v", l.TargetAddress)
+	case *layers.ICMPv6NeighborAdvertisement:
+		return fmt.Sprintf("Neighbor Advertisement %v", l.TargetAddress)
+	}
+	return ""
+}
+
+// tcpFlags in the order of Wireshark.
+var tcpFlags = []struct {
+	name string
+	set  func(tcp *layers.TCP) bool
+}{
+	{"FIN", func(tcp *layers.TCP) bool { return tcp.FIN }},
+	{"SYN", func(tcp *layers.TCP) bool { return tcp.SYN }},
+	{"RST", func(tcp *layers.TCP) bool { return tcp.RST }},
+	{"PSH", func(tcp *layers.TCP) bool { return tcp.PSH }},
+	{"ACK", func(tcp *layers.TCP) bool { return tcp.ACK }},
+	{"URG", func(tcp *layers.TCP) bool { return tcp.URG }},
+	{"ECE", func(tcp *layers.TCP) bool { return tcp.ECE }},
+	{"CWR", func(tcp *layers.TCP) bool { return tcp.CWR }},
+	{"NS", func(tcp *layers.TCP) bool { return tcp.NS }},
+}
+
+func tcpInfo(p *Packet, i int) string {
+	tcp, ok := p.Layers[i].layer.(*layers.TCP)
+	if !ok {
+		return ""
+	}
+	if line := httpLine(tcp.Payload); line != "" {
+		return line
+	}
+
+	var flags []string
+	for _, f := range tcpFlags {
+		if f.set(tcp) {
+			flags = append(flags, f.name)
+		}
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d → %d [%v] Seq=%v", tcp.SrcPort, tcp.DstPort, strings.Join(flags, ", "), tcp.Seq)
+	if tcp.ACK {
+		fmt.Fprintf(&sb, " Ack=%v", tcp.Ack)
+	}
+	fmt.Fprintf(&sb, " Win=%v Len=%v", tcp.Window, len(tcp.Payload))
+	return sb.String()
+}
+
+func udpInfo(p *Packet, i int) string {
+	udp, ok := p.Layers[i].layer.(*layers.UDP)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%d → %d Len=%v", udp.SrcPort, udp.DstPort, len(udp.Payload))
+}
+
+// dnsResponseCodes are the names of the common DNS errors in Wireshark.
+var dnsResponseCodes = map[layers.DNSResponseCode]string{
+	layers.DNSResponseCodeFormErr:  "Format error",
+	layers.DNSResponseCodeServFail: "Server failure",
+	layers.DNSResponseCodeNXDomain: "No such name",
+	layers.DNSResponseCodeNotImp:   "Not implemented",
+	layers.DNSResponseCodeRefused:  "Refused",
+}
+
+func dnsInfo(p *Packet, i int) string {
+	dns, ok := p.Layers[i].layer.(*layers.DNS)
+	if !ok {
+		return ""
+	}
+
+	var sb strings.Builder
+	if dns.OpCode == layers.DNSOpCodeQuery {
+		sb.WriteString("Standard query")
+	} else {
+		sb.WriteString(dns.OpCode.String())
+	}
+	if dns.QR {
+		sb.WriteString(" response")
+	}
+	fmt.Fprintf(&sb, " 0x%04x", dns.ID)
+	if dns.QR && dns.ResponseCode != layers.DNSResponseCodeNoErr {
+		rcode, ok := dnsResponseCodes[dns.ResponseCode]
+		if !ok {
+			rcode = strings.TrimSpace(dns.ResponseCode.String())
+		}
+		sb.WriteString(" " + rcode)
+	}
+	for _, q := range dns.Questions {
+		fmt.Fprintf(&sb, " %v %s", q.Type, q.Name)
+	}
+	for _, a := range dns.Answers {
+		fmt.Fprintf(&sb, " %v", a.Type)
+		switch a.Type {
+		case layers.DNSTypeA, layers.DNSTypeAAAA:
+			fmt.Fprintf(&sb, " %v", a.IP)
+		case layers.DNSTypeCNAME:
+			fmt.Fprintf(&sb, " %s", a.CNAME)
+		case layers.DNSTypeNS:
+			fmt.Fprintf(&sb, " %s", a.NS)
+		case layers.DNSTypePTR:
+			fmt.Fprintf(&sb, " %s", a.PTR)
+		case layers.DNSTypeMX:
+			fmt.Fprintf(&sb, " %v %s", a.MX.Preference, a.MX.Name)
+		}
+	}
+	return sb.String()
+}
+
+func dhcpv4Info(p *Packet, i int) string {
+	dhcp, ok := p.Layers[i].layer.(*layers.DHCPv4)
+	if !ok {
+		return ""
+	}
+	msgType := "Message"
+	for _, o := range dhcp.Options {
+		if o.Type == layers.DHCPOptMessageType && len(o.Data) == 1 {
+			msgType = layers.DHCPMsgType(o.Data[0]).String()
+		}
+	}
+	return fmt.Sprintf("DHCP %v - Transaction ID 0x%08x", msgType, dhcp.Xid)
+}
+
+func tlsInfo(p *Packet, i int) string {
+	tls, ok := p.Layers[i].layer.(*layers.TLS)
+	if !ok {
+		return ""
+	}
+	var records []string
+	if len(tls.ChangeCipherSpec) > 0 {
+		records = append(records, "Change Cipher Spec")
+	}
+	if len(tls.Handshake) > 0 {
+		records = append(records, "Handshake")
+	}
+	if len(tls.AppData) > 0 {
+		records = append(records, "Application Data")
+	}
+	if len(tls.Alert) > 0 {
+		records = append(records, "Alert")
+	}
+	return strings.Join(records, ", ")
+}
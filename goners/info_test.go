@@ -0,0 +1,123 @@
+package goners
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func craftARPPacket(t testing.TB, operation uint16) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02},
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         operation,
+		SourceHwAddress:   []byte{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02},
+		SourceProtAddress: []byte{10, 0, 0, 2},
+		DstHwAddress:      []byte{0, 0, 0, 0, 0, 0},
+		DstProtAddress:    []byte{10, 0, 0, 1},
+	}
+	return serializeLayers(t, eth, arp)
+}
+
+func TestPacket_Info(t *testing.T) {
+	const request = "GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	response := craftDNSResponse(t, false, 1)
+	ipv6Fragments := fragment(t, craftDNSResponse(t, true, 100), true, 1200, 0)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		wantProtocol string
+		wantInfo     string
+	}{
+		{"dns query", craftDNSQueryPacket(t, "example.com"),
+			"DNS", "Standard query 0x002a A example.com"},
+		{"dns response", response,
+			"DNS", "Standard query response 0x002a A example.com A 192.0.2.0"},
+		{"tcp syn ack", tcpSegment{true, "SA", 500, 101, ""}.craft(t),
+			"TCP", "80 → 40000 [SYN, ACK] Seq=500 Ack=101 Win=65535 Len=0"},
+		{"tcp syn", tcpSegment{false, "S", 100, 0, ""}.craft(t),
+			"TCP", "40000 → 80 [SYN] Seq=100 Win=65535 Len=0"},
+		{"http request", tcpSegment{false, "PA", 101, 501, request}.craft(t),
+			"HTTP", "GET /index.html HTTP/1.1"},
+		{"http response", tcpSegment{true, "PA", 501, 101, "HTTP/1.1 200 OK\r\n\r\n"}.craft(t),
+			"HTTP", "HTTP/1.1 200 OK"},
+		{"not http", tcpSegment{false, "PA", 101, 501, "GET me a coffee\r\n"}.craft(t),
+			"TCP", "40000 → 80 [PSH, ACK] Seq=101 Ack=501 Win=65535 Len=17"},
+		{"arp request", craftARPPacket(t, layers.ARPRequest),
+			"ARP", "Who has 10.0.0.1? Tell 10.0.0.2"},
+		{"arp reply", craftARPPacket(t, layers.ARPReply),
+			"ARP", "10.0.0.2 is at 00:00:5e:00:53:02"},
+		{"ping", craftICMPPacket(t, 0x1234, 7, "ping"),
+			"ICMPv4", "Echo (ping) request id=0x1234, seq=7"},
+		{"ipv6 fragment", ipv6Fragments[0],
+			"IPv6", "IPv6 fragment (off=0 more=true ident=0xcafebabe nxt=17)"},
+		{"malformed", response[:len(response)-4],
+			"UDP", "53 → 40000 Len=52 [Malformed Packet]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPacket(gopacket.NewPacket(tt.data, layers.LinkTypeEthernet, gopacket.Default))
+			if got := p.Protocol(); got != tt.wantProtocol {
+				t.Errorf("❌ Protocol() = %v, want %v", got, tt.wantProtocol)
+			}
+			if got := p.Info(); got != tt.wantInfo {
+				t.Errorf("❌ Info() = %q, want %q", got, tt.wantInfo)
+			}
+		})
+	}
+}
+
+func TestSummaryPacketsFormater(t *testing.T) {
+	source := SyntheticSource{Link: layers.LinkTypeEthernet}
+	start := time.Unix(1678000000, 0)
+	for i, data := range [][]byte{
+		craftDNSQueryPacket(t, "example.com"),
+		craftARPPacket(t, layers.ARPRequest),
+	} {
+		source.Packets = append(source.Packets, SyntheticPacket{
+			Data: data,
+			CaptureInfo: gopacket.CaptureInfo{
+				Timestamp:     start.Add(time.Duration(i) * 1500 * time.Microsecond),
+				CaptureLength: len(data),
+				Length:        len(data),
+			},
+		})
+	}
+
+	tests := []struct {
+		capture CaptureFunc
+		want    []string
+	}{
+		{CapturePacketsFrom, []string{
+			"     1    0.000000 10.0.0.1 → 10.0.0.53 DNS 71 Standard query 0x002a A example.com",
+			"     2    0.001500 00:00:5e:00:53:02 → ff:ff:ff:ff:ff:ff ARP 60 Who has 10.0.0.1? Tell 10.0.0.2",
+		}},
+		{CapturePacketsFastFrom, []string{ // DNS & ARP are decoded by the slow path only
+			"     1    0.000000 10.0.0.1 → 10.0.0.53 UDP 71 40000 → 53 Len=29",
+			"     2    0.001500 00:00:5e:00:53:02 → ff:ff:ff:ff:ff:ff Ethernet 60 Ethernet II, Type ARP (0x0806), Len=46",
+		}},
+	}
+	for _, tt := range tests {
+		var lines []string
+		packets := tt.capture(context.Background(), mustOpen(t, source))
+		for line := range SummaryPacketsFormater.FormatPackets(packets) {
+			lines = append(lines, string(line))
+		}
+		if got, want := strings.Join(lines, "\n"), strings.Join(tt.want, "\n"); got != want {
+			t.Errorf("❌ SummaryPacketsFormater =\n%v\nwant\n%v", got, want)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package goners
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/exp/slog"
+)
+
+// AnyDevice captures from all the up devices.
+//
+// Different from the "any" pseudo-device of libpcap (Linux only),
+// each packet keeps the name of the device it captured from.
+const AnyDevice = "any"
+
+// Devices is a list of device names to capture from.
+//
+// In JSON, it is either a string ("eth0", "eth0,tun0" or "any")
+// or an array of strings.
+type Devices []string
+
+// ParseDevices parses comma separated device names: "eth0,tun0".
+func ParseDevices(s string) Devices {
+	var devices Devices
+	for _, d := range strings.Split(s, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			devices = append(devices, d)
+		}
+	}
+	return devices
+}
+
+func (d Devices) String() string {
+	return strings.Join(d, ",")
+}
+
+// MarshalJSON marshals a single device as a string, for compatibility.
+func (d Devices) MarshalJSON() ([]byte, error) {
+	if len(d) == 1 {
+		return json.Marshal(d[0])
+	}
+	return json.Marshal([]string(d))
+}
+
+func (d *Devices) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*d = ParseDevices(s)
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(data, &l); err != nil {
+		return fmt.Errorf("devices should be a string or an array of strings: %w", err)
+	}
+	*d = ParseDevices(strings.Join(l, ","))
+	return nil
+}
+
+// pcapIfUp is the PCAP_IF_UP flag of pcap.Interface.Flags.
+const pcapIfUp = 0x00000002
+
+// Expand replaces the AnyDevice with all the up devices found by libpcap.
+func (d Devices) Expand() (Devices, error) {
+	var expanded Devices
+	for _, device := range d {
+		if device != AnyDevice {
+			expanded = append(expanded, device)
+			continue
+		}
+		ifs, err := pcap.FindAllDevs()
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range ifs {
+			if i.Name != AnyDevice && i.Flags&pcapIfUp != 0 {
+				expanded = append(expanded, i.Name)
+			}
+		}
+	}
+	if len(expanded) == 0 {
+		return nil, fmt.Errorf("no device to capture")
+	}
+	return expanded, nil
+}
+
+// LiveSources returns a LiveSource for each of the devices,
+// with AnyDevice expanded (into Optional LiveSources).
+func LiveSources(devices Devices, snaplen int32, promisc bool, timeout time.Duration) ([]PacketSourceProvider, error) {
+	var sources []PacketSourceProvider
+	for _, device := range devices {
+		expanded, err := Devices{device}.Expand()
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range expanded {
+			sources = append(sources, LiveSource{
+				Device:   d,
+				Snaplen:  snaplen,
+				Promisc:  promisc,
+				Timeout:  timeout,
+				Optional: device == AnyDevice,
+			})
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no device to capture")
+	}
+	return sources, nil
+}
+
+// DeviceNames returns the device names of the LiveSources
+// ("" for other providers), for CapturePacketsFromAll.
+func DeviceNames(providers []PacketSourceProvider) []string {
+	names := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		var name string
+		if live, ok := provider.(LiveSource); ok {
+			name = live.Device
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// OpenPacketSources is OpenPacketSource for each of the providers.
+// It returns the opened sources, and their providers.
+//
+// If any of them fails, the opened ones are closed. Except that
+// Optional LiveSources (expanded from AnyDevice) failed to open
+// (or to compile the bpf for their link types) are skipped with a warning,
+// as long as any other source is opened.
+func OpenPacketSources(providers []PacketSourceProvider, bpf string) ([]PacketSource, []PacketSourceProvider, error) {
+	sources := make([]PacketSource, 0, len(providers))
+	opened := make([]PacketSourceProvider, 0, len(providers))
+	var skipped error
+	for _, provider := range providers {
+		source, err := OpenPacketSource(provider, bpf)
+		if err != nil {
+			if live, ok := provider.(LiveSource); ok && live.Optional {
+				slog.Warn("OpenPacketSources: skip the device failed to open.",
+					"device", live.Device, "err", err)
+				skipped = fmt.Errorf("%v: %w", provider, err)
+				continue
+			}
+			for _, s := range sources {
+				s.Close()
+			}
+			return nil, nil, fmt.Errorf("%v: %w", provider, err)
+		}
+		sources = append(sources, source)
+		opened = append(opened, provider)
+	}
+	if len(sources) == 0 && skipped != nil {
+		return nil, nil, skipped
+	}
+	return sources, opened, nil
+}
+
+// CapturePacketsFromAll captures packets from all the sources with
+// the capture func (nil for CapturePacketsFrom) and merges them into a single time-ordered
+// stream (see MergePackets).
+//
+// The packets are tagged with the names[i] (if not empty) of their source.
+// With more than one source, DeviceIndex is set to the index i of the source.
+func CapturePacketsFromAll(ctx context.Context, sources []PacketSource, names []string, capture CaptureFunc) chan *Packet {
+	if capture == nil {
+		capture = CapturePacketsFrom
+	}
+	ins := make([]chan *Packet, 0, len(sources))
+	for i, source := range sources {
+		var name string
+		if i < len(names) {
+			name = names[i]
+		}
+		ins = append(ins, tagPackets(capture(ctx, source), i, name, len(sources) > 1))
+	}
+	if len(ins) == 1 {
+		return ins[0]
+	}
+
+	merging := make([]<-chan *Packet, 0, len(ins))
+	for _, in := range ins {
+		merging = append(merging, in)
+	}
+	return MergePackets(merging...)
+}
+
+// tagPackets sets the Device (if not empty) and DeviceIndex (if setIndex).
+func tagPackets(in chan *Packet, index int, name string, setIndex bool) chan *Packet {
+	if name == "" && !setIndex {
+		return in
+	}
+	out := make(chan *Packet, ChanBufSize)
+	go func() {
+		defer close(out)
+		for p := range in {
+			if name != "" {
+				p.Device = name
+			}
+			if setIndex {
+				p.DeviceIndex = index
+			}
+			out <- p
+		}
+	}()
+	return out
+}
+
+// MergeWindow is how long MergePackets waits for a silent input
+// before sending the earliest packet it already has.
+//
+// Packets delayed more than it may be out of order.
+var MergeWindow = 100 * time.Millisecond
+
+// MergePackets merges packets from all ins into a single stream,
+// ordered by the Timestamp. The returned chan is closed after
+// all ins are closed.
+//
+// Inputs are expected to be time-ordered themselves (like captures).
+// An input that is silent for MergeWindow does not block the others.
+func MergePackets(ins ...<-chan *Packet) chan *Packet {
+	out := make(chan *Packet, ChanBufSize)
+
+	type queued struct {
+		packet  *Packet
+		arrived time.Time
+	}
+	type event struct {
+		index  int
+		packet *Packet // nil: input closed
+	}
+
+	events := make(chan event, ChanBufSize)
+	for i, in := range ins {
+		go func(i int, in <-chan *Packet) {
+			for p := range in {
+				events <- event{index: i, packet: p}
+			}
+			events <- event{index: i}
+		}(i, in)
+	}
+
+	go func() {
+		defer close(out)
+
+		queues := make([][]queued, len(ins))
+		closed := make([]bool, len(ins))
+		remaining := len(ins) // inputs not closed
+
+		// earliest returns the index of the queue with the earliest head,
+		// and whether every input has a head or is closed.
+		earliest := func() (index int, ready bool) {
+			index, ready = -1, true
+			for i, q := range queues {
+				if len(q) == 0 {
+					ready = ready && closed[i]
+					continue
+				}
+				if index < 0 || q[0].packet.Timestamp.Before(queues[index][0].packet.Timestamp) {
+					index = i
+				}
+			}
+			return index, ready && index >= 0
+		}
+		// oldest returns when the longest waiting packet arrived.
+		oldest := func() (arrived time.Time) {
+			for _, q := range queues {
+				if len(q) > 0 && (arrived.IsZero() || q[0].arrived.Before(arrived)) {
+					arrived = q[0].arrived
+				}
+			}
+			return arrived
+		}
+		send := func(index int) {
+			out <- queues[index][0].packet
+			queues[index] = queues[index][1:]
+		}
+
+		for {
+			index, ready := earliest()
+			if ready {
+				send(index)
+				continue
+			}
+			if index < 0 && remaining == 0 {
+				return
+			}
+
+			var timeout <-chan time.Time
+			var timer *time.Timer
+			if index >= 0 {
+				timer = time.NewTimer(time.Until(oldest().Add(MergeWindow)))
+				timeout = timer.C
+			}
+
+			select {
+			case e := <-events:
+				if e.packet == nil {
+					closed[e.index] = true
+					remaining--
+				} else {
+					queues[e.index] = append(queues[e.index], queued{e.packet, time.Now()})
+				}
+			case <-timeout: // some inputs are silent: don't wait for them
+				send(index)
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,153 @@
+package goners
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestDevices_JSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		want     Devices
+		wantJSON string
+		wantErr  bool
+	}{
+		{"single", `"eth0"`, Devices{"eth0"}, `"eth0"`, false},
+		{"commas", `"eth0, tun0"`, Devices{"eth0", "tun0"}, `["eth0","tun0"]`, false},
+		{"list", `["eth0","tun0"]`, Devices{"eth0", "tun0"}, `["eth0","tun0"]`, false},
+		{"any", `"any"`, Devices{AnyDevice}, `"any"`, false},
+		{"empty", `""`, nil, `null`, false},
+		{"bad", `42`, nil, ``, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Devices
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("❌ Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("❌ Unmarshal() = %#v, want %#v", got, tt.want)
+			}
+			j, err := json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(j) != tt.wantJSON {
+				t.Errorf("❌ Marshal() = %s, want %s", j, tt.wantJSON)
+			}
+		})
+	}
+}
+
+func TestCapturePacketsFromAll(t *testing.T) {
+	// two sources, timestamps interleaved: a0 b0 a1 b1 ...
+	start := time.Unix(1678000000, 0)
+	newSource := func(offset time.Duration, dstPort uint16) PacketSource {
+		source := SyntheticSource{Link: layers.LinkTypeEthernet}
+		for i := 0; i < 50; i++ {
+			source.Packets = append(source.Packets, SyntheticPacket{
+				Data: craftTCPPacket(t, 40000, dstPort, []byte("hello")),
+				CaptureInfo: gopacket.CaptureInfo{
+					Timestamp: start.Add(time.Duration(i)*time.Millisecond + offset),
+				},
+			})
+		}
+		s, err := source.OpenPacketSource()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	sources := []PacketSource{newSource(0, 80), newSource(time.Microsecond, 443)}
+	packets := CapturePacketsFromAll(context.Background(), sources, []string{"eth0", "tun0"}, nil)
+
+	var last time.Time
+	count := 0
+	for p := range packets {
+		if p.Timestamp.Before(last) {
+			t.Errorf("❌ packet %v out of order: %v before %v", count, p.Timestamp, last)
+		}
+		last = p.Timestamp
+
+		wantIndex, wantDevice := 0, "eth0"
+		if p.packet.TransportLayer().TransportFlow().Dst().String() == "443" {
+			wantIndex, wantDevice = 1, "tun0"
+		}
+		if p.DeviceIndex != wantIndex || p.Device != wantDevice {
+			t.Errorf("❌ packet %v: DeviceIndex = %v, Device = %q, want %v, %q",
+				count, p.DeviceIndex, p.Device, wantIndex, wantDevice)
+		}
+		count++
+	}
+	if count != 100 {
+		t.Errorf("❌ got %v packets, want %v", count, 100)
+	}
+}
+
+func TestMergePackets_silentInput(t *testing.T) {
+	silent := make(chan *Packet)
+	defer close(silent)
+
+	busy := make(chan *Packet, 2)
+	busy <- &Packet{Timestamp: time.Unix(1, 0)}
+	busy <- &Packet{Timestamp: time.Unix(2, 0)}
+	close(busy)
+
+	merged := MergePackets(busy, silent)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-merged:
+		case <-time.After(MergeWindow * 10):
+			t.Fatalf("❌ blocked by the silent input")
+		}
+	}
+}
+
+func TestOpenPacketSources_optional(t *testing.T) {
+	synthetic := newTestSyntheticSource(t, 5)
+	noexists := LiveSource{Device: "noexists0", Snaplen: 1500, Timeout: BlockForever}
+	optional := noexists
+	optional.Optional = true
+
+	tests := []struct {
+		name      string
+		providers []PacketSourceProvider
+		wantOpen  int
+		wantErr   bool
+	}{
+		{"all", []PacketSourceProvider{synthetic, synthetic}, 2, false},
+		{"skip optional", []PacketSourceProvider{optional, synthetic, optional}, 1, false},
+		{"all optional failed", []PacketSourceProvider{optional, optional}, 0, true},
+		{"required failed", []PacketSourceProvider{synthetic, noexists}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources, providers, err := OpenPacketSources(tt.providers, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("❌ OpenPacketSources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(sources) != tt.wantOpen || len(providers) != tt.wantOpen {
+				t.Errorf("❌ OpenPacketSources() opened %v sources of %v providers, want %v",
+					len(sources), len(providers), tt.wantOpen)
+			}
+			for i, source := range sources {
+				if _, ok := providers[i].(SyntheticSource); !ok {
+					t.Errorf("❌ OpenPacketSources() providers[%v] = %v", i, providers[i])
+				}
+				source.Close()
+			}
+		})
+	}
+}
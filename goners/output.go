@@ -1,11 +1,17 @@
 package goners
 
 import (
-	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
 
 	"github.com/cdfmlr/goners/wsforwarder"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
 	"golang.org/x/exp/slog"
 	"golang.org/x/net/websocket"
 )
@@ -17,7 +23,12 @@ type Outputer interface {
 
 // fileOutputer outputs to a file: one data one line
 type fileOutputer struct {
-	file io.WriteCloser
+	file   io.WriteCloser
+	name   string
+	header []byte // written at the head of each file
+
+	written atomic.Int64
+	dropped atomic.Int64
 }
 
 func NewFileOutputer(file string) (Outputer, error) {
@@ -25,19 +36,95 @@ func NewFileOutputer(file string) (Outputer, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &fileOutputer{file: f}, nil
+	return &fileOutputer{file: f, name: file}, nil
 }
 
-func (o fileOutputer) Output(in <-chan []byte) {
-	for data := range in {
-		o.file.Write(data)
+// NewRotatingFileOutputer is a NewFileOutputer that rotates
+// the output file as the rotate options say.
+func NewRotatingFileOutputer(file string, rotate RotateOptions) (Outputer, error) {
+	if !rotate.Enabled() {
+		return NewFileOutputer(file)
+	}
+	f, err := newRotatingFile(file, rotate)
+	if err != nil {
+		return nil, err
+	}
+	return &fileOutputer{file: f, name: file}, nil
+}
+
+func (o *fileOutputer) setHeader(header []byte) {
+	o.header = header
+}
+
+func (o *fileOutputer) Output(in <-chan []byte) {
+	defer o.file.Close()
+
+	r, rotating := o.file.(rotator)
+	var expired <-chan time.Time
+	if rotating {
+		expired = r.Expired()
+	}
+	headed := false // the header & records are written into the current file
+
+	rotate := func() bool {
+		if err := r.Rotate(); err != nil {
+			slog.Error("fileOutputer: rotate failed.", "err", err)
+			o.dropped.Add(1)
+			for range in { // drain
+				o.dropped.Add(1)
+			}
+			return false
+		}
+		headed = false
+		return true
+	}
+
+	for {
+		var data []byte
+		var ok bool
+		select {
+		case data, ok = <-in:
+		case <-expired:
+			if headed && r.Due() && !rotate() {
+				return
+			}
+			continue
+		}
+		if !ok {
+			return
+		}
+
+		if rotating && headed && r.Due() && !rotate() {
+			return
+		}
+		if !headed && o.header != nil {
+			o.file.Write(o.header)
+			o.file.Write([]byte("\n"))
+		}
+		headed = true
+		if _, err := o.file.Write(data); err != nil {
+			o.dropped.Add(1)
+			continue
+		}
 		o.file.Write([]byte("\n"))
+		o.written.Add(1)
+	}
+}
+
+func (o *fileOutputer) OutputStats() OutputStats {
+	return OutputStats{
+		Name:    "file:" + o.name,
+		Written: o.written.Load(),
+		Dropped: o.dropped.Load(),
 	}
-	o.file.Close()
 }
 
 type webSocketOutputer struct {
 	forwarder wsforwarder.Forwarder
+	header    atomic.Pointer[[]byte] // sent to each client first
+
+	written atomic.Int64 // forwarded to the connected clients
+	dropped atomic.Int64 // no client connected
 }
 
 func NewWebSocketOutputer() (Outputer, websocket.Handler) {
@@ -46,14 +133,347 @@ func NewWebSocketOutputer() (Outputer, websocket.Handler) {
 	}
 
 	handler := websocket.Handler(func(c *websocket.Conn) {
+		if header := wso.header.Load(); header != nil {
+			if _, err := c.Write(*header); err != nil {
+				c.Close()
+				return
+			}
+		}
 		wso.forwarder.ForwardMessageTo(c)
 	})
 
 	return wso, handler
 }
 
-func (o webSocketOutputer) Output(in <-chan []byte) {
-	o.forwarder.ForwardMessageFrom(in)
+func (o *webSocketOutputer) setHeader(header []byte) {
+	o.header.Store(&header)
+}
+
+func (o *webSocketOutputer) Output(in <-chan []byte) {
+	o.forwarder.ForwardMessageFrom(o.count(in))
+}
+
+// count counts the messages passed through:
+// they are dropped if there is no client connected.
+func (o *webSocketOutputer) count(in <-chan []byte) <-chan []byte {
+	out := make(chan []byte, ChanBufSize)
+	go func() {
+		defer close(out)
+		for data := range in {
+			if o.Clients() > 0 {
+				o.written.Add(1)
+			} else {
+				o.dropped.Add(1)
+			}
+			out <- data
+		}
+	}()
+	return out
+}
+
+// Clients returns the number of connected WebSocket clients.
+func (o *webSocketOutputer) Clients() int {
+	return o.forwarder.Clients()
+}
+
+func (o *webSocketOutputer) OutputStats() OutputStats {
+	return OutputStats{
+		Name:    "ws",
+		Written: o.written.Load(),
+		Dropped: o.dropped.Load(),
+	}
+}
+
+// PacketsOutputer recv packets from chan in & write them to somewhere.
+//
+// Different from Outputer, it works with the raw packets
+// instead of the formatted data (e.g. writes a pcap file).
+type PacketsOutputer interface {
+	OutputPackets(in <-chan *Packet) // OutputPackets blocks.
+}
+
+// PcapFileFormat is the format of files written by the pcapOutputer.
+type PcapFileFormat string
+
+const (
+	PcapFormat   PcapFileFormat = "pcap"
+	PcapngFormat PcapFileFormat = "pcapng"
+)
+
+// PcapFileFormatOf guesses the PcapFileFormat by the file extension:
+// PcapngFormat for "*.pcapng", otherwise PcapFormat.
+func PcapFileFormatOf(file string) PcapFileFormat {
+	if filepath.Ext(file) == ".pcapng" {
+		return PcapngFormat
+	}
+	return PcapFormat
+}
+
+// CheckLinkTypes reports an error if the packets from the sources can't
+// be written into a file of the format together: a pcap file has a
+// single link type (in its header), while a pcapng file has one for
+// each of its interfaces.
+func (f PcapFileFormat) CheckLinkTypes(sources []PacketSource) error {
+	if f == PcapngFormat || len(sources) == 0 {
+		return nil
+	}
+	first := sources[0].LinkType()
+	for _, source := range sources[1:] {
+		if source.LinkType() != first {
+			return fmt.Errorf("sources of link types %v and %v can't be written into a single pcap file: use pcapng instead",
+				first, source.LinkType())
+		}
+	}
+	return nil
+}
+
+// pcapWriter is implemented by both pcapgo.Writer and pcapgo.NgWriter.
+type pcapWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+}
+
+// pcapOutputer writes the raw packets into a pcap or pcapng file,
+// which can be reopened by goners read, tcpdump -r or Wireshark.
+type pcapOutputer struct {
+	file    io.WriteCloser
+	format  PcapFileFormat
+	name    string
+	snaplen int // of the file header
+
+	written atomic.Int64
+	dropped atomic.Int64
+}
+
+// NewPcapOutputer writes packets into the file. The snaplen (the one
+// the packets are captured with) goes into the file header:
+// 0 means DefaultSnaplen.
+func NewPcapOutputer(file string, format PcapFileFormat, snaplen int) (PacketsOutputer, error) {
+	if format != PcapFormat && format != PcapngFormat {
+		return nil, fmt.Errorf("unknown pcap file format: %v", format)
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return newPcapOutputer(f, format, file, snaplen), nil
+}
+
+// NewRotatingPcapOutputer is a NewPcapOutputer that rotates
+// the output file as the rotate options say.
+// Each of the files is a complete pcap or pcapng file.
+func NewRotatingPcapOutputer(file string, format PcapFileFormat, snaplen int, rotate RotateOptions) (PacketsOutputer, error) {
+	if !rotate.Enabled() {
+		return NewPcapOutputer(file, format, snaplen)
+	}
+	if format != PcapFormat && format != PcapngFormat {
+		return nil, fmt.Errorf("unknown pcap file format: %v", format)
+	}
+	f, err := newRotatingFile(file, rotate)
+	if err != nil {
+		return nil, err
+	}
+	return newPcapOutputer(f, format, file, snaplen), nil
+}
+
+func newPcapOutputer(f io.WriteCloser, format PcapFileFormat, name string, snaplen int) *pcapOutputer {
+	if snaplen <= 0 {
+		snaplen = DefaultSnaplen
+	}
+	return &pcapOutputer{file: f, format: format, name: name, snaplen: snaplen}
+}
+
+// ngInterface is an interface of a pcapng file:
+// packets of each device & link type go into their own interface.
+type ngInterface struct {
+	device   string
+	linkType layers.LinkType
+}
+
+// OutputPackets writes packets captured by CaptureLivePackets or
+// CaptureFilePackets.
+//
+// A pcap file header is written with the link type of the first packet:
+// packets of other link types are dropped (see CheckLinkTypes).
+// A pcapng file gets an interface for each device & link type instead.
+func (o *pcapOutputer) OutputPackets(in <-chan *Packet) {
+	defer o.file.Close()
+
+	var w pcapWriter
+	var ngWriter *pcapgo.NgWriter
+	var interfaces map[ngInterface]int // of the pcapng file: index by the device & link type
+
+	r, rotating := o.file.(rotator)
+	var expired <-chan time.Time
+	if rotating {
+		expired = r.Expired()
+	}
+
+	drain := func() {
+		for p := range in {
+			o.dropped.Add(1)
+			p.Release()
+		}
+	}
+
+	// rotate to a new file, whose header is written with its first packet
+	rotate := func() bool {
+		if ngWriter != nil {
+			if err := ngWriter.Flush(); err != nil {
+				slog.Error("pcapOutputer: flush failed.", "err", err)
+			}
+		}
+		if err := r.Rotate(); err != nil {
+			slog.Error("pcapOutputer: rotate failed.", "err", err)
+			o.dropped.Add(1)
+			drain()
+			return false
+		}
+		w, ngWriter, interfaces = nil, nil, nil
+		return true
+	}
+
+	newInterface := func(p *Packet) pcapgo.NgInterface {
+		intf := pcapgo.DefaultNgInterface
+		if p.Device != "" {
+			intf.Name = p.Device
+		}
+		intf.LinkType = p.linkType
+		intf.SnapLength = uint32(o.snaplen)
+		return intf
+	}
+
+	var linkType layers.LinkType // of the pcap file header
+	var warned bool
+
+	for {
+		var p *Packet
+		var ok bool
+		select {
+		case p, ok = <-in:
+		case <-expired:
+			if w != nil && r.Due() && !rotate() {
+				return
+			}
+			continue
+		}
+		if !ok {
+			break
+		}
+
+		if p.data == nil {
+			o.dropped.Add(1)
+			p.Release()
+			continue
+		}
+		if w != nil && ngWriter == nil && p.linkType != linkType {
+			// e.g. captured from devices of different link types
+			if !warned {
+				slog.Warn("pcapOutputer: drop packets of another link type: use pcapng instead.",
+					"linkType", p.linkType, "fileLinkType", linkType)
+				warned = true
+			}
+			o.dropped.Add(1)
+			p.Release()
+			continue
+		}
+
+		if rotating && w != nil && r.Due() && !rotate() {
+			return
+		}
+
+		if w == nil { // first packet: write file header
+			linkType = p.linkType
+			var err error
+			switch o.format {
+			case PcapngFormat:
+				ngWriter, err = pcapgo.NewNgWriterInterface(o.file, newInterface(p), pcapgo.DefaultNgWriterOptions)
+				interfaces = map[ngInterface]int{{p.Device, p.linkType}: 0}
+				w = ngWriter
+			default:
+				pw := pcapgo.NewWriter(o.file)
+				err = pw.WriteFileHeader(uint32(o.snaplen), p.linkType)
+				w = pw
+			}
+			if err != nil {
+				slog.Error("pcapOutputer: write file header failed.", "err", err)
+				o.dropped.Add(1)
+				drain()
+				return
+			}
+		}
+
+		ci := p.ci
+		if ngWriter != nil {
+			key := ngInterface{p.Device, p.linkType}
+			index, ok := interfaces[key]
+			if !ok {
+				var err error
+				if index, err = ngWriter.AddInterface(newInterface(p)); err != nil {
+					slog.Error("pcapOutputer: add interface failed.", "err", err)
+					o.dropped.Add(1)
+					p.Release()
+					continue
+				}
+				interfaces[key] = index
+			}
+			ci.InterfaceIndex = index
+		}
+
+		if err := w.WritePacket(ci, p.frame()); err != nil {
+			slog.Error("pcapOutputer: write packet failed.", "err", err)
+			o.dropped.Add(1)
+		} else {
+			o.written.Add(1)
+		}
+		p.Release()
+	}
+
+	if ngWriter != nil {
+		if err := ngWriter.Flush(); err != nil {
+			slog.Error("pcapOutputer: flush failed.", "err", err)
+		}
+	}
+}
+
+// Close closes the file of an outputer that is never started
+// (e.g. the session failed to start). OutputPackets closes it by itself.
+func (o *pcapOutputer) Close() error {
+	return o.file.Close()
+}
+
+func (o *pcapOutputer) OutputStats() OutputStats {
+	return OutputStats{
+		Name:    string(o.format) + ":" + o.name,
+		Written: o.written.Load(),
+		Dropped: o.dropped.Load(),
+	}
+}
+
+// TeePackets copies each packet from in to all the n returned chans.
+// A slow reader of any returned chan slows down all of them.
+func TeePackets(in <-chan *Packet, n int) []<-chan *Packet {
+	outs := make([]chan *Packet, n)
+	ret := make([]<-chan *Packet, n)
+	for i := range outs {
+		outs[i] = make(chan *Packet, ChanBufSize)
+		ret[i] = outs[i]
+	}
+
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+		for p := range in {
+			p.retain(n - 1) // each reader releases it
+			for _, out := range outs {
+				out <- p
+			}
+		}
+	}()
+
+	return ret
 }
 
 // PacketsFormater helps converting CaptureLivePackets.out into Output.in
@@ -61,6 +481,46 @@ type PacketsFormater interface {
 	FormatPackets(in <-chan *Packet) <-chan []byte
 }
 
+// HeaderFormater is a PacketsFormater whose output has a header
+// (e.g. the field names of the fields format) before the packets.
+type HeaderFormater interface {
+	PacketsFormater
+	Header() []byte // nil for no header
+}
+
+// headerOutputer is implemented by the Outputers writing the header
+// by themselves: at the head of each (rotated) file, to each client.
+type headerOutputer interface {
+	setHeader(header []byte)
+}
+
+// FormatPacketsFor formats the packets to be output by out.
+//
+// The header of a HeaderFormater goes to out, if it writes the header
+// by itself (as the file & WebSocket Outputers do).
+// Otherwise it is the first output.
+func FormatPacketsFor(out Outputer, f PacketsFormater, in <-chan *Packet) <-chan []byte {
+	hf, ok := f.(HeaderFormater)
+	if !ok || hf.Header() == nil {
+		return f.FormatPackets(in)
+	}
+	if o, ok := out.(headerOutputer); ok {
+		o.setHeader(hf.Header())
+		return f.FormatPackets(in)
+	}
+
+	formatted := f.FormatPackets(in)
+	withHeader := make(chan []byte, ChanBufSize)
+	go func() {
+		defer close(withHeader)
+		withHeader <- hf.Header()
+		for data := range formatted {
+			withHeader <- data
+		}
+	}()
+	return withHeader
+}
+
 type PacketsFormaterFunc func(in <-chan *Packet) <-chan []byte
 
 func (f PacketsFormaterFunc) FormatPackets(in <-chan *Packet) <-chan []byte {
@@ -72,26 +532,90 @@ func (f PacketsFormaterFunc) FormatPackets(in <-chan *Packet) <-chan []byte {
 var StringPacketsFormater = PacketsFormaterFunc(func(in <-chan *Packet) <-chan []byte {
 	out := make(chan []byte, ChanBufSize)
 	go func() {
+		defer close(out)
 		for p := range in {
 			out <- []byte(p.String())
+			p.Release()
 		}
 	}()
 	return out
 })
 
-// StringPacketsFormater formats recved input packets into JSON bytes,
-// and send them to the returned output chan.
-var JsonPacketsFormater = PacketsFormaterFunc(func(in <-chan *Packet) <-chan []byte {
+// SummaryPacketsFormater formats recved input packets into one-line
+// summaries like tshark: number, seconds since the first packet,
+// source -> destination, protocol, length and Info.
+var SummaryPacketsFormater = PacketsFormaterFunc(func(in <-chan *Packet) <-chan []byte {
 	out := make(chan []byte, ChanBufSize)
 	go func() {
+		defer close(out)
+		var n int
+		var first time.Time
 		for p := range in {
-			j, err := json.Marshal(p)
-			if err != nil {
-				slog.Error("JsonPacketsFormater: marshal packet failed.", "err", err)
-				continue
+			n++
+			if n == 1 {
+				first = p.Timestamp
 			}
-			out <- j
+			out <- []byte(p.summary(n, first))
+			p.Release()
 		}
 	}()
 	return out
 })
+
+// JsonPacketsFormater formats recved input packets into JSON bytes,
+// and send them to the returned output chan.
+var JsonPacketsFormater = NewJsonPacketsFormater(LayerAllDetails)
+
+// NewJsonPacketsFormater formats packets into JSON bytes, with only the
+// selected details of layers: skipping the costly field trees & hex dumps
+// helps a lot on busy links.
+func NewJsonPacketsFormater(detail LayerDetail) PacketsFormater {
+	return PacketsFormaterFunc(func(in <-chan *Packet) <-chan []byte {
+		out := make(chan []byte, ChanBufSize)
+		go func() {
+			defer close(out)
+			for p := range in {
+				j, err := p.MarshalJSONDetail(detail)
+				p.Release()
+				if err != nil {
+					slog.Error("JsonPacketsFormater: marshal packet failed.", "err", err)
+					continue
+				}
+				out <- j
+			}
+		}()
+		return out
+	})
+}
+
+// NewJsonProfilePacketsFormater formats packets into JSON bytes (one line
+// per packet, i.e. NDJSON) in the profile of the options:
+//
+//	summary: the flow, type, length & info of packets, no layers
+//	fields: the layers with the field trees, but no hex dumps
+//	full: the layers with all details, same as JsonPacketsFormater
+//
+// The payloads of layers can be omitted or truncated as well,
+// which makes the output much smaller.
+func NewJsonProfilePacketsFormater(opts JsonOptions) (PacketsFormater, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return PacketsFormaterFunc(func(in <-chan *Packet) <-chan []byte {
+		out := make(chan []byte, ChanBufSize)
+		go func() {
+			defer close(out)
+			for p := range in {
+				j, err := p.MarshalJSONOptions(opts)
+				p.Release()
+				if err != nil {
+					slog.Error("JsonPacketsFormater: marshal packet failed.", "err", err)
+					continue
+				}
+				out <- j
+			}
+		}()
+		return out
+	}), nil
+}
@@ -1,13 +1,20 @@
 package goners
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
 	"golang.org/x/net/websocket"
 )
 
@@ -125,3 +132,194 @@ func TestWebSocketOutput_Output(t *testing.T) {
 		})
 	}
 }
+
+func TestWebSocketOutputer_header(t *testing.T) {
+	o, handler := NewWebSocketOutputer()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	o.(headerOutputer).setHeader([]byte("ip.src,ip.dst"))
+
+	in := make(chan []byte)
+	go o.Output(in)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	for i := 0; i < 2; i++ { // each client gets the header first
+		client, err := websocket.Dial(url, "", "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var header string
+		if err := websocket.Message.Receive(client, &header); err != nil || header != "ip.src,ip.dst" {
+			t.Errorf("❌ client %v: first message = %q, %v, want the header", i, header, err)
+		}
+
+		row := fmt.Sprintf("10.0.0.%v,10.0.0.2", i)
+		for o.(*webSocketOutputer).Clients() < i+1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		in <- []byte(row)
+		var got string
+		if err := websocket.Message.Receive(client, &got); err != nil || got != row {
+			t.Errorf("❌ client %v: message = %q, %v, want %q", i, got, err, row)
+		}
+		defer client.Close()
+	}
+	close(in)
+}
+
+func TestPcapOutputer_OutputPackets(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	src := path.Join(tmpdir, "src.pcap")
+	writeTestPcapFile(t, src, 5, false)
+
+	tests := []struct {
+		name   string
+		file   string
+		format PcapFileFormat
+	}{
+		{"pcap", path.Join(tmpdir, "out.pcap"), PcapFormat},
+		{"pcapng", path.Join(tmpdir, "out.pcapng"), PcapngFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PcapFileFormatOf(tt.file); got != tt.format {
+				t.Errorf("❌ PcapFileFormatOf(%v) = %v, want %v", tt.file, got, tt.format)
+			}
+
+			o, err := NewPcapOutputer(tt.file, tt.format, 1500)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packets, err := CaptureFilePackets(context.Background(), src, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sent []*Packet
+			done := make(chan struct{})
+			tee := TeePackets(packets, 2)
+			go func() {
+				for p := range tee[1] {
+					sent = append(sent, p)
+				}
+				close(done)
+			}()
+			o.OutputPackets(tee[0]) // blocks until EOF
+			<-done
+
+			readback, err := CaptureFilePackets(context.Background(), tt.file, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			i := 0
+			for p := range readback {
+				if i >= len(sent) {
+					t.Fatalf("❌ read back more packets than sent")
+				}
+				if !bytes.Equal(p.packet.Data(), sent[i].packet.Data()) || !p.Timestamp.Equal(sent[i].Timestamp) {
+					t.Errorf("❌ packet %v mismatch: got %v, want %v", i, p, sent[i])
+				}
+				i++
+			}
+			if i != 5 {
+				t.Errorf("❌ read back %v packets, want 5", i)
+			}
+
+			h, err := openFileHandle(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer h.Close()
+			if h.snaplen != 1500 {
+				t.Errorf("❌ snaplen of the file header = %v, want 1500", h.snaplen)
+			}
+		})
+	}
+}
+
+func TestPcapOutputer_linkTypes(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// eth0 (Ethernet) and tun0 (raw IP), 3 packets each
+	newSources := func() []PacketSource {
+		eth := SyntheticSource{Link: layers.LinkTypeEthernet}
+		raw := SyntheticSource{Link: layers.LinkTypeRaw}
+		start := time.Unix(1678000000, 0)
+		for i := 0; i < 3; i++ {
+			data := craftTCPPacket(t, 40000, 443, []byte("hello"))
+			ts := start.Add(time.Duration(i) * time.Millisecond)
+			eth.Packets = append(eth.Packets, SyntheticPacket{Data: data, CaptureInfo: gopacket.CaptureInfo{Timestamp: ts}})
+			raw.Packets = append(raw.Packets, SyntheticPacket{Data: data[14:], CaptureInfo: gopacket.CaptureInfo{Timestamp: ts.Add(time.Microsecond)}})
+		}
+		return []PacketSource{mustOpen(t, eth), mustOpen(t, raw)}
+	}
+
+	if err := PcapFormat.CheckLinkTypes(newSources()); err == nil {
+		t.Errorf("❌ PcapFormat.CheckLinkTypes(Ethernet, Raw) error = nil")
+	}
+	if err := PcapngFormat.CheckLinkTypes(newSources()); err != nil {
+		t.Errorf("❌ PcapngFormat.CheckLinkTypes(Ethernet, Raw) error = %v", err)
+	}
+
+	// pcapng: an interface for each device & link type
+	file := path.Join(tmpdir, "out.pcapng")
+	o, err := NewPcapOutputer(file, PcapngFormat, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.OutputPackets(CapturePacketsFromAll(context.Background(), newSources(), []string{"eth0", "tun0"}, nil))
+	if stats := o.(*pcapOutputer).OutputStats(); stats.Written != 6 || stats.Dropped != 0 {
+		t.Errorf("❌ pcapng: %+v", stats)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.NgReaderOptions{WantMixedLinkType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err != nil {
+			break
+		}
+		intf, err := r.Interface(ci.InterfaceIndex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantName, wantLinkType := "eth0", layers.LinkTypeEthernet
+		if data[0]>>4 == 4 { // IPv4 header, no Ethernet
+			wantName, wantLinkType = "tun0", layers.LinkTypeRaw
+		}
+		if intf.Name != wantName || intf.LinkType != wantLinkType {
+			t.Errorf("❌ packet %v: interface %v (%v), want %v (%v)",
+				count, intf.Name, intf.LinkType, wantName, wantLinkType)
+		}
+		count++
+	}
+	if count != 6 || r.NInterfaces() != 2 {
+		t.Errorf("❌ pcapng: read back %v packets of %v interfaces, want 6 of 2", count, r.NInterfaces())
+	}
+
+	// pcap: the link type of the first packet only
+	o, err = NewPcapOutputer(path.Join(tmpdir, "out.pcap"), PcapFormat, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.OutputPackets(CapturePacketsFromAll(context.Background(), newSources(), []string{"eth0", "tun0"}, nil))
+	if stats := o.(*pcapOutputer).OutputStats(); stats.Written != 3 || stats.Dropped != 3 {
+		t.Errorf("❌ pcap: %+v", stats)
+	}
+}
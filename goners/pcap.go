@@ -6,36 +6,48 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/gopacket"
-	_ "github.com/google/gopacket/layers"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 )
 
 // Packet is a View to gopacket.Packet
 type Packet struct {
 	DeviceIndex int       `json:"device_index"`
-	Timestamp   time.Time `json:"timestamp"` // gopacket.Packet.Metadata().Timestamp
+	Device      string    `json:"device,omitempty"` // name of the device captured from (if known)
+	Timestamp   time.Time `json:"timestamp"`        // gopacket.Packet.Metadata().Timestamp
 
 	Length        int `json:"length"`         // gopacket.Packet.Metadata().Length
 	CaptureLength int `json:"capture_length"` // gopacket.Packet.Metadata().CaptureLength
 
+	// Reassembly is set if the packet is an IP fragment, or reassembled
+	// from fragments (see Defragment).
+	Reassembly *IPReassembly `json:"reassembly,omitempty"`
+
 	Layers []Layer `json:"layers"`
 
-	packet gopacket.Packet
+	packet   gopacket.Packet // nil for fast path Packets
+	data     []byte
+	ci       gopacket.CaptureInfo
+	linkType layers.LinkType // link type of the source it captured from
+	fast     *fastDecoder    // non-nil for fast path Packets
 }
 
 func NewPacket(packet gopacket.Packet) *Packet {
 	p := Packet{
 		packet: packet,
+		data:   packet.Data(),
+		ci:     packet.Metadata().CaptureInfo,
 
 		DeviceIndex:   packet.Metadata().InterfaceIndex,
 		Timestamp:     packet.Metadata().Timestamp,
 		Length:        packet.Metadata().Length,
 		CaptureLength: packet.Metadata().CaptureLength,
+		Reassembly:    reassemblyOf(packet.Metadata().CaptureInfo),
 	}
 
 	packetLayers := packet.Layers()
@@ -47,16 +59,43 @@ func NewPacket(packet gopacket.Packet) *Packet {
 	return &p
 }
 
+// Data returns the raw data of the packet: the reassembled one for
+// the packets reassembled by Defragment.
+func (p Packet) Data() []byte {
+	return p.data
+}
+
+// frame returns the data as captured: that is Data, except for the
+// packets reassembled by Defragment, whose last fragment is returned.
+func (p *Packet) frame() []byte {
+	if p.Reassembly != nil && p.Reassembly.frame != nil {
+		return p.Reassembly.frame
+	}
+	return p.data
+}
+
+// CaptureInfo returns the metadata of the capture.
+func (p Packet) CaptureInfo() gopacket.CaptureInfo {
+	return p.ci
+}
+
 // To pretty print this, a tty with 96+ chars width is required.
 func (p Packet) String() string {
 	var sb strings.Builder
 
 	src, dst := p.Flow()
-	// TODO: PACKET -> type (e.g. HTTP, TCP, ...)
 	sb.WriteString(fmt.Sprintf("%v: %v -> %v @ %v\n",
-		"PACKET", src, dst, p.Timestamp))
+		p.Protocol(), src, dst, p.Timestamp))
+	sb.WriteString(fmt.Sprintf("\t%v\n", p.Info()))
+	device := fmt.Sprint(p.DeviceIndex)
+	if p.Device != "" {
+		device = p.Device
+	}
 	sb.WriteString(fmt.Sprintf("\tLength: %v (Captured %v) from device %v\n",
-		p.Length, p.CaptureLength, p.DeviceIndex))
+		p.Length, p.CaptureLength, device))
+	if p.Reassembly != nil {
+		sb.WriteString(fmt.Sprintf("\tIP %v\n", p.Reassembly))
+	}
 
 	for i, l := range p.Layers {
 		sb.WriteString(fmt.Sprintf("  Layer %v ", i+1))
@@ -90,6 +129,22 @@ func (p Packet) Flow() (src string, dst string) {
 	return src, dst
 }
 
+// Field returns the value at the field path "<layer>.<path>" of the first
+// layer matching the layer abbreviation, e.g. "tcp.options[0].kind".
+func (p Packet) Field(path string) (any, bool) {
+	abbr, rest, _ := strings.Cut(path, ".")
+	for _, l := range p.Layers {
+		if l.Abbr() != abbr {
+			continue
+		}
+		if rest == "" {
+			return l.Fields(), true
+		}
+		return l.Fields().Get(rest)
+	}
+	return nil, false
+}
+
 // PacketType returns the most high-level protocol.
 func (p Packet) PacketType() string {
 	if len(p.Layers) == 0 {
@@ -101,18 +156,81 @@ func (p Packet) PacketType() string {
 type PacketView Packet
 
 func (p Packet) MarshalJSON() ([]byte, error) {
+	return p.MarshalJSONDetail(LayerAllDetails)
+}
+
+// MarshalJSONDetail is MarshalJSON with only the selected details of layers,
+// which is much cheaper than all of them.
+func (p Packet) MarshalJSONDetail(detail LayerDetail) ([]byte, error) {
+	return p.marshalJSON(detail, JsonOptions{})
+}
+
+// MarshalJSONOptions marshals the packet in the profile of the options,
+// with the payloads omitted or truncated as they say.
+func (p Packet) MarshalJSONOptions(opts JsonOptions) ([]byte, error) {
+	switch opts.Profile {
+	case JsonSummary:
+		return p.marshalJSONSummary()
+	case JsonFields:
+		return p.marshalJSON(LayerFields, opts)
+	case JsonFull, "":
+		return p.marshalJSON(LayerAllDetails, opts)
+	}
+	return nil, fmt.Errorf("unknown json profile: %q", opts.Profile)
+}
+
+func (p Packet) marshalJSON(detail LayerDetail, opts JsonOptions) ([]byte, error) {
 	src, dst := p.Flow()
 
+	views := make([]any, 0, len(p.Layers))
+	for _, l := range p.Layers {
+		views = append(views, l.jsonView(detail, opts))
+	}
+
 	return json.Marshal(struct {
 		PacketView
+		Layers     []any  `json:"layers"`
 		Src        string `json:"src"`
 		Dst        string `json:"dst"`
 		PacketType string `json:"packet_type"`
+		Protocol   string `json:"protocol"`
+		Info       string `json:"info"`
 	}{
 		PacketView: PacketView(p),
+		Layers:     views,
 		Src:        src,
 		Dst:        dst,
 		PacketType: p.PacketType(),
+		Protocol:   p.Protocol(),
+		Info:       p.Info(),
+	})
+}
+
+// marshalJSONSummary marshals the JsonSummary profile: no layers.
+func (p Packet) marshalJSONSummary() ([]byte, error) {
+	src, dst := p.Flow()
+	return json.Marshal(struct {
+		DeviceIndex   int       `json:"device_index"`
+		Device        string    `json:"device,omitempty"`
+		Timestamp     time.Time `json:"timestamp"`
+		Length        int       `json:"length"`
+		CaptureLength int       `json:"capture_length"`
+		Src           string    `json:"src"`
+		Dst           string    `json:"dst"`
+		PacketType    string    `json:"packet_type"`
+		Protocol      string    `json:"protocol"`
+		Info          string    `json:"info"`
+	}{
+		DeviceIndex:   p.DeviceIndex,
+		Device:        p.Device,
+		Timestamp:     p.Timestamp,
+		Length:        p.Length,
+		CaptureLength: p.CaptureLength,
+		Src:           src,
+		Dst:           dst,
+		PacketType:    p.PacketType(),
+		Protocol:      p.Protocol(),
+		Info:          p.Info(),
 	})
 }
 
@@ -131,9 +249,26 @@ type Layer struct {
 	Payload []byte `json:"payload"`
 
 	layer gopacket.Layer
+	cache *layerCache // lazily built Fields & Dump
+}
+
+// layerCache holds the costly details of a Layer, built at the first use.
+type layerCache struct {
+	fieldsOnce sync.Once
+	fields     Fields
+
+	dumpOnce sync.Once
+	dump     string
 }
 
 func NewLayer(layer gopacket.Layer) Layer {
+	l := newLayer(layer)
+	l.cache = &layerCache{}
+	return l
+}
+
+// newLayer is NewLayer without the cache of details.
+func newLayer(layer gopacket.Layer) Layer {
 	l := Layer{
 		layer:     layer,
 		LayerType: layer.LayerType().String(),
@@ -155,10 +290,21 @@ func NewLayer(layer gopacket.Layer) Layer {
 	return l
 }
 
-// Dump is my version of gopacket.LayerDump
+// Dump is my version of gopacket.LayerDump.
+// It's built at the first call, and cached.
 func (l Layer) Dump() string {
+	if l.cache == nil {
+		return dumpLayer(l.layer)
+	}
+	l.cache.dumpOnce.Do(func() {
+		l.cache.dump = dumpLayer(l.layer)
+	})
+	return l.cache.dump
+}
+
+func dumpLayer(layer gopacket.Layer) string {
 	var b bytes.Buffer
-	if d, ok := l.layer.(gopacket.Dumper); ok {
+	if d, ok := layer.(gopacket.Dumper); ok {
 		dump := d.Dump()
 		if dump != "" {
 			b.WriteString(dump)
@@ -167,47 +313,25 @@ func (l Layer) Dump() string {
 			}
 		}
 	}
-	b.WriteString(hex.Dump(l.layer.LayerContents()))
+	b.WriteString(hex.Dump(layer.LayerContents()))
 	return b.String()
 }
 
-// Fields is a map[string]string version of gopacket.layerString
-func (l Layer) Fields() map[string]string {
-	fields := make(map[string]string)
-
-	v := reflect.ValueOf(l.layer)
-
-	// unwrap: interface, ptr
-	for unwrapTimes := 0; unwrapTimes < 3; unwrapTimes++ {
-		switch v.Type().Kind() {
-		case reflect.Interface, reflect.Ptr:
-			if v.IsNil() {
-				return fields
-			}
-			v = v.Elem()
-		}
-	}
-
-	if v.Type().Kind() != reflect.Struct {
-		fields["value"] = fmt.Sprintf("%v", v.Interface())
-		return fields
-	}
-
-	// assert v.Type().Kind() == reflect.Struct
-	for i := 0; i < v.NumField(); i++ {
-		ftype := v.Type().Field(i)
-		if ftype.Anonymous { // embedded field
-			continue
-		}
-		if ftype.PkgPath != "" { // unexported field
-			continue
-		}
-		key := ftype.Name
-		value := v.Field(i).Interface()
-		fields[key] = fmt.Sprintf("%v", value)
+// Fields returns the typed field tree of the layer. See Fields.
+// It's built at the first call, and cached: do not modify it.
+func (l Layer) Fields() Fields {
+	if l.cache == nil {
+		return NewFields(l.layer)
 	}
+	l.cache.fieldsOnce.Do(func() {
+		l.cache.fields = NewFields(l.layer)
+	})
+	return l.cache.fields
+}
 
-	return fields
+// Abbr is the abbreviation of the layer type for field paths: "ip", "tcp", ...
+func (l Layer) Abbr() string {
+	return LayerAbbr(l.LayerType)
 }
 
 const maxLineWidth = 80
@@ -223,10 +347,13 @@ func (l Layer) String() string {
 	// |    longK: ---longV---              |
 	sb.WriteString("Fields:\n")
 	line := make([]string, 0, 4)
-	longFields := make(map[string]string)
-	for k, v := range l.Fields() {
+	var longFields []string
+	fields := l.Fields()
+	flat := fields.Flatten("")
+	for _, k := range fields.Paths() {
+		v := fmt.Sprintf("%v", flat[k])
 		if len(k)+2+len(v) >= prettyFieldLen {
-			longFields[k] = v
+			longFields = append(longFields, fmt.Sprintf("%v: %v", k, v))
 			continue
 		}
 		line = append(line,
@@ -243,9 +370,10 @@ func (l Layer) String() string {
 		sb.WriteString(strings.Join(line, "\t"))
 		sb.WriteString("\n")
 	}
-	for k, v := range longFields {
+	for _, f := range longFields {
 		sb.WriteString("\t")
-		sb.WriteString(fmt.Sprintf("%v: %v\n", k, v))
+		sb.WriteString(f)
+		sb.WriteString("\n")
 	}
 
 	// Dump content
@@ -258,54 +386,311 @@ func (l Layer) String() string {
 // LayerView is Layer: workaround for add Dump() & Fields() retvalue into Layer's json.
 type LayerView Layer
 
-func (l Layer) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
+// LayerDetail selects the costly details of layers to marshal into JSON.
+type LayerDetail uint8
+
+const (
+	LayerFields LayerDetail = 1 << iota // the field tree: Layer.Fields()
+	LayerDump                           // the hex dump: Layer.Dump()
+
+	LayerNoDetail   LayerDetail = 0
+	LayerAllDetails             = LayerFields | LayerDump
+)
+
+// JsonProfile is the verbosity of the JSON of packets.
+type JsonProfile string
+
+const (
+	// JsonSummary is the flow, type, length & info of packets, no layers:
+	// like a row of the packet list of Wireshark.
+	JsonSummary JsonProfile = "summary"
+	// JsonFields is the layers with the field trees, but no hex dumps.
+	JsonFields JsonProfile = "fields"
+	// JsonFull is the layers with all details (see LayerAllDetails).
+	JsonFull JsonProfile = "full"
+)
+
+// JsonOptions configures the JSON of packets.
+type JsonOptions struct {
+	Profile JsonProfile `json:"profile"` // default: JsonFull
+
+	// OmitPayload omits the payloads of layers.
+	OmitPayload bool `json:"omit_payload"`
+	// MaxPayload truncates the payloads of layers to MaxPayload bytes.
+	// 0 means no limit.
+	MaxPayload int `json:"max_payload"`
+}
+
+// Validate checks the profile is known.
+func (o JsonOptions) Validate() error {
+	switch o.Profile {
+	case JsonSummary, JsonFields, JsonFull, "":
+		return nil
+	}
+	return fmt.Errorf("unknown json profile: %q. Available: summary, fields, full", o.Profile)
+}
+
+// limitPayload returns the part of the payload data to marshal.
+func (o JsonOptions) limitPayload(data []byte) []byte {
+	switch {
+	case o.OmitPayload:
+		return nil
+	case o.MaxPayload > 0 && len(data) > o.MaxPayload:
+		return data[:o.MaxPayload]
+	}
+	return data
+}
+
+// jsonView is the Layer with the details to marshal.
+//
+// The payload is omitted or truncated as the opts say, with
+// payload_length telling the original length. So is the data of
+// application layers (e.g. the Payload layer of an HTTP request) in
+// their dump & fields, with data_length telling the original length.
+func (l Layer) jsonView(detail LayerDetail, opts JsonOptions) any {
+	type view struct {
 		LayerView
-		Dump   string            `json:"dump"`
-		Fields map[string]string `json:"fields"`
-	}{
-		LayerView: LayerView(l),
-		Dump:      l.Dump(),
-		Fields:    l.Fields(),
-	})
+		Dump   *string `json:"dump,omitempty"`
+		Fields Fields  `json:"fields,omitempty"`
+	}
+
+	v := view{LayerView: LayerView(l)}
+	if detail&LayerDump != 0 {
+		dump := l.Dump()
+		v.Dump = &dump
+	}
+	if detail&LayerFields != 0 {
+		v.Fields = l.Fields()
+	}
+
+	payload := opts.limitPayload(l.Payload)
+	data, opaque := l.applicationData()
+	keptData := opts.limitPayload(data)
+	if len(payload) == len(l.Payload) && len(keptData) == len(data) {
+		return v
+	}
+
+	type limitedView struct {
+		view
+		Payload       []byte `json:"payload,omitempty"` // shadows LayerView.Payload
+		PayloadLength *int   `json:"payload_length,omitempty"`
+		DataLength    *int   `json:"data_length,omitempty"`
+	}
+	lv := limitedView{view: v, Payload: payload}
+	if len(payload) < len(l.Payload) {
+		n := len(l.Payload)
+		lv.PayloadLength = &n
+	}
+	if len(keptData) < len(data) {
+		n := len(data)
+		lv.DataLength = &n
+		if lv.Dump != nil {
+			dump := hex.Dump(keptData)
+			lv.Dump = &dump
+		}
+		if opaque && lv.Fields != nil {
+			lv.Fields = nil
+			if keptData != nil {
+				lv.Fields = Fields{"value": hex.EncodeToString(keptData)}
+			}
+		}
+	}
+	return lv
+}
+
+// applicationData returns the data of the application layer, which is
+// in its dump (and the fields of the opaque ones, e.g. the Payload layer),
+// or nil for the other layers.
+func (l Layer) applicationData() (data []byte, opaque bool) {
+	switch layer := l.layer.(type) {
+	case gopacket.Payload, *gopacket.Payload, *gopacket.Fragment, *gopacket.DecodeFailure:
+		return layer.LayerContents(), true
+	case gopacket.ApplicationLayer: // e.g. DNS, TLS
+		return layer.LayerContents(), false
+	}
+	return nil, false
+}
+
+func (l Layer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.jsonView(LayerAllDetails, JsonOptions{}))
 }
 
 const BlockForever = pcap.BlockForever
 
 var ChanBufSize = 16
 
+// CaptureLivePackets captures live packets from the device.
 func CaptureLivePackets(ctx context.Context,
 	device string, bpf string, snaplen int32, promisc bool, timeout time.Duration,
 ) (chan *Packet, error) {
-	chOut := make(chan *Packet, ChanBufSize)
+	return CapturePackets(ctx, LiveSource{
+		Device:  device,
+		Snaplen: snaplen,
+		Promisc: promisc,
+		Timeout: timeout,
+	}, bpf)
+}
+
+// CaptureFilePackets reads packets from a saved pcap or pcapng file.
+//
+// The returned chan is closed after the last packet in the file is read,
+// or when the ctx is done.
+func CaptureFilePackets(ctx context.Context, path string, bpf string) (chan *Packet, error) {
+	return CapturePackets(ctx, FileSource{Path: path}, bpf)
+}
 
-	handle, err := pcap.OpenLive(device, snaplen, promisc, timeout)
+// CapturePackets opens a PacketSource from the provider, sets the bpf filter
+// (if not empty) and captures packets from it, until the source is exhausted
+// or the ctx is done.
+func CapturePackets(ctx context.Context, provider PacketSourceProvider, bpf string) (chan *Packet, error) {
+	source, err := OpenPacketSource(provider, bpf)
+	if err != nil {
+		return nil, err
+	}
+	return CapturePacketsFrom(ctx, source), nil
+}
+
+// OpenPacketSource opens a PacketSource from the provider,
+// and sets the bpf filter (if not empty) on it. The filter is compiled
+// for the link type of the source: ErrBadFilter if it fails.
+func OpenPacketSource(provider PacketSourceProvider, bpf string) (PacketSource, error) {
+	source, err := provider.OpenPacketSource()
 	if err != nil {
 		return nil, err
 	}
-	// handle.Close()
 
 	bpf = strings.TrimSpace(bpf)
 	if bpf != "" {
-		if err := handle.SetBPFFilter(bpf); err != nil {
-			return nil, err
+		if err := source.SetBPFFilter(bpf); err != nil {
+			linkType := source.LinkType()
+			source.Close()
+			return nil, fmt.Errorf("%w %q for %v: %v", ErrBadFilter, bpf, linkType, err)
 		}
 	}
 
+	return source, nil
+}
+
+// CaptureFunc captures packets from the opened source:
+// CapturePacketsFrom or CapturePacketsFastFrom.
+type CaptureFunc func(ctx context.Context, source PacketSource) chan *Packet
+
+// CapturePacketsFrom reads & decodes packets from the opened source into
+// the returned chan, until the source is exhausted or the ctx is done.
+// The source is closed then.
+func CapturePacketsFrom(ctx context.Context, source PacketSource) chan *Packet {
+	chOut := make(chan *Packet, ChanBufSize)
+
 	go func() {
 		defer close(chOut)
-		packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+		defer source.Close()
+
+		packetSource := gopacket.NewPacketSource(source, source.LinkType())
+		packets := packetSource.Packets()
 		for {
 			select {
-			case packet := <-packetSource.Packets():
+			case packet, ok := <-packets:
+				if !ok { // EOF or unrecoverable error
+					return
+				}
 				p := NewPacket(packet)
-				chOut <- p
+				p.linkType = source.LinkType()
+				select {
+				case chOut <- p:
+				case <-ctx.Done():
+					return
+				}
 			case <-ctx.Done():
-				handle.Close()
 				return
 			}
 		}
 	}()
 
-	return chOut, nil
+	return chOut
+}
+
+// drainPackets Releases the packets from in until it's closed,
+// for a stage that stops early not to block the stages above it.
+func drainPackets(in <-chan *Packet) {
+	for p := range in {
+		p.Release()
+	}
+}
+
+// trackPackets is the Track stage of the trackers: it adds each packet
+// passed through, and calls done (if not nil) once in is closed.
+func trackPackets(in <-chan *Packet, add func(p *Packet), done func()) chan *Packet {
+	out := make(chan *Packet, ChanBufSize)
+	go func() {
+		defer close(out)
+		for p := range in {
+			add(p)
+			out <- p
+		}
+		if done != nil {
+			done()
+		}
+	}()
+	return out
+}
+
+// StopConditions stops a capturing when any of them is hit.
+// Zero values mean no limit.
+type StopConditions struct {
+	Count    int64         `json:"count"`     // stop after Count packets
+	Duration time.Duration `json:"duration"`  // stop after Duration (wall-clock)
+	MaxBytes int64         `json:"max_bytes"` // stop after MaxBytes captured bytes
+}
+
+// Enabled reports whether any stop condition is set.
+func (c StopConditions) Enabled() bool {
+	return c.Count > 0 || c.Duration > 0 || c.MaxBytes > 0
+}
+
+// LimitPackets forwards packets from in to the returned chan,
+// until any of the stop conditions is hit. Then it calls stop
+// (e.g. the cancel of the capturing ctx) and closes the returned chan,
+// so that the downstream formaters & outputers finish cleanly.
+//
+// The packet that reaches the Count or MaxBytes is forwarded.
+// The packets after it are drained from in and Released, so that the
+// stages above see in closed (e.g. a StreamTracker flushes its streams).
+func LimitPackets(in <-chan *Packet, cond StopConditions, stop context.CancelFunc) chan *Packet {
+	out := make(chan *Packet, ChanBufSize)
+
+	go func() {
+		defer drainPackets(in)
+		defer close(out)
+		defer stop()
+
+		var timeout <-chan time.Time
+		if cond.Duration > 0 {
+			timer := time.NewTimer(cond.Duration)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		var count, bytes int64
+		for {
+			select {
+			case p, ok := <-in:
+				if !ok {
+					return
+				}
+				// p may be Released & reused once sent
+				count++
+				bytes += int64(p.CaptureLength)
+				out <- p
+
+				if (cond.Count > 0 && count >= cond.Count) ||
+					(cond.MaxBytes > 0 && bytes >= cond.MaxBytes) {
+					return
+				}
+			case <-timeout:
+				return
+			}
+		}
+	}()
+
+	return out
 }
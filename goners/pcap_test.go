@@ -1,16 +1,23 @@
 package goners
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/gopacket"
-	_ "github.com/google/gopacket/layers"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
 )
 
 // sudo go test . -run TestNewPacket -v
@@ -36,7 +43,7 @@ func TestNewPacket(t *testing.T) {
 		for i, l := range packet.Layers {
 			t.Logf("--- Layer %v (%v): \n%v\n", i, l.LayerType, l.Dump())
 			for k, v := range l.Fields() {
-				fmt.Printf("\tfield %q: %q\n", k, v)
+				fmt.Printf("\tfield %q: %v\n", k, v)
 			}
 		}
 
@@ -169,3 +176,423 @@ LOOP:
 	duration = time.Since(startTime)
 	t.Logf("captured %v packets. stoped: duration=%v", counter.Load(), duration)
 }
+
+// craftTCPPacket builds an Ethernet/IPv4/TCP packet: 10.0.0.1:srcPort -> 10.0.0.2:dstPort.
+func craftTCPPacket(t testing.TB, srcPort, dstPort uint16, payload []byte) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01},
+		DstMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(srcPort),
+		DstPort: layers.TCPPort(dstPort),
+		Seq:     1,
+		PSH:     true,
+		ACK:     true,
+		Window:  65535,
+	}
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeTestPcapFile writes n crafted TCP packets into a pcap (or pcapng if ng) file.
+func writeTestPcapFile(t testing.TB, file string, n int, ng bool) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var w interface {
+		WritePacket(ci gopacket.CaptureInfo, data []byte) error
+	}
+	if ng {
+		ngw, err := pcapgo.NewNgWriter(f, layers.LinkTypeEthernet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ngw.Flush()
+		w = ngw
+	} else {
+		pw := pcapgo.NewWriter(f)
+		if err := pw.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+			t.Fatal(err)
+		}
+		w = pw
+	}
+
+	start := time.Unix(1678000000, 0)
+	for i := 0; i < n; i++ {
+		data := craftTCPPacket(t, 40000, 443, []byte(fmt.Sprintf("hello %d", i)))
+		ci := gopacket.CaptureInfo{
+			Timestamp:     start.Add(time.Duration(i) * time.Millisecond),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCaptureFilePackets(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	pcapFile := path.Join(tmpdir, "test.pcap")
+	writeTestPcapFile(t, pcapFile, 5, false)
+	pcapngFile := path.Join(tmpdir, "test.pcapng")
+	writeTestPcapFile(t, pcapngFile, 5, true)
+
+	tests := []struct {
+		name      string
+		path      string
+		bpf       string
+		wantCount int
+		wantErr   bool
+	}{
+		{"pcap", pcapFile, "", 5, false},
+		{"pcapng", pcapngFile, "", 5, false},
+		{"filterMatched", pcapFile, "tcp port 443", 5, false},
+		{"filterUnmatched", pcapngFile, "udp", 0, false},
+		{"badBpf", pcapFile, "好久不见呀 我又来了", 0, true},
+		{"noFile", path.Join(tmpdir, "noexists.pcap"), "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CaptureFilePackets(context.Background(), tt.path, tt.bpf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CaptureFilePackets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			count := 0
+			for p := range got { // closed at EOF
+				if p.Timestamp.IsZero() || p.CaptureLength == 0 {
+					t.Errorf("❌ bad packet metadata: %+v", p)
+				}
+				if src, dst := p.Flow(); src != "10.0.0.1:40000" || dst != "10.0.0.2:443" {
+					t.Errorf("❌ bad flow: %v -> %v", src, dst)
+				}
+				count++
+			}
+			if count != tt.wantCount {
+				t.Errorf("❌ read %v packets, want %v", count, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestReplayPackets(t *testing.T) {
+	const gap = 200 * time.Millisecond
+
+	in := make(chan *Packet, 3)
+	start := time.Unix(1678000000, 0)
+	for i := 0; i < 3; i++ {
+		in <- &Packet{Timestamp: start.Add(time.Duration(i) * gap)}
+	}
+	close(in)
+
+	startTime := time.Now()
+	count := 0
+	for p := range ReplayPackets(context.Background(), in) {
+		elapsed := time.Since(startTime)
+		expected := p.Timestamp.Sub(start)
+		if elapsed < expected || elapsed > expected+gap/2 {
+			t.Errorf("❌ packet %v replayed at %v, expected %v", count, elapsed, expected)
+		}
+		count++
+	}
+	if count != 3 {
+		t.Errorf("❌ replayed %v packets, want 3", count)
+	}
+}
+
+func TestReplayPackets_cancel(t *testing.T) {
+	in := make(chan *Packet, 3)
+	start := time.Unix(1678000000, 0)
+	for i := 0; i < 3; i++ {
+		in <- &Packet{Timestamp: start.Add(time.Duration(i) * time.Hour)}
+	}
+	close(in)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := ReplayPackets(ctx, in)
+	<-out
+	cancel()
+	for range out {
+		t.Errorf("❌ replayed a packet after ctx done")
+	}
+	if len(in) != 0 {
+		t.Errorf("❌ %v packets left in in, want drained", len(in))
+	}
+}
+
+func TestLimitPackets(t *testing.T) {
+	tests := []struct {
+		name      string
+		cond      StopConditions
+		wantCount int
+	}{
+		{"noLimit", StopConditions{}, 10},
+		{"count", StopConditions{Count: 3}, 3},
+		{"maxBytes", StopConditions{MaxBytes: 250}, 3}, // 100 bytes per packet
+		{"duration", StopConditions{Duration: 250 * time.Millisecond}, 3},
+		{"first hit", StopConditions{Count: 2, MaxBytes: 1000}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			in := make(chan *Packet)
+			slow := tt.cond.Duration > 0
+			go func() { // a fake capturing: 1 packet per 100ms until ctx done
+				defer close(in)
+				for i := 0; i < 10; i++ {
+					select {
+					case in <- &Packet{CaptureLength: 100}:
+					case <-ctx.Done():
+						return
+					}
+					if slow {
+						time.Sleep(100 * time.Millisecond)
+					}
+				}
+			}()
+
+			count := 0
+			for p := range LimitPackets(in, tt.cond, cancel) {
+				p.CaptureLength = 0 // as if Released & reused by the pool
+				count++
+			}
+			if count != tt.wantCount {
+				t.Errorf("❌ got %v packets, want %v", count, tt.wantCount)
+			}
+			if ctx.Err() == nil {
+				t.Errorf("❌ stop is not called")
+			}
+		})
+	}
+}
+
+func TestLimitPackets_drain(t *testing.T) {
+	in := make(chan *Packet)
+	done := make(chan struct{})
+	go func() { // not stopped by the ctx: blocked if in is not drained
+		defer close(done)
+		defer close(in)
+		for i := 0; i < 100; i++ {
+			in <- &Packet{CaptureLength: 100}
+		}
+	}()
+
+	count := 0
+	for range LimitPackets(in, StopConditions{Count: 1}, func() {}) {
+		count++
+	}
+	if count != 1 {
+		t.Errorf("❌ got %v packets, want 1", count)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("❌ the stage above is blocked: in is not drained")
+	}
+}
+
+func TestPacket_MarshalJSONOptions(t *testing.T) {
+	payload := bytes.Repeat([]byte("goners"), 10) // 60 bytes
+	p := NewPacket(gopacket.NewPacket(craftTCPPacket(t, 40000, 443, payload), layers.LinkTypeEthernet, gopacket.Default))
+
+	tests := []struct {
+		name        string
+		opts        JsonOptions
+		wantLayers  bool
+		wantFields  bool
+		wantDump    bool
+		wantPayload int // length of the TCP payload, -1 for absent
+	}{
+		{"default", JsonOptions{}, true, true, true, 60},
+		{"full", JsonOptions{Profile: JsonFull}, true, true, true, 60},
+		{"fields", JsonOptions{Profile: JsonFields}, true, true, false, 60},
+		{"summary", JsonOptions{Profile: JsonSummary}, false, false, false, -1},
+		{"omit payload", JsonOptions{Profile: JsonFields, OmitPayload: true}, true, true, false, -1},
+		{"truncate payload", JsonOptions{MaxPayload: 16}, true, true, true, 16},
+		{"short payload", JsonOptions{MaxPayload: 100}, true, true, true, 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := p.MarshalJSONOptions(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				Src    string `json:"src"`
+				Info   string `json:"info"`
+				Length int    `json:"length"`
+				Layers []struct {
+					LayerType     string         `json:"layer_type"`
+					Payload       *[]byte        `json:"payload"`
+					PayloadLength *int           `json:"payload_length"`
+					DataLength    *int           `json:"data_length"`
+					Fields        map[string]any `json:"fields"`
+					Dump          *string        `json:"dump"`
+				} `json:"layers"`
+			}
+			if err := json.Unmarshal(j, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Src != "10.0.0.1:40000" || got.Info == "" || got.Length != p.Length {
+				t.Errorf("❌ src = %q, info = %q, length = %v", got.Src, got.Info, got.Length)
+			}
+			if (len(got.Layers) > 0) != tt.wantLayers {
+				t.Fatalf("❌ layers = %s, want layers: %v", j, tt.wantLayers)
+			}
+			for _, l := range got.Layers {
+				if l.LayerType == "Payload" { // the data is the TCP payload
+					kept := payload
+					if tt.wantPayload >= 0 {
+						kept = payload[:tt.wantPayload]
+					}
+					limited := tt.wantPayload != 60
+					if value, _ := l.Fields["value"].(string); tt.wantFields && tt.wantPayload != -1 &&
+						value != hex.EncodeToString(kept) {
+						t.Errorf("❌ Payload: fields.value = %q, want %q", value, hex.EncodeToString(kept))
+					}
+					if tt.wantPayload == -1 && (l.Fields != nil || l.Dump != nil) {
+						t.Errorf("❌ Payload: fields = %v, dump = %v, want omitted", l.Fields, l.Dump)
+					}
+					if tt.wantDump && (l.Dump == nil || !strings.Contains(*l.Dump, hex.Dump(kept)) ||
+						limited && *l.Dump != hex.Dump(kept)) {
+						t.Errorf("❌ Payload: dump = %v, want %q", l.Dump, hex.Dump(kept))
+					}
+					if limited != (l.DataLength != nil) || limited && *l.DataLength != 60 {
+						t.Errorf("❌ Payload: data_length = %v", l.DataLength)
+					}
+					continue
+				}
+				if (l.Fields != nil) != tt.wantFields || (l.Dump != nil) != tt.wantDump {
+					t.Errorf("❌ %v: fields = %v, dump = %v, want %v, %v",
+						l.LayerType, l.Fields != nil, l.Dump != nil, tt.wantFields, tt.wantDump)
+				}
+				if l.LayerType != "TCP" {
+					continue
+				}
+				gotPayload := -1
+				if l.Payload != nil {
+					gotPayload = len(*l.Payload)
+				}
+				if gotPayload != tt.wantPayload {
+					t.Errorf("❌ TCP payload length = %v, want %v", gotPayload, tt.wantPayload)
+				}
+				if limited := tt.wantPayload != 60; limited != (l.PayloadLength != nil) ||
+					limited && *l.PayloadLength != 60 {
+					t.Errorf("❌ TCP payload_length = %v", l.PayloadLength)
+				}
+			}
+		})
+	}
+
+	if _, err := p.MarshalJSONOptions(JsonOptions{Profile: "verbose"}); err == nil {
+		t.Errorf("❌ unknown profile: error = nil")
+	}
+	if _, err := NewJsonProfilePacketsFormater(JsonOptions{Profile: "verbose"}); err == nil {
+		t.Errorf("❌ NewJsonProfilePacketsFormater: unknown profile: error = nil")
+	}
+}
+
+func newBenchmarkPacket(b *testing.B) *Packet {
+	data := craftTCPPacket(b, 40000, 443, bytes.Repeat([]byte("goners"), 100))
+	return NewPacket(gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default))
+}
+
+// go test . -run XXX -bench Fields -benchmem
+func BenchmarkLayer_Fields(b *testing.B) {
+	p := newBenchmarkPacket(b)
+	for _, bm := range []struct {
+		name   string
+		cached bool
+	}{
+		{"cached", true},
+		{"uncached", false}, // the structPlans are built for each packet
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !bm.cached {
+					structPlans.Range(func(t, _ any) bool {
+						structPlans.Delete(t)
+						return true
+					})
+				}
+				for _, l := range p.Layers {
+					NewFields(l.layer)
+				}
+			}
+		})
+	}
+}
+
+// go test . -run XXX -bench MarshalJSON -benchmem
+func BenchmarkPacket_MarshalJSONDetail(b *testing.B) {
+	p := newBenchmarkPacket(b)
+	for _, bm := range []struct {
+		name   string
+		detail LayerDetail
+	}{
+		{"all", LayerAllDetails},
+		{"fields", LayerFields},
+		{"dump", LayerDump},
+		{"none", LayerNoDetail},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				// a new Packet each time: no cached details
+				if _, err := NewPacket(p.packet).MarshalJSONDetail(bm.detail); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// go test . -run XXX -bench Decode -benchmem
+func BenchmarkDecode(b *testing.B) {
+	data := craftTCPPacket(b, 40000, 443, bytes.Repeat([]byte("goners"), 100))
+	ci := gopacket.CaptureInfo{Length: len(data), CaptureLength: len(data)}
+
+	b.Run("slow", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			NewPacket(gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default))
+		}
+	})
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			NewFastPacket(data, ci, layers.LinkTypeEthernet).Release()
+		}
+	})
+}
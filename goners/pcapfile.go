@@ -0,0 +1,174 @@
+package goners
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// pcapngMagic is the block type of a pcapng Section Header Block,
+// which is always the first 4 bytes of a pcapng file.
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// DefaultSnaplen is the snaplen of tcpdump: the default for capturing,
+// and used for compiling BPF filters when the file header tells nothing
+// about the snaplen.
+const DefaultSnaplen = 262144
+
+// pcapFileReader is implemented by both pcapgo.Reader and pcapgo.NgReader.
+type pcapFileReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// fileHandle reads packets from a saved pcap or pcapng file.
+// It is a pure-Go counterpart to pcap.Handle for offline files,
+// except that the BPF filter is matched in userspace.
+type fileHandle struct {
+	file    *os.File
+	reader  pcapFileReader
+	snaplen int
+
+	bpf    atomic.Pointer[pcap.BPF] // may be replaced while reading
+	closed atomic.Bool
+}
+
+// openFileHandle opens a pcap or pcapng file. The format is detected
+// by the magic number at the beginning of the file.
+func openFileHandle(path string) (*fileHandle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &fileHandle{file: f, snaplen: DefaultSnaplen}
+
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(len(pcapngMagic))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if bytes.Equal(magic, pcapngMagic) {
+		ngReader, err := pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if intf, err := ngReader.Interface(0); err == nil && intf.SnapLength > 0 {
+			h.snaplen = int(intf.SnapLength)
+		}
+		h.reader = ngReader
+	} else {
+		pcapReader, err := pcapgo.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if pcapReader.Snaplen() > 0 {
+			h.snaplen = int(pcapReader.Snaplen())
+		}
+		h.reader = pcapReader
+	}
+
+	return h, nil
+}
+
+// ReadPacketData returns the next packet matching the BPF filter (if any).
+// io.EOF is returned at the end of file or after the handle is closed.
+func (h *fileHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	for {
+		if h.closed.Load() {
+			return nil, ci, io.EOF
+		}
+		data, ci, err = h.reader.ReadPacketData()
+		if err != nil {
+			return data, ci, err
+		}
+		if bpf := h.bpf.Load(); bpf == nil || bpf.Matches(ci, data) {
+			return data, ci, nil
+		}
+	}
+}
+
+func (h *fileHandle) LinkType() layers.LinkType {
+	return h.reader.LinkType()
+}
+
+// SetBPFFilter compiles the expr for the file's link type. Packets that
+// do not match it are skipped by ReadPacketData. An empty expr clears the filter.
+// It is safe to call while reading.
+func (h *fileHandle) SetBPFFilter(expr string) error {
+	if expr == "" { // no filter
+		h.bpf.Store(nil)
+		return nil
+	}
+	bpf, err := pcap.NewBPF(h.LinkType(), h.snaplen, expr)
+	if err != nil {
+		return err
+	}
+	h.bpf.Store(bpf)
+	return nil
+}
+
+func (h *fileHandle) Close() {
+	if h.closed.Swap(true) {
+		return
+	}
+	h.file.Close()
+}
+
+// ReplayPackets paces packets from in at the rate they were recorded:
+// each packet is sent to the returned chan after the same delay
+// (since the first packet) as its Timestamp has.
+//
+// It helps to "play back" a saved capture (CaptureFilePackets)
+// in real time. The returned chan is closed when in is closed or ctx is done,
+// then the rest of in is drained and Released.
+func ReplayPackets(ctx context.Context, in <-chan *Packet) chan *Packet {
+	out := make(chan *Packet, ChanBufSize)
+
+	go func() {
+		defer close(out)
+
+		var firstTimestamp, startTime time.Time
+		for p := range in {
+			if startTime.IsZero() {
+				firstTimestamp, startTime = p.Timestamp, time.Now()
+			}
+
+			delay := p.Timestamp.Sub(firstTimestamp) - time.Since(startTime)
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					p.Release()
+					drainPackets(in)
+					return
+				}
+			}
+
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				p.Release()
+				drainPackets(in)
+				return
+			}
+		}
+	}()
+
+	return out
+}
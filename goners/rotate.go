@@ -0,0 +1,162 @@
+package goners
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// RotateOptions configures the ring buffer of capture files,
+// works like tcpdump's -C, -G and -W.
+//
+// Files are named FILE, FILE.1, FILE.2, ... (the number is inserted
+// before the extension: out.pcap, out.1.pcap, out.2.pcap, ...).
+type RotateOptions struct {
+	// FileSize rotates to a new file after FileSize bytes are written
+	// into the current one. 0 means no limit. (tcpdump -C)
+	FileSize int64 `json:"file_size"`
+	// Interval rotates to a new file every Interval. 0 means no limit.
+	// (tcpdump -G)
+	Interval time.Duration `json:"interval"`
+	// FileCount keeps only the last FileCount files: older ones are removed.
+	// 0 means keeping all of them. (tcpdump -W)
+	FileCount int `json:"file_count"`
+}
+
+// Enabled reports whether any rotation is configured.
+func (r RotateOptions) Enabled() bool {
+	return r.FileSize > 0 || r.Interval > 0
+}
+
+// rotator is implemented by files that may rotate.
+// Outputers call Due & Rotate on record boundaries, so that
+// a record (a line, a packet) is never split into two files,
+// and on Expired, so that a file is rotated on time without new records.
+// They never rotate a file without records.
+type rotator interface {
+	Due() bool
+	Rotate() error
+	Expired() <-chan time.Time
+}
+
+// rotatingFile is an io.WriteCloser writing into a series of files.
+type rotatingFile struct {
+	base string
+	opts RotateOptions
+
+	file     *os.File
+	index    int       // index of the current file
+	written  int64     // bytes written into the current file
+	openedAt time.Time // when the current file is opened
+	files    []string  // kept files, the oldest first
+
+	now     func() time.Time // time.Now, faked in tests
+	timer   *time.Timer      // of the Interval of the current file
+	expired chan time.Time   // the timer expired
+}
+
+func newRotatingFile(base string, opts RotateOptions) (*rotatingFile, error) {
+	f := &rotatingFile{
+		base:    base,
+		opts:    opts,
+		now:     time.Now,
+		expired: make(chan time.Time, 1),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// rotatedName returns the name of the i-th file: base for i == 0,
+// otherwise the i is inserted before the extension of base.
+func rotatedName(base string, i int) string {
+	if i == 0 {
+		return base
+	}
+	ext := filepath.Ext(base)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(base, ext), i, ext)
+}
+
+// open opens the file of current index, and removes the outdated files.
+func (f *rotatingFile) open() error {
+	name := rotatedName(f.base, f.index)
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	f.file = file
+	f.written = 0
+	f.openedAt = f.now()
+	f.files = append(f.files, name)
+
+	if f.opts.Interval > 0 {
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+		f.timer = time.AfterFunc(f.opts.Interval, func() {
+			select {
+			case f.expired <- f.now():
+			default: // not received yet
+			}
+		})
+	}
+
+	for f.opts.FileCount > 0 && len(f.files) > f.opts.FileCount {
+		if err := os.Remove(f.files[0]); err != nil {
+			slog.Warn("rotatingFile: remove outdated file failed.",
+				"file", f.files[0], "err", err)
+		}
+		f.files = f.files[1:]
+	}
+
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	n, err := f.file.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+// Due reports whether the current file is full or expired.
+//
+// The bytes buffered by the writer of the Outputer (e.g. a pcapgo.NgWriter)
+// are not counted: a file may exceed the FileSize by the buffer.
+func (f *rotatingFile) Due() bool {
+	if f.opts.FileSize > 0 && f.written >= f.opts.FileSize {
+		return true
+	}
+	if f.opts.Interval > 0 && f.now().Sub(f.openedAt) >= f.opts.Interval {
+		return true
+	}
+	return false
+}
+
+// Rotate closes the current file and opens the next one.
+func (f *rotatingFile) Rotate() error {
+	if err := f.file.Close(); err != nil {
+		slog.Warn("rotatingFile: close file failed.",
+			"file", f.file.Name(), "err", err)
+	}
+	f.index++
+	return f.open()
+}
+
+// Expired receives when the Interval of the current file may have
+// passed: call Due to make sure, for the file may have been rotated.
+func (f *rotatingFile) Expired() <-chan time.Time {
+	return f.expired
+}
+
+func (f *rotatingFile) Close() error {
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	return f.file.Close()
+}
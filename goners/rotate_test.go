@@ -0,0 +1,258 @@
+package goners
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func Test_rotatedName(t *testing.T) {
+	tests := []struct {
+		base string
+		i    int
+		want string
+	}{
+		{"out.pcap", 0, "out.pcap"},
+		{"out.pcap", 1, "out.1.pcap"},
+		{"dir/out.txt", 12, "dir/out.12.txt"},
+		{"out", 3, "out.3"},
+	}
+	for _, tt := range tests {
+		if got := rotatedName(tt.base, tt.i); got != tt.want {
+			t.Errorf("❌ rotatedName(%q, %v) = %q, want %q", tt.base, tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestRotatingFileOutputer(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	file := path.Join(tmpdir, "out.txt")
+	o, err := NewRotatingFileOutputer(file, RotateOptions{FileSize: 10, FileCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := make(chan []byte)
+	done := make(chan struct{})
+	go func() {
+		o.Output(in)
+		close(done)
+	}()
+	for i := 0; i < 5; i++ {
+		in <- []byte(fmt.Sprintf("line %05d", i)) // 10 bytes + \n: one line per file
+	}
+	close(in)
+	<-done
+
+	for i, want := range map[int]bool{0: false, 1: false, 2: false, 3: true, 4: true} {
+		name := rotatedName(file, i)
+		content, err := os.ReadFile(name)
+		if exists := err == nil; exists != want {
+			t.Errorf("❌ %v exists = %v, want %v", name, exists, want)
+			continue
+		}
+		if want && string(content) != fmt.Sprintf("line %05d\n", i) {
+			t.Errorf("❌ %v: content = %q", name, content)
+		}
+	}
+}
+
+func TestRotatingFileOutputer_header(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	file := path.Join(tmpdir, "out.csv")
+	o, err := NewRotatingFileOutputer(file, RotateOptions{FileSize: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formater, err := NewFieldsPacketsFormater(FieldsOptions{Fields: []string{"frame.number", "frame.len"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := SyntheticSource{Link: layers.LinkTypeEthernet}
+	for i := 0; i < 3; i++ {
+		source.Packets = append(source.Packets, SyntheticPacket{Data: craftTCPPacket(t, 40000, 443, nil)})
+	}
+	packets := CapturePacketsFrom(context.Background(), mustOpen(t, source))
+	o.Output(FormatPacketsFor(o, formater, packets))
+
+	for i := 0; i < 3; i++ { // header (23 bytes) + a row: one row per file
+		name := rotatedName(file, i)
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("frame.number,frame.len\n%v,60\n", i+1); string(content) != want {
+			t.Errorf("❌ %v: content = %q, want %q", name, content, want)
+		}
+	}
+}
+
+// fakeClock is the clock of a rotatingFile in tests.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// fakeClockOf injects a fakeClock into the (not yet written) file.
+func fakeClockOf(f *rotatingFile) *fakeClock {
+	c := &fakeClock{now: time.Unix(1678000000, 0)}
+	f.now = c.Now
+	f.openedAt = c.Now()
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// waitUntil waits for the outputer goroutine to make cond true.
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("❌ timeout")
+		}
+	}
+}
+
+func TestRotatingFileOutputer_expired(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	file := path.Join(tmpdir, "out.txt")
+	o, err := NewRotatingFileOutputer(file, RotateOptions{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := o.(*fileOutputer).file.(*rotatingFile)
+	clock := fakeClockOf(f)
+
+	in := make(chan []byte)
+	done := make(chan struct{})
+	go func() {
+		o.Output(in)
+		close(done)
+	}()
+	in <- []byte("line 0")
+	waitUntil(t, func() bool { return o.(*fileOutputer).written.Load() == 1 })
+
+	// rotated by the timer, without a new line
+	clock.Add(time.Hour)
+	f.expired <- clock.Now()
+	waitUntil(t, func() bool {
+		_, err := os.Stat(rotatedName(file, 1))
+		return err == nil
+	})
+
+	in <- []byte("line 1")
+	close(in)
+	<-done
+
+	for i := 0; i < 2; i++ {
+		content, err := os.ReadFile(rotatedName(file, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("line %v\n", i); string(content) != want {
+			t.Errorf("❌ file %v: content = %q, want %q", i, content, want)
+		}
+	}
+}
+
+func TestRotatingPcapOutputer(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	src := path.Join(tmpdir, "src.pcap")
+	writeTestPcapFile(t, src, 6, false)
+
+	for _, format := range []PcapFileFormat{PcapFormat, PcapngFormat} {
+		t.Run(string(format), func(t *testing.T) {
+			file := path.Join(tmpdir, "out."+string(format))
+			o, err := NewRotatingPcapOutputer(file, format, 0, RotateOptions{Interval: time.Hour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			po := o.(*pcapOutputer)
+			clock := fakeClockOf(po.file.(*rotatingFile))
+
+			packets, err := CaptureFilePackets(context.Background(), src, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			in := make(chan *Packet)
+			done := make(chan struct{})
+			go func() {
+				o.OutputPackets(in)
+				close(done)
+			}()
+			sent := 0
+			for p := range packets {
+				if sent > 0 && sent%2 == 0 { // 2 packets per file
+					waitUntil(t, func() bool { return po.written.Load() == int64(sent) })
+					clock.Add(time.Hour)
+				}
+				in <- p
+				sent++
+			}
+			close(in)
+			<-done
+
+			for i := 0; i < 4; i++ {
+				name := rotatedName(file, i)
+				if _, err := os.Stat(name); err != nil {
+					if i != 3 {
+						t.Errorf("❌ %v is not rotated: %v", name, err)
+					}
+					continue
+				}
+				if i == 3 {
+					t.Errorf("❌ %v is rotated, want 3 files", name)
+					continue
+				}
+				readback, err := CaptureFilePackets(context.Background(), name, "")
+				if err != nil {
+					t.Fatalf("❌ %v is not a valid capture file: %v", name, err)
+				}
+				count := 0
+				for range readback {
+					count++
+				}
+				if count != 2 {
+					t.Errorf("❌ read back %v packets from %v, want 2", count, name)
+				}
+			}
+		})
+	}
+}
@@ -2,8 +2,12 @@ package goners
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,73 +16,325 @@ import (
 
 type SessionID string
 
+var ErrSessionNotFound = errors.New("session not found")
+
 type PcapSessionConfig struct {
-	Device  string        `json:"device"`
+	Device  Devices       `json:"device"` // one or more devices, or "any"
 	Filter  string        `json:"filter"`
 	Snaplen int           `json:"snaplen"`
 	Promisc bool          `json:"promisc"`
 	Timeout time.Duration `json:"timeout"`
 
-	Format PacketsFormater
-	Output Outputer
+	// DisplayFilter filters the decoded packets (see DisplayFilter). Optional.
+	DisplayFilter string `json:"display_filter"`
+
+	// FastPath decodes only Ethernet/IPv4/IPv6/TCP/UDP/ICMP layers
+	// with the fast path (see CapturePacketsFastFrom).
+	FastPath bool `json:"fast_path"`
+
+	// Defrag reassembles IP fragments before decoding (see Defragment).
+	Defrag bool `json:"defrag"`
+
+	// TrackStreams reassembles the TCP streams of the session
+	// (see StreamTracker), for SessionStreams.
+	TrackStreams bool `json:"track_streams"`
+
+	// TrackFlows maintains the Conversations & Endpoints of the session
+	// (see FlowTracker), for SessionFlows.
+	TrackFlows bool `json:"track_flows"`
+
+	// TrackProtocols maintains the protocol hierarchy of the session
+	// (see ProtocolTracker), for SessionProtocols.
+	TrackProtocols bool `json:"track_protocols"`
+
+	// StopConditions stop & close the session: count, duration, max_bytes.
+	StopConditions
+
+	// Source is where packets are captured from. Optional:
+	// defaults to LiveSources of the Device, Snaplen, Promisc and Timeout.
+	Source PacketSourceProvider `json:"-"`
+
+	Format PacketsFormater `json:"-"`
+	Output Outputer        `json:"-"`
+
+	// PacketsOutput outputs the raw packets (e.g. into a pcap file),
+	// besides or instead of the formatted Output. Optional.
+	PacketsOutput PacketsOutputer `json:"-"`
 }
 
 type pcapSession struct {
-	ID     SessionID
-	Config *PcapSessionConfig
-	cancel context.CancelFunc // stop CaptureLivePackets
+	ID        SessionID
+	Config    *PcapSessionConfig
+	StartTime time.Time
+	cancel    context.CancelFunc // stop CapturePackets
+	done      chan struct{}      // closed after all outputs finished
+
+	sources   []PacketSourceProvider
+	handles   []PacketSource // opened from the sources
+	stats     *StatsCollector
+	flows     *FlowTracker     // nil if not TrackFlows
+	protocols *ProtocolTracker // nil if not TrackProtocols
+	streams   *StreamTracker   // nil if not TrackStreams
+	paused    atomic.Bool      // drop packets instead of outputting them
+}
+
+// SessionInfo is a snapshot of a running session.
+type SessionInfo struct {
+	ID        SessionID          `json:"id"`
+	Config    *PcapSessionConfig `json:"config"`
+	Source    string             `json:"source"` // e.g. "live:eth0", "file:trace.pcap", "live:eth0,live:tun0"
+	StartTime time.Time          `json:"start_time"`
+	Packets   int64              `json:"packets"` // packets captured
+	Bytes     int64              `json:"bytes"`   // bytes captured
+	Clients   int                `json:"clients"` // connected WebSocket clients
+	Paused    bool               `json:"paused"`
+}
+
+// ClientsCounter is implemented by Outputers that serve clients
+// (e.g. the webSocketOutputer), to report the number of connected clients.
+type ClientsCounter interface {
+	Clients() int
+}
+
+// info returns a snapshot of the session. The caller holds the mutex of
+// the manager: the Config is copied, for UpdateSession to change it later.
+func (s *pcapSession) info() SessionInfo {
+	config := *s.Config
+	stats := s.stats.Stats()
+	info := SessionInfo{
+		ID:        s.ID,
+		Config:    &config,
+		Source:    s.sourceString(),
+		StartTime: s.StartTime,
+		Packets:   stats.Decoded,
+		Bytes:     stats.Bytes,
+		Paused:    s.paused.Load(),
+	}
+	if c, ok := s.Config.Output.(ClientsCounter); ok {
+		info.Clients = c.Clients()
+	}
+	return info
+}
+
+func (s *pcapSession) sourceString() string {
+	sources := make([]string, 0, len(s.sources))
+	for _, source := range s.sources {
+		sources = append(sources, fmt.Sprint(source))
+	}
+	return strings.Join(sources, ",")
+}
+
+// Stats returns the CaptureStats of the session.
+func (s *pcapSession) Stats() CaptureStats {
+	return s.stats.Stats()
 }
 
 type PcapSessionsManager interface {
 	StartSession(config *PcapSessionConfig) (SessionID, error)
 	CloseSession(id SessionID) error
+	ListSessions() []SessionInfo
+	GetSession(id SessionID) (SessionInfo, error)
+	SessionStats(id SessionID) (CaptureStats, error)
+	SessionFlows(id SessionID) (*FlowTracker, error)
+	SessionProtocols(id SessionID) (*ProtocolTracker, error)
+	SessionStreams(id SessionID) (*StreamTracker, error)
+	SessionDone(id SessionID) (<-chan struct{}, error)
+	UpdateSession(id SessionID, filter string) error
+	PauseSession(id SessionID) error
+	ResumeSession(id SessionID) error
 }
 
+// EndedSessionTTL is how long a session ended by itself (stop conditions
+// hit, EOF, ...) is kept for its stats, streams, conversations and protocols.
+// CloseSession removes it earlier.
+var EndedSessionTTL = 10 * time.Minute
+
 type pcapSessionsManager struct {
-	sessions map[SessionID]*pcapSession
+	sessions map[SessionID]*pcapSession // running
+	ended    map[SessionID]*pcapSession // ended by itself, kept for EndedSessionTTL
 	mutex    sync.RWMutex
 }
 
 func (m *pcapSessionsManager) StartSession(config *PcapSessionConfig) (SessionID, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	packets, err := CaptureLivePackets(
-		ctx,
-		config.Device,
-		config.Filter,
-		int32(config.Snaplen),
-		config.Promisc,
-		config.Timeout)
+	formatted := config.Format != nil && config.Output != nil
+	if !formatted && config.PacketsOutput == nil {
+		cancel()
+		return SessionID(""), fmt.Errorf("bad config: unexpected nil format or nil output")
+	}
+
+	var displayFilter *DisplayFilter
+	if strings.TrimSpace(config.DisplayFilter) != "" {
+		var err error
+		if displayFilter, err = CompileDisplayFilter(config.DisplayFilter); err != nil {
+			cancel()
+			return SessionID(""), err
+		}
+	}
+
+	sources, err := sessionSources(config)
 	if err != nil {
 		cancel()
 		return SessionID(""), err
 	}
 
-	if config.Format == nil || config.Output == nil {
+	handles, sources, err := OpenPacketSources(sources, config.Filter)
+	if err != nil {
 		cancel()
-		return SessionID(""), fmt.Errorf("bad config: unexpected nil format or nil output")
+		return SessionID(""), err
+	}
+	if o, ok := config.PacketsOutput.(*pcapOutputer); ok {
+		if err := o.format.CheckLinkTypes(handles); err != nil {
+			for _, h := range handles {
+				h.Close()
+			}
+			cancel()
+			return SessionID(""), err
+		}
+	}
+
+	stats := NewStatsCollector()
+	for i := range handles {
+		handles[i] = stats.WatchSource(handles[i])
+		if config.Defrag {
+			handles[i] = Defragment(handles[i])
+		}
+	}
+	var capture CaptureFunc
+	if config.FastPath {
+		capture = CapturePacketsFastFrom
+	}
+	packets := CapturePacketsFromAll(ctx, handles, DeviceNames(sources), capture)
+	var streams *StreamTracker
+	if config.TrackStreams { // all the packets, before display filtered
+		streams = NewStreamTracker()
+		packets = streams.Track(packets)
+	}
+	if displayFilter != nil {
+		packets = FilterPackets(packets, displayFilter)
 	}
-	go config.Output.Output(config.Format.FormatPackets(packets))
 
 	sessionID := m.newSessionID(config)
 
 	session := pcapSession{
-		ID:     sessionID,
-		Config: config,
-		cancel: cancel,
+		ID:        sessionID,
+		Config:    config,
+		StartTime: time.Now(),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		sources:   sources,
+		handles:   handles,
+		stats:     stats,
+		streams:   streams,
+	}
+	packets = stats.CountDecoded(packets)
+	if config.TrackFlows {
+		session.flows = NewFlowTracker()
+		packets = session.flows.Track(packets)
+	}
+	if config.TrackProtocols {
+		session.protocols = NewProtocolTracker()
+		packets = session.protocols.Track(packets)
+	}
+	packets = stats.DropPaused(packets, &session.paused)
+	if config.StopConditions.Enabled() { // count the packets output only
+		packets = LimitPackets(packets, config.StopConditions, cancel)
 	}
 
 	slog.Info("pcap sessions manager starts session.",
 		"sessionID", sessionID, "config", config)
 
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
 	m.sessions[sessionID] = &session
+	m.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	output := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+
+	format := func(in <-chan *Packet) <-chan []byte {
+		return stats.CountFormatted(FormatPacketsFor(config.Output, config.Format, in))
+	}
+
+	if formatted {
+		stats.AddFormater(config.Format)
+		stats.AddOutput(config.Output)
+	}
+	if config.PacketsOutput != nil {
+		stats.AddOutput(config.PacketsOutput)
+	}
+
+	switch {
+	case formatted && config.PacketsOutput != nil:
+		tee := TeePackets(packets, 2)
+		output(func() { config.Output.Output(format(tee[0])) })
+		output(func() { config.PacketsOutput.OutputPackets(tee[1]) })
+	case formatted:
+		output(func() { config.Output.Output(format(packets)) })
+	default:
+		output(func() { config.PacketsOutput.OutputPackets(packets) })
+	}
+
+	// the capturing may end by itself (stop conditions hit, EOF, ...):
+	// end the session after its outputs are flushed.
+	go func() {
+		wg.Wait()
+		m.removeSession(sessionID)
+		close(session.done)
+	}()
 
 	return sessionID, nil
 }
 
+// sessionSources returns the config.Source, or the LiveSources of
+// the config.Device.
+func sessionSources(config *PcapSessionConfig) ([]PacketSourceProvider, error) {
+	if config.Source != nil {
+		return []PacketSourceProvider{config.Source}, nil
+	}
+	return LiveSources(config.Device, int32(config.Snaplen), config.Promisc, config.Timeout)
+}
+
+// removeSession moves the session ended by itself (if it is still there)
+// from the running ones to the ended ones, where it's kept for
+// EndedSessionTTL: the trackers can be queried after the capturing.
+func (m *pcapSessionsManager) removeSession(id SessionID) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok { // closed by CloseSession
+		return
+	}
+	session.cancel()
+	delete(m.sessions, id)
+	m.ended[id] = session
+	time.AfterFunc(EndedSessionTTL, func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		delete(m.ended, id)
+	})
+
+	slog.Info("pcap sessions manager ends session.",
+		"sessionID", id, "keep", EndedSessionTTL)
+}
+
+// trackedSession returns the running or ended session.
+// The caller holds the mutex.
+func (m *pcapSessionsManager) trackedSession(id SessionID) (*pcapSession, bool) {
+	if session, ok := m.sessions[id]; ok {
+		return session, true
+	}
+	session, ok := m.ended[id]
+	return session, ok
+}
+
 func (m *pcapSessionsManager) newSessionID(config *PcapSessionConfig) SessionID {
 	var sessionID SessionID
 	u, err := uuid.NewRandom()
@@ -90,13 +346,21 @@ func (m *pcapSessionsManager) newSessionID(config *PcapSessionConfig) SessionID
 	return sessionID
 }
 
+// CloseSession stops a running session, or removes an ended one.
 func (m *pcapSessionsManager) CloseSession(id SessionID) error {
 	m.mutex.RLock()
 	session, ok := m.sessions[id]
+	_, ended := m.ended[id]
 	m.mutex.RUnlock()
 
+	if ended {
+		m.mutex.Lock()
+		delete(m.ended, id)
+		m.mutex.Unlock()
+		return nil
+	}
 	if !ok {
-		return fmt.Errorf("session not found")
+		return ErrSessionNotFound
 	}
 
 	m.mutex.Lock()
@@ -111,12 +375,175 @@ func (m *pcapSessionsManager) CloseSession(id SessionID) error {
 	return nil
 }
 
+// ListSessions returns the info of all running sessions,
+// sorted by the StartTime.
+func (m *pcapSessionsManager) ListSessions() []SessionInfo {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	infos := make([]SessionInfo, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		infos = append(infos, session.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartTime.Before(infos[j].StartTime)
+	})
+
+	return infos
+}
+
+func (m *pcapSessionsManager) GetSession(id SessionID) (SessionInfo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return SessionInfo{}, ErrSessionNotFound
+	}
+	return session.info(), nil
+}
+
+// SessionStats returns the CaptureStats of the session (running or ended).
+func (m *pcapSessionsManager) SessionStats(id SessionID) (CaptureStats, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	session, ok := m.trackedSession(id)
+	if !ok {
+		return CaptureStats{}, ErrSessionNotFound
+	}
+	return session.Stats(), nil
+}
+
+// SessionFlows returns the FlowTracker of the session (running or ended),
+// for its Conversations & Endpoints,
+// or ErrFlowsUntracked if it's not started with TrackFlows.
+func (m *pcapSessionsManager) SessionFlows(id SessionID) (*FlowTracker, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	session, ok := m.trackedSession(id)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if session.flows == nil {
+		return nil, ErrFlowsUntracked
+	}
+	return session.flows, nil
+}
+
+// SessionProtocols returns the ProtocolTracker of the session (running or
+// ended), for its protocol Hierarchy,
+// or ErrProtocolsUntracked if it's not started with TrackProtocols.
+func (m *pcapSessionsManager) SessionProtocols(id SessionID) (*ProtocolTracker, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	session, ok := m.trackedSession(id)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if session.protocols == nil {
+		return nil, ErrProtocolsUntracked
+	}
+	return session.protocols, nil
+}
+
+// SessionStreams returns the StreamTracker of the session (running or ended),
+// or ErrStreamsUntracked if it's not started with TrackStreams.
+func (m *pcapSessionsManager) SessionStreams(id SessionID) (*StreamTracker, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	session, ok := m.trackedSession(id)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if session.streams == nil {
+		return nil, ErrStreamsUntracked
+	}
+	return session.streams, nil
+}
+
+// SessionDone returns a chan closed when the session (running or ended)
+// ends: by itself or by CloseSession, after its outputs are finished.
+func (m *pcapSessionsManager) SessionDone(id SessionID) (<-chan struct{}, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	session, ok := m.trackedSession(id)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return session.done, nil
+}
+
+// UpdateSession changes the BPF filter of a running session in place:
+// the session keeps its ID, outputs and connected clients.
+// The old filter is kept if the new one fails to compile.
+func (m *pcapSessionsManager) UpdateSession(id SessionID, filter string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	for i, handle := range session.handles {
+		if err := handle.SetBPFFilter(strings.TrimSpace(filter)); err != nil {
+			for _, h := range session.handles[:i] { // roll back
+				h.SetBPFFilter(strings.TrimSpace(session.Config.Filter))
+			}
+			return err
+		}
+	}
+	session.Config.Filter = filter
+
+	slog.Info("pcap sessions manager updates session.",
+		"sessionID", id, "filter", filter)
+
+	return nil
+}
+
+// PauseSession pauses a running session: packets are dropped (and counted
+// in the CaptureStats) until it's resumed. The session keeps its ID, outputs
+// and connected clients.
+func (m *pcapSessionsManager) PauseSession(id SessionID) error {
+	return m.setPaused(id, true)
+}
+
+// ResumeSession resumes a paused session.
+func (m *pcapSessionsManager) ResumeSession(id SessionID) error {
+	return m.setPaused(id, false)
+}
+
+func (m *pcapSessionsManager) setPaused(id SessionID, paused bool) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.paused.Store(paused)
+
+	slog.Info("pcap sessions manager pauses/resumes session.",
+		"sessionID", id, "paused", paused)
+
+	return nil
+}
+
 // deprecated
 func getPacketsFormater(format string) (PacketsFormater, error) {
 	var formater PacketsFormater
 	switch format {
 	case "text":
 		formater = StringPacketsFormater
+	case "summary":
+		formater = SummaryPacketsFormater
+	case "tcpdump":
+		formater = TcpdumpPacketsFormater
 	case "json":
 		formater = JsonPacketsFormater
 	default:
@@ -129,8 +556,13 @@ func getPacketsFormater(format string) (PacketsFormater, error) {
 var pcapSessionsManagerSingleton *pcapSessionsManager
 
 func init() {
-	pcapSessionsManagerSingleton = &pcapSessionsManager{
+	pcapSessionsManagerSingleton = newPcapSessionsManager()
+}
+
+func newPcapSessionsManager() *pcapSessionsManager {
+	return &pcapSessionsManager{
 		sessions: make(map[SessionID]*pcapSession),
+		ended:    make(map[SessionID]*pcapSession),
 	}
 }
 
@@ -1,9 +1,451 @@
 package goners
 
 import (
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 )
 
+// chanOutputer outputs into the chan, closes it when in is closed.
+type chanOutputer chan []byte
+
+func (o chanOutputer) Output(in <-chan []byte) {
+	for data := range in {
+		o <- data
+	}
+	close(o)
+}
+
+// waitSessionDone waits for the session to end, e.g. at EOF.
+func waitSessionDone(t testing.TB, m PcapSessionsManager, id SessionID) {
+	t.Helper()
+	done, err := m.SessionDone(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("❌ session %v is not done", id)
+	}
+}
+
 func Test_pcapSessionManager(t *testing.T) {
-	// TODO: Test_pcapSessionManager
+	m := newPcapSessionsManager()
+
+	tests := []struct {
+		name      string
+		config    PcapSessionConfig
+		wantCount int
+		wantErr   bool
+	}{
+		{
+			name:      "synthetic",
+			config:    PcapSessionConfig{Source: newTestSyntheticSource(t, 5)},
+			wantCount: 5,
+		},
+		{
+			name: "stopConditions",
+			config: PcapSessionConfig{
+				Source:         newTestSyntheticSource(t, 5),
+				StopConditions: StopConditions{Count: 2},
+			},
+			wantCount: 2,
+		},
+		{
+			name:    "noOutput",
+			config:  PcapSessionConfig{Source: newTestSyntheticSource(t, 5)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := make(chanOutputer)
+			if !tt.wantErr {
+				tt.config.Format = JsonPacketsFormater
+				tt.config.Output = out
+			}
+
+			id, err := m.StartSession(&tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("StartSession() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			count := 0
+			for range out { // closed after the source is exhausted
+				count++
+			}
+			if count != tt.wantCount {
+				t.Errorf("❌ got %v packets, want %v", count, tt.wantCount)
+			}
+
+			waitSessionDone(t, m, id)
+			if _, err := m.GetSession(id); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("❌ ended session %v is still running", id)
+			}
+			if stats, err := m.SessionStats(id); err != nil || stats.Formatted != int64(tt.wantCount) {
+				t.Errorf("❌ SessionStats(ended) = %+v, %v", stats, err)
+			}
+			if err := m.CloseSession(id); err != nil {
+				t.Errorf("❌ CloseSession(ended) error = %v", err)
+			}
+			if _, err := m.SessionStats(id); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("❌ ended session %v is not removed by CloseSession", id)
+			}
+		})
+	}
+}
+
+func Test_pcapSessionManager_ListSessions(t *testing.T) {
+	m := newPcapSessionsManager()
+
+	// unread outputs: sessions are kept running
+	outs := []chanOutputer{make(chanOutputer), make(chanOutputer)}
+	ids := make([]SessionID, 0, len(outs))
+	for _, out := range outs {
+		id, err := m.StartSession(&PcapSessionConfig{
+			Source: newTestSyntheticSource(t, 5),
+			Format: JsonPacketsFormater,
+			Output: out,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	infos := m.ListSessions()
+	if len(infos) != len(ids) {
+		t.Fatalf("❌ ListSessions() got %v sessions, want %v", len(infos), len(ids))
+	}
+	for i, info := range infos {
+		if info.ID != ids[i] {
+			t.Errorf("❌ ListSessions()[%v].ID = %v, want %v", i, info.ID, ids[i])
+		}
+		if info.Source != "synthetic:5 packets" || info.StartTime.IsZero() {
+			t.Errorf("❌ ListSessions()[%v] = %+v", i, info)
+		}
+	}
+
+	<-outs[0] // let some packets through
+	time.Sleep(100 * time.Millisecond)
+	info, err := m.GetSession(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Packets == 0 || info.Bytes == 0 {
+		t.Errorf("❌ GetSession() counters not updated: %+v", info)
+	}
+	if _, err := m.GetSession("noexists"); err == nil {
+		t.Errorf("❌ GetSession(noexists) error = nil")
+	}
+
+	stats, err := m.SessionStats(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Decoded != info.Packets || stats.Formatted == 0 {
+		t.Errorf("❌ SessionStats() = %+v, info = %+v", stats, info)
+	}
+	if _, err := m.SessionStats("noexists"); err == nil {
+		t.Errorf("❌ SessionStats(noexists) error = nil")
+	}
+
+	for i, id := range ids {
+		if err := m.CloseSession(id); err != nil {
+			t.Error(err)
+		}
+		for range outs[i] { // drain
+		}
+	}
+	if infos := m.ListSessions(); len(infos) != 0 {
+		t.Errorf("❌ ListSessions() after closed: %v", infos)
+	}
+}
+
+func Test_pcapSessionManager_UpdateSession(t *testing.T) {
+	m := newPcapSessionsManager()
+
+	// more packets than the buffers of the pipeline (gopacket.PacketSource
+	// alone buffers 1000 packets):
+	// the filter is updated in the middle of the capturing.
+	const n = 3000
+	source := SyntheticSource{Link: layers.LinkTypeEthernet}
+	for i := 0; i < n; i++ {
+		dstPort := uint16(443)
+		if i%2 == 0 {
+			dstPort = 80
+		}
+		source.Packets = append(source.Packets, SyntheticPacket{
+			Data: craftTCPPacket(t, 40000, dstPort, []byte("hello")),
+		})
+	}
+
+	dstPorts := PacketsFormaterFunc(func(in <-chan *Packet) <-chan []byte {
+		out := make(chan []byte, ChanBufSize)
+		go func() {
+			defer close(out)
+			for p := range in {
+				out <- []byte(p.packet.TransportLayer().TransportFlow().Dst().String())
+			}
+		}()
+		return out
+	})
+
+	out := make(chanOutputer)
+	config := PcapSessionConfig{Source: source, Format: dstPorts, Output: out}
+	id, err := m.StartSession(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-out // running
+	before, _ := m.GetSession(id)
+	if err := m.UpdateSession(id, "tcp dst port 80"); err != nil {
+		t.Fatalf("❌ UpdateSession() error = %v", err)
+	}
+	if info, _ := m.GetSession(id); info.Config.Filter != "tcp dst port 80" {
+		t.Errorf("❌ GetSession().Config.Filter = %q after UpdateSession()", info.Config.Filter)
+	}
+	if before.Config.Filter != "" { // a snapshot
+		t.Errorf("❌ GetSession().Config.Filter before UpdateSession() = %q", before.Config.Filter)
+	}
+
+	var got []string
+	for data := range out {
+		got = append(got, string(data))
+	}
+	if len(got) >= n-1 {
+		t.Errorf("❌ got %v packets, the filter is not applied", len(got))
+	}
+	if len(got) < ChanBufSize {
+		t.Fatalf("❌ got %v packets, want at least %v", len(got), ChanBufSize)
+	}
+	for _, port := range got[len(got)-ChanBufSize:] {
+		if port != "80" {
+			t.Errorf("❌ got packet to port %v after the filter is updated", port)
+		}
+	}
+
+	if err := m.UpdateSession("noexists", "tcp"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("❌ UpdateSession(noexists) error = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+// gatedSource reads the packets of the SyntheticSource,
+// but blocks before the gateAt-th one until the gate is closed.
+type gatedSource struct {
+	SyntheticSource
+	gateAt  int
+	waiting chan struct{} // closed when blocked at the gate
+	gate    chan struct{}
+}
+
+func (s *gatedSource) OpenPacketSource() (PacketSource, error) {
+	h, err := s.SyntheticSource.OpenPacketSource()
+	if err != nil {
+		return nil, err
+	}
+	return &gatedHandle{PacketSource: h, source: s}, nil
+}
+
+type gatedHandle struct {
+	PacketSource
+	source *gatedSource
+	read   int
+}
+
+func (h *gatedHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if h.read == h.source.gateAt {
+		close(h.source.waiting)
+		<-h.source.gate
+	}
+	h.read++
+	return h.PacketSource.ReadPacketData()
+}
+
+func Test_pcapSessionManager_PauseSession(t *testing.T) {
+	m := newPcapSessionsManager()
+
+	const n = 3000 // more than the buffers of the pipeline
+	source := &gatedSource{
+		SyntheticSource: newTestSyntheticSource(t, n),
+		gateAt:          n / 2, // paused before, resumed after
+		waiting:         make(chan struct{}),
+		gate:            make(chan struct{}),
+	}
+	out := make(chanOutputer)
+	id, err := m.StartSession(&PcapSessionConfig{
+		Source: source,
+		Format: JsonPacketsFormater,
+		Output: out,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-out // running
+	if err := m.PauseSession(id); err != nil {
+		t.Fatalf("❌ PauseSession() error = %v", err)
+	}
+	if info, _ := m.GetSession(id); !info.Paused {
+		t.Errorf("❌ GetSession().Paused = false after PauseSession()")
+	}
+
+	// keep reading: DropPaused is not blocked by the outputs while paused
+	got := int64(1)
+	done := make(chan struct{})
+	go func() {
+		for range out {
+			got++
+		}
+		close(done)
+	}()
+	<-source.waiting
+
+	stats, _ := m.SessionStats(id)
+	if stats.Paused == 0 {
+		t.Errorf("❌ no packet dropped while paused: %+v", stats)
+	}
+
+	if err := m.ResumeSession(id); err != nil {
+		t.Fatalf("❌ ResumeSession() error = %v", err)
+	}
+	if info, _ := m.GetSession(id); info.Paused {
+		t.Errorf("❌ GetSession().Paused = true after ResumeSession()")
+	}
+	close(source.gate)
+
+	<-done
+	stats, err = m.SessionStats(id) // kept after the session ended
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got < n-int64(source.gateAt) || got+stats.Paused != n {
+		t.Errorf("❌ got %v packets + %v dropped while paused, want %v", got, stats.Paused, n)
+	}
+
+	for _, f := range []func(SessionID) error{m.PauseSession, m.ResumeSession} {
+		if err := f("noexists"); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("❌ pause/resume noexists: error = %v, want %v", err, ErrSessionNotFound)
+		}
+	}
+}
+
+func Test_pcapSessionManager_SessionStreams(t *testing.T) {
+	m := newPcapSessionsManager()
+
+	out := make(chanOutputer)
+	id, err := m.StartSession(&PcapSessionConfig{
+		Source: tcpSegmentsSource(t, []tcpSegment{
+			{false, "S", 100, 0, ""},
+			{true, "SA", 500, 101, ""},
+			{false, "PA", 101, 501, "hello"},
+		}),
+		DisplayFilter: "tcp.len > 0", // streams are tracked before filtered
+		TrackStreams:  true,
+		Format:        JsonPacketsFormater,
+		Output:        out,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range out {
+	}
+	waitSessionDone(t, m, id) // ended: kept for the trackers
+	streams, err := m.SessionStreams(id)
+	if err != nil {
+		t.Fatalf("❌ SessionStreams() error = %v", err)
+	}
+
+	s, err := streams.Stream(0)
+	if err != nil || s.Packets != 3 || string(s.Segments[0].Data) != "hello" {
+		t.Errorf("❌ Stream(0) = %+v, %v", s, err)
+	}
+
+	untracked := make(chanOutputer)
+	id, err = m.StartSession(&PcapSessionConfig{
+		Source: newTestSyntheticSource(t, 5),
+		Format: JsonPacketsFormater,
+		Output: untracked,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.SessionStreams(id); !errors.Is(err, ErrStreamsUntracked) {
+		t.Errorf("❌ SessionStreams(untracked) error = %v, want %v", err, ErrStreamsUntracked)
+	}
+	for range untracked {
+	}
+
+	if _, err := m.SessionStreams("noexists"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("❌ SessionStreams(noexists) error = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func Test_pcapSessionManager_SessionFlows(t *testing.T) {
+	m := newPcapSessionsManager()
+
+	out := make(chanOutputer)
+	id, err := m.StartSession(&PcapSessionConfig{
+		Source:         newTestSyntheticSource(t, 5),
+		TrackFlows:     true,
+		TrackProtocols: true,
+		Format:         JsonPacketsFormater,
+		Output:         out,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range out {
+	}
+	waitSessionDone(t, m, id) // ended: kept for the trackers
+	flows, err := m.SessionFlows(id)
+	if err != nil {
+		t.Fatalf("❌ SessionFlows() error = %v", err)
+	}
+	protocols, err := m.SessionProtocols(id)
+	if err != nil {
+		t.Fatalf("❌ SessionProtocols() error = %v", err)
+	}
+
+	if c := flows.Conversations("tcp"); len(c) != 1 || c[0].Packets != 5 {
+		t.Errorf("❌ Conversations(tcp) = %+v", c)
+	}
+	if root := protocols.Hierarchy(); root.Packets != 5 || len(root.Children) != 1 {
+		t.Errorf("❌ Hierarchy() = %+v", root)
+	}
+
+	untracked := make(chanOutputer)
+	id, err = m.StartSession(&PcapSessionConfig{
+		Source: newTestSyntheticSource(t, 5),
+		Format: JsonPacketsFormater,
+		Output: untracked,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.SessionFlows(id); !errors.Is(err, ErrFlowsUntracked) {
+		t.Errorf("❌ SessionFlows(untracked) error = %v, want %v", err, ErrFlowsUntracked)
+	}
+	if _, err := m.SessionProtocols(id); !errors.Is(err, ErrProtocolsUntracked) {
+		t.Errorf("❌ SessionProtocols(untracked) error = %v, want %v", err, ErrProtocolsUntracked)
+	}
+	for range untracked {
+	}
+
+	if _, err := m.SessionFlows("noexists"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("❌ SessionFlows(noexists) error = %v, want %v", err, ErrSessionNotFound)
+	}
+	if _, err := m.SessionProtocols("noexists"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("❌ SessionProtocols(noexists) error = %v, want %v", err, ErrSessionNotFound)
+	}
 }
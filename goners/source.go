@@ -0,0 +1,142 @@
+package goners
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// PacketSource is where packets are captured from.
+//
+// *pcap.Handle is a PacketSource.
+type PacketSource interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+	SetBPFFilter(expr string) error // may be called while reading
+	Close()
+}
+
+// PacketSourceProvider opens PacketSources to capture from:
+// LiveSource, FileSource or SyntheticSource.
+type PacketSourceProvider interface {
+	OpenPacketSource() (PacketSource, error)
+}
+
+// LiveSource captures live packets from a device.
+// Root privilege is required.
+type LiveSource struct {
+	Device  string
+	Snaplen int32
+	Promisc bool
+	Timeout time.Duration
+
+	// Optional is set for the devices expanded from AnyDevice:
+	// OpenPacketSources skips them if they fail to open
+	// (e.g. pseudo-devices like nflog, usbmon or dbus).
+	Optional bool
+}
+
+func (s LiveSource) String() string {
+	return "live:" + s.Device
+}
+
+func (s LiveSource) OpenPacketSource() (PacketSource, error) {
+	handle, err := pcap.OpenLive(s.Device, s.Snaplen, s.Promisc, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+// FileSource reads packets from a saved pcap or pcapng file.
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) String() string {
+	return "file:" + s.Path
+}
+
+func (s FileSource) OpenPacketSource() (PacketSource, error) {
+	handle, err := openFileHandle(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+// SyntheticPacket is a crafted packet for SyntheticSource.
+type SyntheticPacket struct {
+	Data        []byte
+	CaptureInfo gopacket.CaptureInfo
+}
+
+// SyntheticSource replays crafted packets from memory.
+//
+// It requires neither privilege nor device, which is helpful for tests.
+type SyntheticSource struct {
+	Link    layers.LinkType
+	Packets []SyntheticPacket
+}
+
+func (s SyntheticSource) String() string {
+	return fmt.Sprintf("synthetic:%d packets", len(s.Packets))
+}
+
+func (s SyntheticSource) OpenPacketSource() (PacketSource, error) {
+	return &syntheticHandle{source: s}, nil
+}
+
+// syntheticHandle reads packets from a SyntheticSource.
+type syntheticHandle struct {
+	source SyntheticSource
+	next   int // index of the next packet to read
+
+	bpf    atomic.Pointer[pcap.BPF] // may be replaced while reading
+	closed atomic.Bool
+}
+
+func (h *syntheticHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	for !h.closed.Load() && h.next < len(h.source.Packets) {
+		p := h.source.Packets[h.next]
+		h.next++
+
+		ci = p.CaptureInfo
+		if ci.CaptureLength == 0 {
+			ci.CaptureLength = len(p.Data)
+		}
+		if ci.Length == 0 {
+			ci.Length = len(p.Data)
+		}
+		if bpf := h.bpf.Load(); bpf == nil || bpf.Matches(ci, p.Data) {
+			return p.Data, ci, nil
+		}
+	}
+	return nil, ci, io.EOF
+}
+
+func (h *syntheticHandle) LinkType() layers.LinkType {
+	return h.source.Link
+}
+
+func (h *syntheticHandle) SetBPFFilter(expr string) error {
+	if expr == "" { // no filter
+		h.bpf.Store(nil)
+		return nil
+	}
+	bpf, err := pcap.NewBPF(h.LinkType(), DefaultSnaplen, expr)
+	if err != nil {
+		return err
+	}
+	h.bpf.Store(bpf)
+	return nil
+}
+
+func (h *syntheticHandle) Close() {
+	h.closed.Store(true)
+}
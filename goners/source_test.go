@@ -0,0 +1,76 @@
+package goners
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// newTestSyntheticSource crafts n TCP packets: 10.0.0.1:40000 -> 10.0.0.2:443,
+// 1ms apart.
+func newTestSyntheticSource(t testing.TB, n int) SyntheticSource {
+	source := SyntheticSource{Link: layers.LinkTypeEthernet}
+
+	start := time.Unix(1678000000, 0)
+	for i := 0; i < n; i++ {
+		source.Packets = append(source.Packets, SyntheticPacket{
+			Data: craftTCPPacket(t, 40000, 443, []byte(fmt.Sprintf("hello %d", i))),
+			CaptureInfo: gopacket.CaptureInfo{
+				Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+			},
+		})
+	}
+	return source
+}
+
+func TestOpenPacketSource_badFilter(t *testing.T) {
+	source := newTestSyntheticSource(t, 5)
+	if _, err := OpenPacketSource(source, "tcp port"); !errors.Is(err, ErrBadFilter) {
+		t.Errorf("❌ OpenPacketSource(bad filter) error = %v, want %v", err, ErrBadFilter)
+	}
+	if _, _, err := OpenPacketSources([]PacketSourceProvider{source}, "tcp port"); !errors.Is(err, ErrBadFilter) {
+		t.Errorf("❌ OpenPacketSources(bad filter) error = %v, want %v", err, ErrBadFilter)
+	}
+}
+
+func TestSyntheticSource(t *testing.T) {
+	source := newTestSyntheticSource(t, 5)
+
+	packets, err := CapturePackets(context.Background(), source, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := 0
+	for p := range packets {
+		want := source.Packets[i]
+		if !p.Timestamp.Equal(want.CaptureInfo.Timestamp) {
+			t.Errorf("❌ packet %v: Timestamp = %v, want %v", i, p.Timestamp, want.CaptureInfo.Timestamp)
+		}
+		if p.CaptureLength != len(want.Data) || p.Length != len(want.Data) {
+			t.Errorf("❌ packet %v: CaptureLength = %v, Length = %v, want %v",
+				i, p.CaptureLength, p.Length, len(want.Data))
+		}
+		if p.PacketType() != "Payload" || len(p.Layers) != 4 {
+			t.Errorf("❌ packet %v: unexpected layers: %v", i, p)
+		}
+		i++
+	}
+	if i != len(source.Packets) {
+		t.Errorf("❌ got %v packets, want %v", i, len(source.Packets))
+	}
+
+	// reopen: a provider can be used for many times
+	packets, err = CapturePackets(context.Background(), source, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := <-packets; p == nil {
+		t.Errorf("❌ reopened source is exhausted")
+	}
+}
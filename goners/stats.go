@@ -0,0 +1,288 @@
+package goners
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// CaptureStats is the statistics of a capturing pipeline:
+//
+//	source -> decoded -> formatted -> outputs
+type CaptureStats struct {
+	Pcap *PcapStats `json:"pcap,omitempty"` // from libpcap: live sources only
+
+	Decoded      int64         `json:"decoded"`       // packets decoded
+	Bytes        int64         `json:"bytes"`         // bytes of decoded packets
+	Paused       int64         `json:"paused"`        // packets dropped while the capturing is paused
+	Formatted    int64         `json:"formatted"`     // packets formatted
+	FormatFailed int64         `json:"format_failed"` // packets dropped failing to be formatted (e.g. by a template)
+	Outputs      []OutputStats `json:"outputs"`
+}
+
+// PcapStats is a view to pcap.Stats.
+type PcapStats struct {
+	Received           int `json:"received"`             // packets received by filter
+	DroppedByKernel    int `json:"dropped_by_kernel"`    // packets dropped by kernel (buffer full)
+	DroppedByInterface int `json:"dropped_by_interface"` // packets dropped by the network interface or its driver
+}
+
+// OutputStats is the statistics of an output.
+type OutputStats struct {
+	Name    string `json:"name"`
+	Written int64  `json:"written"`
+	Dropped int64  `json:"dropped"`
+}
+
+// StatsOutputer is implemented by Outputers and PacketsOutputers
+// that report their OutputStats.
+type StatsOutputer interface {
+	OutputStats() OutputStats
+}
+
+// FailingFormater is implemented by PacketsFormaters that skip
+// the packets they fail to format, e.g. TemplatePacketsFormater.
+type FailingFormater interface {
+	Failed() int64
+}
+
+// pcapStatser is implemented by *pcap.Handle.
+type pcapStatser interface {
+	Stats() (*pcap.Stats, error)
+}
+
+// String is a summary like the one tcpdump prints on exit.
+func (s CaptureStats) String() string {
+	var sb strings.Builder
+	if s.Pcap != nil {
+		sb.WriteString(fmt.Sprintf("%v packets received by filter\n", s.Pcap.Received))
+		sb.WriteString(fmt.Sprintf("%v packets dropped by kernel\n", s.Pcap.DroppedByKernel))
+		sb.WriteString(fmt.Sprintf("%v packets dropped by interface\n", s.Pcap.DroppedByInterface))
+	}
+	sb.WriteString(fmt.Sprintf("%v packets (%v bytes) decoded\n", s.Decoded, s.Bytes))
+	if s.Paused > 0 {
+		sb.WriteString(fmt.Sprintf("%v packets dropped while paused\n", s.Paused))
+	}
+	sb.WriteString(fmt.Sprintf("%v packets formatted\n", s.Formatted))
+	if s.FormatFailed > 0 {
+		sb.WriteString(fmt.Sprintf("%v packets dropped failing to format\n", s.FormatFailed))
+	}
+	for _, o := range s.Outputs {
+		sb.WriteString(fmt.Sprintf("%v packets written, %v dropped by output %v\n",
+			o.Written, o.Dropped, o.Name))
+	}
+	return sb.String()
+}
+
+// StatsCollector collects the CaptureStats of a capturing pipeline.
+//
+// Use WatchSource, CountDecoded, CountFormatted, AddFormater and AddOutput
+// to plug it into the pipeline.
+type StatsCollector struct {
+	sources []*statsSource
+
+	decoded   atomic.Int64
+	bytes     atomic.Int64
+	paused    atomic.Int64
+	formatted atomic.Int64
+
+	formaters []FailingFormater
+	outputs   []StatsOutputer
+	mu        sync.RWMutex // protects sources, formaters & outputs
+}
+
+func NewStatsCollector() *StatsCollector {
+	return &StatsCollector{}
+}
+
+// WatchSource wraps the source to collect its libpcap stats (if any).
+// The returned PacketSource should be used instead of the source.
+// Stats of all the watched sources are summed up.
+func (c *StatsCollector) WatchSource(source PacketSource) PacketSource {
+	s := &statsSource{PacketSource: source}
+
+	c.mu.Lock()
+	c.sources = append(c.sources, s)
+	c.mu.Unlock()
+
+	return s
+}
+
+// CountDecoded counts the packets & bytes passed through.
+func (c *StatsCollector) CountDecoded(in <-chan *Packet) chan *Packet {
+	out := make(chan *Packet, ChanBufSize)
+	go func() {
+		defer close(out)
+		for p := range in {
+			c.decoded.Add(1)
+			c.bytes.Add(int64(p.CaptureLength))
+			out <- p
+		}
+	}()
+	return out
+}
+
+// DropPaused drops & counts the packets passed through while paused is true.
+func (c *StatsCollector) DropPaused(in <-chan *Packet, paused *atomic.Bool) chan *Packet {
+	out := make(chan *Packet, ChanBufSize)
+	go func() {
+		defer close(out)
+		for p := range in {
+			if paused.Load() {
+				c.paused.Add(1)
+				p.Release()
+				continue
+			}
+			out <- p
+		}
+	}()
+	return out
+}
+
+// CountFormatted counts the formatted data passed through.
+func (c *StatsCollector) CountFormatted(in <-chan []byte) chan []byte {
+	out := make(chan []byte, ChanBufSize)
+	go func() {
+		defer close(out)
+		for data := range in {
+			c.formatted.Add(1)
+			out <- data
+		}
+	}()
+	return out
+}
+
+// AddFormater adds a PacketsFormater to collect stats from,
+// if it is a FailingFormater.
+func (c *StatsCollector) AddFormater(formater PacketsFormater) {
+	f, ok := formater.(FailingFormater)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.formaters = append(c.formaters, f)
+}
+
+// AddOutput adds an Outputer or PacketsOutputer to collect stats from,
+// if it is a StatsOutputer.
+func (c *StatsCollector) AddOutput(output any) {
+	o, ok := output.(StatsOutputer)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.outputs = append(c.outputs, o)
+}
+
+// Stats returns a snapshot of the collected CaptureStats.
+func (c *StatsCollector) Stats() CaptureStats {
+	stats := CaptureStats{
+		Decoded:   c.decoded.Load(),
+		Bytes:     c.bytes.Load(),
+		Paused:    c.paused.Load(),
+		Formatted: c.formatted.Load(),
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, source := range c.sources {
+		s := source.pcapStats()
+		if s == nil {
+			continue
+		}
+		if stats.Pcap == nil {
+			stats.Pcap = &PcapStats{}
+		}
+		stats.Pcap.Received += s.Received
+		stats.Pcap.DroppedByKernel += s.DroppedByKernel
+		stats.Pcap.DroppedByInterface += s.DroppedByInterface
+	}
+
+	for _, f := range c.formaters {
+		stats.FormatFailed += f.Failed()
+	}
+
+	stats.Outputs = make([]OutputStats, 0, len(c.outputs))
+	for _, o := range c.outputs {
+		stats.Outputs = append(stats.Outputs, o.OutputStats())
+	}
+
+	return stats
+}
+
+// statsSource is a PacketSource that keeps the last libpcap stats
+// of the underlying source after it's closed.
+//
+// (*pcap.Handle).Stats() and SetBPFFilter() must not be called after
+// the handle is closed.
+type statsSource struct {
+	PacketSource
+
+	mu     sync.Mutex
+	closed bool
+	last   *PcapStats
+}
+
+// pcapStats returns the libpcap stats, or nil if not available.
+func (s *statsSource) pcapStats() *PcapStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return s.last
+	}
+
+	statser, ok := s.PacketSource.(pcapStatser)
+	if !ok {
+		return nil
+	}
+	stats, err := statser.Stats()
+	if err != nil {
+		return s.last
+	}
+	s.last = &PcapStats{
+		Received:           stats.PacketsReceived,
+		DroppedByKernel:    stats.PacketsDropped,
+		DroppedByInterface: stats.PacketsIfDropped,
+	}
+	return s.last
+}
+
+func (s *statsSource) SetBPFFilter(expr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("packet source closed")
+	}
+	return s.PacketSource.SetBPFFilter(expr)
+}
+
+// ZeroCopyReadPacketData for the fast path, if the underlying source
+// supports it.
+func (s *statsSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if zc, ok := s.PacketSource.(gopacket.ZeroCopyPacketDataSource); ok {
+		return zc.ZeroCopyReadPacketData()
+	}
+	return s.PacketSource.ReadPacketData()
+}
+
+func (s *statsSource) Close() {
+	s.pcapStats() // the last chance
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	s.PacketSource.Close()
+}
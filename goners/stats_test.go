@@ -0,0 +1,85 @@
+package goners
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestStatsCollector(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(".", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	const n = 5
+
+	source, err := OpenPacketSource(newTestSyntheticSource(t, n), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := NewStatsCollector()
+	packets := stats.CountDecoded(CapturePacketsFrom(context.Background(), stats.WatchSource(source)))
+
+	textOutput, err := NewFileOutputer(path.Join(tmpdir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcapOutput, err := NewPcapOutputer(path.Join(tmpdir, "out.pcap"), PcapFormat, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsOutput, _ := NewWebSocketOutputer()
+	stats.AddOutput(textOutput)
+	stats.AddOutput(pcapOutput)
+	stats.AddOutput(wsOutput)
+	stats.AddOutput(make(chanOutputer)) // not a StatsOutputer: ignored
+
+	tee := TeePackets(packets, 3)
+	done := make(chan struct{}, 3)
+	go func() {
+		textOutput.Output(stats.CountFormatted(StringPacketsFormater.FormatPackets(tee[0])))
+		done <- struct{}{}
+	}()
+	go func() {
+		pcapOutput.OutputPackets(tee[1])
+		done <- struct{}{}
+	}()
+	go func() {
+		wsOutput.Output(JsonPacketsFormater.FormatPackets(tee[2]))
+		done <- struct{}{}
+	}()
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+
+	got := stats.Stats()
+	if got.Pcap != nil {
+		t.Errorf("❌ Pcap stats of a synthetic source: %+v, want nil", got.Pcap)
+	}
+	if got.Decoded != n || got.Bytes == 0 || got.Formatted != n {
+		t.Errorf("❌ Stats() = %+v, want %v decoded & formatted", got, n)
+	}
+
+	want := []OutputStats{
+		{Name: "file:" + path.Join(tmpdir, "out.txt"), Written: n},
+		{Name: "pcap:" + path.Join(tmpdir, "out.pcap"), Written: n},
+		{Name: "ws", Dropped: n}, // no client
+	}
+	if len(got.Outputs) != len(want) {
+		t.Fatalf("❌ Stats().Outputs = %+v, want %+v", got.Outputs, want)
+	}
+	for i := range want {
+		if got.Outputs[i] != want[i] {
+			t.Errorf("❌ Stats().Outputs[%v] = %+v, want %+v", i, got.Outputs[i], want[i])
+		}
+	}
+
+	if s := got.String(); !strings.Contains(s, "5 packets formatted") {
+		t.Errorf("❌ String() = %q", s)
+	}
+}
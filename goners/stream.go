@@ -0,0 +1,476 @@
+package goners
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// TCPStream is a bidirectional TCP conversation reassembled from
+// the captured packets, like the "Follow TCP Stream" of Wireshark.
+type TCPStream struct {
+	Index  int    `json:"index"`  // in the order of the first packets, like tcp.stream of Wireshark
+	Client string `json:"client"` // "ip:port" of the side that sent the SYN (or the first packet)
+	Server string `json:"server"` // "ip:port" of the other side
+
+	Start   time.Time `json:"start"` // first packet
+	End     time.Time `json:"end"`   // last packet
+	Packets int       `json:"packets"`
+
+	ClientBytes int64 `json:"client_bytes"` // payload reassembled from the client
+	ServerBytes int64 `json:"server_bytes"` // payload reassembled from the server
+	Missing     int64 `json:"missing"`      // bytes lost (segments not captured)
+
+	Closed    bool `json:"closed"`    // FIN or RST seen
+	Truncated bool `json:"truncated"` // data beyond the DataLimit of the StreamTracker is not kept
+	Evicted   bool `json:"evicted"`   // data dropped (once closed) for the TotalDataLimit of the StreamTracker
+
+	Segments []StreamSegment `json:"segments,omitempty"`
+	kept     int             // bytes of data in the Segments
+}
+
+// StreamSegment is the data sent by one side of a TCPStream,
+// before the other side sends anything.
+type StreamSegment struct {
+	FromServer bool      `json:"from_server"`
+	Timestamp  time.Time `json:"timestamp"` // of the first packet
+	Data       []byte    `json:"data"`
+}
+
+const (
+	// DefaultStreamDataLimit is the bytes of data kept for each TCPStream.
+	DefaultStreamDataLimit = 1 << 20
+	// DefaultStreamTotalDataLimit is the bytes of data kept for all
+	// the TCPStreams of a StreamTracker.
+	DefaultStreamTotalDataLimit = 64 << 20
+	// DefaultStreamLimit is the number of TCPStreams tracked
+	// by a StreamTracker.
+	DefaultStreamLimit = 1 << 14
+)
+
+const (
+	// data waiting for lost (or out-of-order) segments is given up
+	// after streamFlushTimeout (in the time of packets)
+	streamFlushTimeout = 2 * time.Second
+	// connections idle for streamIdleTimeout are closed in the assembler
+	// (the TCPStream keeps growing if they come back)
+	streamIdleTimeout = 2 * time.Minute
+)
+
+var (
+	ErrStreamNotFound   = errors.New("stream not found")
+	ErrStreamsUntracked = errors.New("streams are not tracked (track_streams is off)")
+)
+
+// StreamTracker reassembles the TCPStreams of the packets passed through.
+//
+// Track reassembles them in a pipeline; without one, Add packets and
+// Flush at the end.
+type StreamTracker struct {
+	// DataLimit is the bytes of data kept for each TCPStream.
+	// Beyond that, data are counted but dropped.
+	DataLimit int
+	// TotalDataLimit is the bytes of data kept for all the TCPStreams.
+	// Beyond that, data of the closed streams are Evicted, the oldest
+	// closed first; with no closed streams left, data are dropped.
+	TotalDataLimit int
+	// Limit is the number of TCPStreams tracked.
+	// Beyond that, packets of new ones are counted in Untracked.
+	Limit int
+
+	assembler *tcpassembly.Assembler
+	streams   []*TCPStream
+	active    map[string]*TCPStream // latest streams by streamKey
+	closed    []*TCPStream          // closed streams with data to evict, the oldest first
+	kept      int                   // bytes of data in all the streams
+	untracked int64
+
+	// the stream & direction of the packet being assembled,
+	// for streamFactory.New
+	current    *TCPStream
+	fromServer bool
+
+	lastFlush time.Time
+	mu        sync.Mutex // protects all the above
+}
+
+func NewStreamTracker() *StreamTracker {
+	t := &StreamTracker{
+		DataLimit:      DefaultStreamDataLimit,
+		TotalDataLimit: DefaultStreamTotalDataLimit,
+		Limit:          DefaultStreamLimit,
+		active:         make(map[string]*TCPStream),
+	}
+	t.assembler = tcpassembly.NewAssembler(tcpassembly.NewStreamPool(streamFactory{t}))
+	return t
+}
+
+// Track adds the packets passed through. Streams are flushed
+// when in is closed.
+func (t *StreamTracker) Track(in <-chan *Packet) chan *Packet {
+	return trackPackets(in, t.Add, t.Flush)
+}
+
+// Add adds a packet. Non-TCP packets are ignored.
+func (t *StreamTracker) Add(p *Packet) {
+	netFlow, tcp := tcpOf(p)
+	if tcp == nil {
+		return
+	}
+	src, dst, ok := tcpEndpoints(netFlow, tcp)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := streamKey(src, dst)
+	s, ok := t.active[key]
+	if !ok || (s.Closed && tcp.SYN && !tcp.ACK) { // a new connection
+		if len(t.streams) >= t.Limit {
+			// the rest of the new connection is not the closed one's
+			delete(t.active, key)
+			t.untracked++
+			return
+		}
+		s = &TCPStream{
+			Index:  len(t.streams),
+			Client: src,
+			Server: dst,
+			Start:  p.Timestamp,
+		}
+		if tcp.SYN && tcp.ACK { // SYN lost: we are seeing the server
+			s.Client, s.Server = dst, src
+		}
+		t.streams = append(t.streams, s)
+		t.active[key] = s
+	}
+	s.Packets++
+	s.End = p.Timestamp
+	if (tcp.FIN || tcp.RST) && !s.Closed {
+		s.Closed = true
+		t.closed = append(t.closed, s)
+	}
+
+	t.current, t.fromServer = s, src == s.Server
+	t.assembler.AssembleWithTimestamp(netFlow, tcp, p.Timestamp)
+	t.current = nil
+
+	if p.Timestamp.Sub(t.lastFlush) > time.Second {
+		t.assembler.FlushWithOptions(tcpassembly.FlushOptions{T: p.Timestamp.Add(-streamFlushTimeout)})
+		t.assembler.FlushWithOptions(tcpassembly.FlushOptions{T: p.Timestamp.Add(-streamIdleTimeout), CloseAll: true})
+		t.lastFlush = p.Timestamp
+	}
+}
+
+// Flush gives up waiting for lost segments: all data buffered
+// in the assembler are added to the streams.
+func (t *StreamTracker) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.assembler.FlushAll()
+}
+
+// Untracked returns the number of packets of the streams
+// dropped because of the Limit.
+func (t *StreamTracker) Untracked() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.untracked
+}
+
+// Streams returns all the streams, without their Segments.
+func (t *StreamTracker) Streams() []TCPStream {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	streams := make([]TCPStream, 0, len(t.streams))
+	for _, s := range t.streams {
+		stream := *s
+		stream.Segments = nil
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+// Stream returns the stream of the index.
+func (t *StreamTracker) Stream(index int) (TCPStream, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if index < 0 || index >= len(t.streams) {
+		return TCPStream{}, ErrStreamNotFound
+	}
+	return t.streams[index].clone(), nil
+}
+
+// Lookup returns the latest stream between the two "ip:port" endpoints,
+// in any order.
+func (t *StreamTracker) Lookup(a, b string) (TCPStream, error) {
+	ap, err := netip.ParseAddrPort(a)
+	if err != nil {
+		return TCPStream{}, err
+	}
+	bp, err := netip.ParseAddrPort(b)
+	if err != nil {
+		return TCPStream{}, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.active[streamKey(ap.String(), bp.String())]
+	if !ok {
+		return TCPStream{}, ErrStreamNotFound
+	}
+	return s.clone(), nil
+}
+
+// clone copies the stream with its Segments,
+// which keep growing in the tracker.
+func (s *TCPStream) clone() TCPStream {
+	stream := *s
+	stream.Segments = append([]StreamSegment(nil), s.Segments...)
+	return stream
+}
+
+// addData appends the data sent at ts to the stream,
+// within the DataLimit & TotalDataLimit.
+func (t *StreamTracker) addData(s *TCPStream, data []byte, fromServer bool, ts time.Time) {
+	if len(data) > 0 {
+		t.evict(len(data))
+	}
+	limit := t.DataLimit - s.kept
+	if total := t.TotalDataLimit - t.kept; total < limit {
+		limit = total
+	}
+	if s.Evicted || limit < 0 {
+		limit = 0
+	}
+	t.kept += s.add(data, fromServer, ts, limit)
+}
+
+// evict drops the data of the oldest closed streams,
+// until there is room for n more bytes in the TotalDataLimit.
+func (t *StreamTracker) evict(n int) {
+	for len(t.closed) > 0 && t.kept+n > t.TotalDataLimit {
+		s := t.closed[0]
+		t.closed[0] = nil
+		t.closed = t.closed[1:]
+
+		t.kept -= s.kept
+		s.Segments, s.kept = nil, 0
+		s.Evicted = true
+	}
+}
+
+// add appends the data sent at ts to the stream, with at most limit bytes
+// kept. It returns the number of bytes kept.
+func (s *TCPStream) add(data []byte, fromServer bool, ts time.Time, limit int) int {
+	if fromServer {
+		s.ServerBytes += int64(len(data))
+	} else {
+		s.ClientBytes += int64(len(data))
+	}
+
+	if len(data) > limit {
+		s.Truncated = true
+		data = data[:limit]
+	}
+	if len(data) == 0 {
+		return 0
+	}
+	s.kept += len(data)
+
+	if n := len(s.Segments); n > 0 && s.Segments[n-1].FromServer == fromServer {
+		s.Segments[n-1].Data = append(s.Segments[n-1].Data, data...)
+		return len(data)
+	}
+	s.Segments = append(s.Segments, StreamSegment{
+		FromServer: fromServer,
+		Timestamp:  ts,
+		Data:       append([]byte(nil), data...),
+	})
+	return len(data)
+}
+
+// tcpOf returns the network flow & the TCP layer of the packet (if any).
+func tcpOf(p *Packet) (netFlow gopacket.Flow, tcp *layers.TCP) {
+	for _, l := range p.Layers {
+		switch layer := l.layer.(type) {
+		case *layers.TCP:
+			return netFlow, layer
+		case gopacket.NetworkLayer:
+			netFlow = layer.NetworkFlow()
+		}
+	}
+	return netFlow, nil
+}
+
+// tcpEndpoints returns the "ip:port" of the source & destination.
+func tcpEndpoints(netFlow gopacket.Flow, tcp *layers.TCP) (src, dst string, ok bool) {
+	srcIP, ok1 := netip.AddrFromSlice(netFlow.Src().Raw())
+	dstIP, ok2 := netip.AddrFromSlice(netFlow.Dst().Raw())
+	if !ok1 || !ok2 {
+		return "", "", false
+	}
+	src = netip.AddrPortFrom(srcIP, uint16(tcp.SrcPort)).String()
+	dst = netip.AddrPortFrom(dstIP, uint16(tcp.DstPort)).String()
+	return src, dst, true
+}
+
+// streamKey is the same for both directions.
+func streamKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + " " + b
+}
+
+// streamFactory creates tcpassembly.Streams of the current TCPStream
+// of the StreamTracker.
+type streamFactory struct {
+	t *StreamTracker
+}
+
+func (f streamFactory) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	return &halfStream{t: f.t, stream: f.t.current, fromServer: f.t.fromServer}
+}
+
+// halfStream is one direction of a TCPStream.
+// It is called by the assembler, with the StreamTracker locked.
+type halfStream struct {
+	t          *StreamTracker
+	stream     *TCPStream
+	fromServer bool
+}
+
+func (h *halfStream) Reassembled(reassemblies []tcpassembly.Reassembly) {
+	for _, r := range reassemblies {
+		if r.Skip > 0 {
+			h.stream.Missing += int64(r.Skip)
+		}
+		h.t.addData(h.stream, r.Bytes, h.fromServer, r.Seen)
+	}
+}
+
+func (h *halfStream) ReassemblyComplete() {}
+
+// FollowMode is the output of WriteFollow.
+type FollowMode string
+
+const (
+	FollowASCII FollowMode = "ascii" // printable characters, like tshark -z follow,tcp,ascii
+	FollowHex   FollowMode = "hex"   // hex dump, like tshark -z follow,tcp,hex
+	FollowRaw   FollowMode = "raw"   // the data as it is, both directions interleaved
+)
+
+// ParseFollowMode parses "ascii", "hex" or "raw".
+func ParseFollowMode(s string) (FollowMode, error) {
+	switch mode := FollowMode(strings.ToLower(s)); mode {
+	case FollowASCII, FollowHex, FollowRaw:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown follow mode: %q", s)
+}
+
+const followSeparator = "===================================================================\n"
+
+// WriteFollow writes the data of the stream in the mode.
+// Data from the server are indented with a tab (except the raw mode).
+func WriteFollow(w io.Writer, s TCPStream, mode FollowMode) error {
+	if mode == FollowRaw {
+		for _, seg := range s.Segments {
+			if _, err := w.Write(seg.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(followSeparator)
+	fmt.Fprintf(&sb, "Follow: tcp,%v\n", mode)
+	fmt.Fprintf(&sb, "Filter: tcp.stream eq %v\n", s.Index)
+	fmt.Fprintf(&sb, "Node 0: %v\n", s.Client)
+	fmt.Fprintf(&sb, "Node 1: %v\n", s.Server)
+
+	var offsets [2]int // of client & server
+	for _, seg := range s.Segments {
+		indent, dir := "", 0
+		if seg.FromServer {
+			indent, dir = "\t", 1
+		}
+		switch mode {
+		case FollowHex:
+			writeHexDump(&sb, seg.Data, offsets[dir], indent)
+		default:
+			fmt.Fprintf(&sb, "%v%v\n", indent, len(seg.Data))
+			text := strings.TrimSuffix(printableASCII(seg.Data), "\n")
+			for _, line := range strings.Split(text, "\n") {
+				sb.WriteString(indent + line + "\n")
+			}
+		}
+		offsets[dir] += len(seg.Data)
+	}
+	switch {
+	case s.Evicted:
+		sb.WriteString("[evicted]\n")
+	case s.Truncated:
+		sb.WriteString("[truncated]\n")
+	}
+	sb.WriteString(followSeparator)
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// printableASCII replaces non-printable bytes (except newlines) with '.'.
+func printableASCII(data []byte) string {
+	b := make([]byte, len(data))
+	for i, c := range data {
+		if (c >= 0x20 && c < 0x7f) || c == '\n' {
+			b[i] = c
+		} else {
+			b[i] = '.'
+		}
+	}
+	return string(b)
+}
+
+// writeHexDump dumps data like `hexdump -C`, starting at the offset:
+//
+//	00000000  47 45 54 20 2f 20 48 54  54 50 2f 31 2e 31 0d 0a  GET / HTTP/1.1..
+func writeHexDump(sb *strings.Builder, data []byte, offset int, indent string) {
+	for i := 0; i < len(data); i += 16 {
+		end := i + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		line := data[i:end]
+		fmt.Fprintf(sb, "%v%08x  ", indent, offset+i)
+		for j := 0; j < 16; j++ {
+			switch {
+			case j < len(line):
+				fmt.Fprintf(sb, "%02x ", line[j])
+			default:
+				sb.WriteString("   ")
+			}
+			if j == 7 {
+				sb.WriteByte(' ')
+			}
+		}
+		sb.WriteString(" " + strings.ReplaceAll(printableASCII(line), "\n", ".") + "\n")
+	}
+}
@@ -0,0 +1,276 @@
+package goners
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// tcpSegment is a crafted packet between 10.0.0.1:40000 (client)
+// and 10.0.0.2:80 (server).
+type tcpSegment struct {
+	fromServer bool
+	flags      string // "S", "SA", "A", "PA", "FA", "R"
+	seq, ack   uint32
+	payload    string
+}
+
+func (s tcpSegment) craft(t testing.TB) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01},
+		DstMAC:       net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+		SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 80, Seq: s.seq, Ack: s.ack, Window: 65535,
+		SYN: strings.Contains(s.flags, "S"),
+		ACK: strings.Contains(s.flags, "A"),
+		PSH: strings.Contains(s.flags, "P"),
+		FIN: strings.Contains(s.flags, "F"),
+		RST: strings.Contains(s.flags, "R"),
+	}
+	if s.fromServer {
+		ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+		tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	return serializeLayers(t, eth, ip, tcp, gopacket.Payload(s.payload))
+}
+
+func tcpSegmentsSource(t testing.TB, segments []tcpSegment) SyntheticSource {
+	source := SyntheticSource{Link: layers.LinkTypeEthernet}
+	start := time.Unix(1678000000, 0)
+	for i, s := range segments {
+		data := s.craft(t)
+		source.Packets = append(source.Packets, SyntheticPacket{
+			Data: data,
+			CaptureInfo: gopacket.CaptureInfo{
+				Timestamp:     start.Add(time.Duration(i) * time.Millisecond),
+				CaptureLength: len(data),
+				Length:        len(data),
+			},
+		})
+	}
+	return source
+}
+
+func TestStreamTracker(t *testing.T) {
+	const request = "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	source := tcpSegmentsSource(t, []tcpSegment{
+		{false, "S", 100, 0, ""},
+		{true, "SA", 500, 101, ""},
+		{false, "A", 101, 501, ""},
+		{false, "PA", 101, 501, request},
+		{true, "PA", 509, 101 + uint32(len(request)), "world\n"}, // out of order
+		{true, "PA", 501, 101 + uint32(len(request)), "hello, \x00"},
+		{false, "FA", 101 + uint32(len(request)), 515, ""},
+		{true, "FA", 515, 102 + uint32(len(request)), ""},
+		// a new connection on the same ports: another stream
+		{false, "S", 9000, 0, ""},
+		{true, "SA", 7000, 9001, ""},
+		{false, "PA", 9001, 7001, "bye"},
+		{false, "R", 9004, 0, ""},
+		// data lost from the server
+		{true, "PA", 7010, 9004, "lost"},
+	})
+
+	for _, capture := range []CaptureFunc{CapturePacketsFrom, CapturePacketsFastFrom} {
+		tracker := NewStreamTracker()
+		for p := range tracker.Track(capture(context.Background(), mustOpen(t, source))) {
+			p.Release()
+		}
+
+		streams := tracker.Streams()
+		if len(streams) != 2 {
+			t.Fatalf("❌ got %v streams, want 2: %+v", len(streams), streams)
+		}
+		if s := streams[0]; s.Client != "10.0.0.1:40000" || s.Server != "10.0.0.2:80" ||
+			s.Packets != 8 || !s.Closed || s.Segments != nil ||
+			s.ClientBytes != int64(len(request)) || s.ServerBytes != 14 {
+			t.Errorf("❌ streams[0] = %+v", s)
+		}
+
+		s, err := tracker.Stream(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []StreamSegment{
+			{FromServer: false, Data: []byte(request)},
+			{FromServer: true, Data: []byte("hello, \x00world\n")},
+		}
+		if len(s.Segments) != len(want) {
+			t.Fatalf("❌ stream 0 segments = %+v, want %+v", s.Segments, want)
+		}
+		for i := range want {
+			if s.Segments[i].FromServer != want[i].FromServer || !bytes.Equal(s.Segments[i].Data, want[i].Data) {
+				t.Errorf("❌ stream 0 segment %v = %+v, want %+v", i, s.Segments[i], want[i])
+			}
+		}
+
+		s, err = tracker.Lookup("10.0.0.2:80", "10.0.0.1:40000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Index != 1 || s.ClientBytes != 3 || s.ServerBytes != 4 || s.Missing != 9 {
+			t.Errorf("❌ Lookup() = %+v, want the stream 1", s)
+		}
+
+		if _, err := tracker.Stream(2); err != ErrStreamNotFound {
+			t.Errorf("❌ Stream(2) error = %v, want %v", err, ErrStreamNotFound)
+		}
+		if _, err := tracker.Lookup("10.0.0.1:1", "10.0.0.2:80"); err != ErrStreamNotFound {
+			t.Errorf("❌ Lookup(unknown) error = %v, want %v", err, ErrStreamNotFound)
+		}
+	}
+}
+
+func TestStreamTracker_dataLimit(t *testing.T) {
+	source := tcpSegmentsSource(t, []tcpSegment{
+		{false, "S", 100, 0, ""},
+		{false, "PA", 101, 0, "hello"},
+		{false, "PA", 106, 0, "world"},
+	})
+	tracker := NewStreamTracker()
+	tracker.DataLimit = 8
+	for p := range tracker.Track(CapturePacketsFrom(context.Background(), mustOpen(t, source))) {
+		p.Release()
+	}
+
+	s, _ := tracker.Stream(0)
+	if !s.Truncated || s.ClientBytes != 10 || len(s.Segments) != 1 || string(s.Segments[0].Data) != "hellowor" {
+		t.Errorf("❌ stream = %+v, want truncated at 8 bytes", s)
+	}
+}
+
+// threeStreams are 3 connections on the same ports, one after another:
+// "first" & "second" are closed, "third" is not.
+var threeStreams = []tcpSegment{
+	{false, "S", 100, 0, ""},
+	{false, "PA", 101, 0, "first"},
+	{false, "R", 106, 0, ""},
+	{false, "S", 200, 0, ""},
+	{false, "PA", 201, 0, "second"},
+	{false, "R", 207, 0, ""},
+	{false, "S", 300, 0, ""},
+	{false, "PA", 301, 0, "third"},
+}
+
+func TestStreamTracker_limit(t *testing.T) {
+	tracker := NewStreamTracker()
+	tracker.Limit = 2
+	for p := range tracker.Track(CapturePacketsFrom(context.Background(), mustOpen(t, tcpSegmentsSource(t, threeStreams)))) {
+		p.Release()
+	}
+
+	if streams := tracker.Streams(); len(streams) != 2 {
+		t.Errorf("❌ got %v streams, want 2: %+v", len(streams), streams)
+	}
+	if got := tracker.Untracked(); got != 2 {
+		t.Errorf("❌ Untracked() = %v, want 2", got)
+	}
+}
+
+func TestStreamTracker_totalDataLimit(t *testing.T) {
+	tests := []struct {
+		name           string
+		totalDataLimit int
+		want           []string // data of the streams
+		evicted        []bool
+		truncated      []bool
+	}{
+		{"enough", 16, []string{"first", "second", "third"}, []bool{false, false, false}, []bool{false, false, false}},
+		{"evict the oldest closed", 12, []string{"", "second", "third"}, []bool{true, false, false}, []bool{false, false, false}},
+		{"evict all the closed", 5, []string{"", "", "third"}, []bool{true, true, false}, []bool{false, true, false}},
+		{"no closed to evict", 3, []string{"", "", "thi"}, []bool{true, true, false}, []bool{true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewStreamTracker()
+			tracker.TotalDataLimit = tt.totalDataLimit
+			for p := range tracker.Track(CapturePacketsFrom(context.Background(), mustOpen(t, tcpSegmentsSource(t, threeStreams)))) {
+				p.Release()
+			}
+
+			for i, want := range tt.want {
+				s, err := tracker.Stream(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var data []byte
+				for _, seg := range s.Segments {
+					data = append(data, seg.Data...)
+				}
+				if string(data) != want || s.Evicted != tt.evicted[i] || s.Truncated != tt.truncated[i] {
+					t.Errorf("❌ stream %v = %+v, want data %q, evicted %v, truncated %v",
+						i, s, want, tt.evicted[i], tt.truncated[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteFollow(t *testing.T) {
+	stream := TCPStream{
+		Index:  3,
+		Client: "10.0.0.1:40000",
+		Server: "10.0.0.2:80",
+		Segments: []StreamSegment{
+			{FromServer: false, Data: []byte("GET / HTTP/1.1\r\n\r\n")},
+			{FromServer: true, Data: []byte("HTTP/1.1 200 OK\r\n\r\nhi")},
+		},
+	}
+
+	tests := []struct {
+		mode FollowMode
+		want string
+	}{
+		{FollowASCII, `===================================================================
+Follow: tcp,ascii
+Filter: tcp.stream eq 3
+Node 0: 10.0.0.1:40000
+Node 1: 10.0.0.2:80
+18
+GET / HTTP/1.1.
+.
+	21
+	HTTP/1.1 200 OK.
+	.
+	hi
+===================================================================
+`},
+		{FollowHex, `===================================================================
+Follow: tcp,hex
+Filter: tcp.stream eq 3
+Node 0: 10.0.0.1:40000
+Node 1: 10.0.0.2:80
+00000000  47 45 54 20 2f 20 48 54  54 50 2f 31 2e 31 0d 0a  GET / HTTP/1.1..
+00000010  0d 0a                                             ..
+	00000000  48 54 54 50 2f 31 2e 31  20 32 30 30 20 4f 4b 0d  HTTP/1.1 200 OK.
+	00000010  0a 0d 0a 68 69                                    ...hi
+===================================================================
+`},
+		{FollowRaw, "GET / HTTP/1.1\r\n\r\nHTTP/1.1 200 OK\r\n\r\nhi"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			var sb strings.Builder
+			if err := WriteFollow(&sb, stream, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			if got := sb.String(); got != tt.want {
+				t.Errorf("❌ WriteFollow() =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseFollowMode("ebcdic"); err == nil {
+		t.Errorf("❌ ParseFollowMode(ebcdic) error = nil")
+	}
+}
@@ -0,0 +1,342 @@
+package goners
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+)
+
+// TcpdumpOptions configures NewTcpdumpPacketsFormater.
+type TcpdumpOptions struct {
+	// Verbose is the level of details, like tcpdump -v (1) and -vv (2):
+	// -v adds the IP header fields and TCP checksums,
+	// -vv adds the link layer header as well.
+	Verbose int
+	// Hex dumps the whole frame (including the link layer header)
+	// in hex after each line, like tcpdump -xx.
+	Hex bool
+}
+
+// TcpdumpPacketsFormater formats recved input packets into one line each,
+// in the style of tcpdump.
+var TcpdumpPacketsFormater = NewTcpdumpPacketsFormater(TcpdumpOptions{})
+
+// NewTcpdumpPacketsFormater formats packets into one line each in the style
+// of tcpdump, e.g.
+//
+//	15:04:05.123456 IP 10.0.0.1.40000 > 10.0.0.2.80: Flags [S], seq 100, win 65535, options [mss 1460], length 0
+//
+// Like tcpdump -S, the TCP seq & ack are absolute.
+func NewTcpdumpPacketsFormater(opts TcpdumpOptions) PacketsFormater {
+	return PacketsFormaterFunc(func(in <-chan *Packet) <-chan []byte {
+		out := make(chan []byte, ChanBufSize)
+		go func() {
+			defer close(out)
+			for p := range in {
+				out <- []byte(p.tcpdump(opts))
+				p.Release()
+			}
+		}()
+		return out
+	})
+}
+
+// tcpdumpLayers are the layers of a packet tcpdump cares about.
+type tcpdumpLayers struct {
+	eth   *layers.Ethernet
+	arp   *layers.ARP
+	ip4   *layers.IPv4
+	ip6   *layers.IPv6
+	tcp   *layers.TCP
+	udp   *layers.UDP
+	icmp4 *layers.ICMPv4
+	icmp6 *layers.ICMPv6
+	echo6 *layers.ICMPv6Echo
+	dns   *layers.DNS
+}
+
+func (p Packet) tcpdumpLayers() tcpdumpLayers {
+	var ls tcpdumpLayers
+	for _, l := range p.Layers {
+		switch l := l.layer.(type) {
+		case *layers.Ethernet:
+			ls.eth = l
+		case *layers.ARP:
+			ls.arp = l
+		case *layers.IPv4:
+			if ls.ip4 == nil && ls.ip6 == nil { // the outer one
+				ls.ip4 = l
+			}
+		case *layers.IPv6:
+			if ls.ip4 == nil && ls.ip6 == nil {
+				ls.ip6 = l
+			}
+		case *layers.TCP:
+			ls.tcp = l
+		case *layers.UDP:
+			ls.udp = l
+		case *layers.ICMPv4:
+			ls.icmp4 = l
+		case *layers.ICMPv6:
+			ls.icmp6 = l
+		case *layers.ICMPv6Echo:
+			ls.echo6 = l
+		case *layers.DNS:
+			ls.dns = l
+		}
+	}
+	return ls
+}
+
+func (p Packet) tcpdump(opts TcpdumpOptions) string {
+	ls := p.tcpdumpLayers()
+
+	var sb strings.Builder
+	sb.WriteString(p.Timestamp.Format("15:04:05.000000"))
+	sb.WriteByte(' ')
+	if opts.Verbose >= 2 && ls.eth != nil {
+		fmt.Fprintf(&sb, "%v > %v, ethertype %v (0x%04x), length %v: ",
+			ls.eth.SrcMAC, ls.eth.DstMAC, ls.eth.EthernetType, uint16(ls.eth.EthernetType), p.Length)
+	}
+
+	switch {
+	case ls.ip4 != nil:
+		ip := ls.ip4
+		sb.WriteString("IP ")
+		if opts.Verbose >= 1 {
+			fmt.Fprintf(&sb, "(tos 0x%x, ttl %v, id %v, offset %v, flags [%v], proto %v (%d), length %v)\n    ",
+				ip.TOS, ip.TTL, ip.Id, int(ip.FragOffset)*8, tcpdumpIPv4Flags(ip.Flags),
+				strings.ToUpper(ip.Protocol.String()), uint8(ip.Protocol), ip.Length)
+		}
+		ls.writeTransport(&sb, ip.SrcIP, ip.DstIP, ip.Protocol, len(ip.Payload), opts)
+	case ls.ip6 != nil:
+		ip := ls.ip6
+		sb.WriteString("IP6 ")
+		if opts.Verbose >= 1 {
+			fmt.Fprintf(&sb, "(flowlabel 0x%05x, hlim %v, next-header %v (%d) payload length: %v) ",
+				ip.FlowLabel, ip.HopLimit, strings.ToUpper(ip.NextHeader.String()), uint8(ip.NextHeader), ip.Length)
+		}
+		ls.writeTransport(&sb, ip.SrcIP, ip.DstIP, ip.NextHeader, len(ip.Payload), opts)
+	case ls.arp != nil:
+		ls.writeARP(&sb)
+	case ls.eth != nil:
+		fmt.Fprintf(&sb, "ethertype %v (0x%04x), length %v",
+			ls.eth.EthernetType, uint16(ls.eth.EthernetType), p.Length)
+	default:
+		fmt.Fprintf(&sb, "%v, length %v", p.Protocol(), p.Length)
+	}
+
+	if opts.Hex {
+		writeTcpdumpHex(&sb, p.Data())
+	}
+	return sb.String()
+}
+
+func tcpdumpIPv4Flags(flags layers.IPv4Flag) string {
+	var fs []string
+	if flags&layers.IPv4EvilBit != 0 {
+		fs = append(fs, "rsvd")
+	}
+	if flags&layers.IPv4DontFragment != 0 {
+		fs = append(fs, "DF")
+	}
+	if flags&layers.IPv4MoreFragments != 0 {
+		fs = append(fs, "+")
+	}
+	if len(fs) == 0 {
+		return "none"
+	}
+	return strings.Join(fs, ", ")
+}
+
+// writeTransport writes "src.port > dst.port: ..." after the IP header.
+// Fragments (without the transport layer decoded) are written with the
+// IP protocol and length only.
+func (ls tcpdumpLayers) writeTransport(sb *strings.Builder, src, dst net.IP,
+	proto layers.IPProtocol, length int, opts TcpdumpOptions,
+) {
+	switch {
+	case ls.tcp != nil:
+		tcp := ls.tcp
+		fmt.Fprintf(sb, "%v.%d > %v.%d: Flags [%v]", src, tcp.SrcPort, dst, tcp.DstPort, tcpdumpTCPFlags(tcp))
+		if opts.Verbose >= 1 {
+			fmt.Fprintf(sb, ", cksum 0x%04x", tcp.Checksum)
+		}
+		if len(tcp.Payload) > 0 {
+			fmt.Fprintf(sb, ", seq %v:%v", tcp.Seq, tcp.Seq+uint32(len(tcp.Payload)))
+		} else {
+			fmt.Fprintf(sb, ", seq %v", tcp.Seq)
+		}
+		if tcp.ACK {
+			fmt.Fprintf(sb, ", ack %v", tcp.Ack)
+		}
+		fmt.Fprintf(sb, ", win %v", tcp.Window)
+		if len(tcp.Options) > 0 {
+			fmt.Fprintf(sb, ", options [%v]", tcpdumpTCPOptions(tcp.Options))
+		}
+		fmt.Fprintf(sb, ", length %v", len(tcp.Payload))
+		if line := httpLine(tcp.Payload); line != "" {
+			fmt.Fprintf(sb, ": HTTP: %v", line)
+		}
+	case ls.udp != nil:
+		udp := ls.udp
+		fmt.Fprintf(sb, "%v.%d > %v.%d: ", src, udp.SrcPort, dst, udp.DstPort)
+		if ls.dns != nil {
+			writeTcpdumpDNS(sb, ls.dns, len(udp.Payload))
+		} else {
+			fmt.Fprintf(sb, "UDP, length %v", len(udp.Payload))
+		}
+	case ls.icmp4 != nil:
+		icmp := ls.icmp4
+		fmt.Fprintf(sb, "%v > %v: ICMP ", src, dst)
+		switch icmp.TypeCode.Type() {
+		case layers.ICMPv4TypeEchoRequest:
+			fmt.Fprintf(sb, "echo request, id %v, seq %v", icmp.Id, icmp.Seq)
+		case layers.ICMPv4TypeEchoReply:
+			fmt.Fprintf(sb, "echo reply, id %v, seq %v", icmp.Id, icmp.Seq)
+		default:
+			sb.WriteString(icmp.TypeCode.String())
+		}
+		fmt.Fprintf(sb, ", length %v", length)
+	case ls.icmp6 != nil:
+		fmt.Fprintf(sb, "%v > %v: ICMP6, ", src, dst)
+		switch t := ls.icmp6.TypeCode.Type(); {
+		case ls.echo6 != nil && t == layers.ICMPv6TypeEchoRequest:
+			fmt.Fprintf(sb, "echo request, id %v, seq %v", ls.echo6.Identifier, ls.echo6.SeqNumber)
+		case ls.echo6 != nil && t == layers.ICMPv6TypeEchoReply:
+			fmt.Fprintf(sb, "echo reply, id %v, seq %v", ls.echo6.Identifier, ls.echo6.SeqNumber)
+		default:
+			sb.WriteString(ls.icmp6.TypeCode.String())
+		}
+		fmt.Fprintf(sb, ", length %v", length)
+	default:
+		fmt.Fprintf(sb, "%v > %v: ip-proto-%d %v", src, dst, uint8(proto), length)
+	}
+}
+
+func (ls tcpdumpLayers) writeARP(sb *strings.Builder) {
+	arp := ls.arp
+	length := len(arp.Contents) + len(arp.Payload) // with the padding
+	switch arp.Operation {
+	case layers.ARPRequest:
+		fmt.Fprintf(sb, "ARP, Request who-has %v tell %v, length %v",
+			net.IP(arp.DstProtAddress), net.IP(arp.SourceProtAddress), length)
+	case layers.ARPReply:
+		fmt.Fprintf(sb, "ARP, Reply %v is-at %v, length %v",
+			net.IP(arp.SourceProtAddress), net.HardwareAddr(arp.SourceHwAddress), length)
+	default:
+		fmt.Fprintf(sb, "ARP, opcode %v, length %v", arp.Operation, length)
+	}
+}
+
+// tcpdumpTCPFlags in the order of tcpdump, "." for ACK.
+func tcpdumpTCPFlags(tcp *layers.TCP) string {
+	var sb strings.Builder
+	for _, f := range []struct {
+		set  bool
+		flag byte
+	}{
+		{tcp.FIN, 'F'}, {tcp.SYN, 'S'}, {tcp.RST, 'R'}, {tcp.PSH, 'P'},
+		{tcp.ACK, '.'}, {tcp.URG, 'U'}, {tcp.ECE, 'E'}, {tcp.CWR, 'W'},
+	} {
+		if f.set {
+			sb.WriteByte(f.flag)
+		}
+	}
+	if sb.Len() == 0 {
+		return "none"
+	}
+	return sb.String()
+}
+
+func tcpdumpTCPOptions(options []layers.TCPOption) string {
+	var opts []string
+	for _, o := range options {
+		data := o.OptionData
+		switch {
+		case o.OptionType == layers.TCPOptionKindNop:
+			opts = append(opts, "nop")
+		case o.OptionType == layers.TCPOptionKindEndList:
+			opts = append(opts, "eol")
+		case o.OptionType == layers.TCPOptionKindMSS && len(data) == 2:
+			opts = append(opts, fmt.Sprintf("mss %v", binary.BigEndian.Uint16(data)))
+		case o.OptionType == layers.TCPOptionKindWindowScale && len(data) == 1:
+			opts = append(opts, fmt.Sprintf("wscale %v", data[0]))
+		case o.OptionType == layers.TCPOptionKindSACKPermitted:
+			opts = append(opts, "sackOK")
+		case o.OptionType == layers.TCPOptionKindTimestamps && len(data) == 8:
+			opts = append(opts, fmt.Sprintf("TS val %v ecr %v",
+				binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:])))
+		case o.OptionType == layers.TCPOptionKindSACK && len(data)%8 == 0:
+			var blocks []string
+			for i := 0; i < len(data); i += 8 {
+				blocks = append(blocks, fmt.Sprintf("{%v:%v}",
+					binary.BigEndian.Uint32(data[i:]), binary.BigEndian.Uint32(data[i+4:])))
+			}
+			opts = append(opts, fmt.Sprintf("sack %v %v", len(blocks), strings.Join(blocks, "")))
+		default:
+			opts = append(opts, fmt.Sprintf("opt-%d", uint8(o.OptionType)))
+		}
+	}
+	return strings.Join(opts, ",")
+}
+
+// writeTcpdumpDNS writes the DNS message like tcpdump, e.g.
+// "42+ A? example.com. (29)" or "42 1/0/0 A 192.0.2.1 (45)".
+func writeTcpdumpDNS(sb *strings.Builder, dns *layers.DNS, length int) {
+	fmt.Fprintf(sb, "%v", dns.ID)
+	if !dns.QR {
+		if dns.RD {
+			sb.WriteByte('+')
+		}
+		for _, q := range dns.Questions {
+			fmt.Fprintf(sb, " %v? %s.", q.Type, q.Name)
+		}
+		fmt.Fprintf(sb, " (%v)", length)
+		return
+	}
+
+	if dns.AA {
+		sb.WriteByte('*')
+	}
+	if dns.ResponseCode != layers.DNSResponseCodeNoErr {
+		fmt.Fprintf(sb, " %v", strings.TrimSpace(dns.ResponseCode.String()))
+	}
+	fmt.Fprintf(sb, " %v/%v/%v", len(dns.Answers), len(dns.Authorities), len(dns.Additionals))
+	var answers []string
+	for _, a := range dns.Answers {
+		switch a.Type {
+		case layers.DNSTypeA, layers.DNSTypeAAAA:
+			answers = append(answers, fmt.Sprintf("%v %v", a.Type, a.IP))
+		case layers.DNSTypeCNAME:
+			answers = append(answers, fmt.Sprintf("%v %s.", a.Type, a.CNAME))
+		case layers.DNSTypePTR:
+			answers = append(answers, fmt.Sprintf("%v %s.", a.Type, a.PTR))
+		default:
+			answers = append(answers, a.Type.String())
+		}
+	}
+	if len(answers) > 0 {
+		sb.WriteString(" " + strings.Join(answers, ", "))
+	}
+	fmt.Fprintf(sb, " (%v)", length)
+}
+
+// writeTcpdumpHex writes the data like tcpdump -xx:
+//
+//	0x0000:  0000 5e00 5302 0000 5e00 5301 0800 4500
+func writeTcpdumpHex(sb *strings.Builder, data []byte) {
+	for off := 0; off < len(data); off += 16 {
+		fmt.Fprintf(sb, "\n\t0x%04x: ", off)
+		for i := off; i < off+16 && i < len(data); i += 2 {
+			if i+1 < len(data) && i+1 < off+16 {
+				fmt.Fprintf(sb, " %02x%02x", data[i], data[i+1])
+			} else {
+				fmt.Fprintf(sb, " %02x", data[i])
+			}
+		}
+	}
+}
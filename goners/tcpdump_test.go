@@ -0,0 +1,91 @@
+package goners
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestPacket_tcpdump(t *testing.T) {
+	syn := tcpSegment{false, "S", 100, 0, ""}.craft(t)
+	ts := time.Date(2023, 3, 5, 15, 4, 5, 123456000, time.Local)
+
+	tests := []struct {
+		name string
+		data []byte
+		opts TcpdumpOptions
+		want string
+	}{
+		{"tcp syn", syn, TcpdumpOptions{},
+			"15:04:05.123456 IP 10.0.0.1.40000 > 10.0.0.2.80: Flags [S], seq 100, win 65535, length 0"},
+		{"tcp data", tcpSegment{true, "PA", 501, 101, "hello"}.craft(t), TcpdumpOptions{},
+			"15:04:05.123456 IP 10.0.0.2.80 > 10.0.0.1.40000: Flags [P.], seq 501:506, ack 101, win 65535, length 5"},
+		{"http", tcpSegment{false, "PA", 101, 501, "GET / HTTP/1.1\r\n\r\n"}.craft(t), TcpdumpOptions{},
+			"15:04:05.123456 IP 10.0.0.1.40000 > 10.0.0.2.80: Flags [P.], seq 101:119, ack 501, win 65535, length 18: HTTP: GET / HTTP/1.1"},
+		{"dns query", craftDNSQueryPacket(t, "example.com"), TcpdumpOptions{},
+			"15:04:05.123456 IP 10.0.0.1.40000 > 10.0.0.53.53: 42+ A? example.com. (29)"},
+		{"dns response", craftDNSResponse(t, false, 2), TcpdumpOptions{},
+			"15:04:05.123456 IP 10.0.0.53.53 > 10.0.0.1.40000: 42 2/0/0 A 192.0.2.0, A 192.0.2.1 (83)"},
+		{"ping", craftICMPPacket(t, 0x1234, 7, "ping"), TcpdumpOptions{},
+			"15:04:05.123456 IP 10.0.0.1 > 10.0.0.2: ICMP echo request, id 4660, seq 7, length 12"},
+		{"arp", craftARPPacket(t, layers.ARPRequest), TcpdumpOptions{},
+			"15:04:05.123456 ARP, Request who-has 10.0.0.1 tell 10.0.0.2, length 46"},
+		{"ipv6 fragment", fragment(t, craftDNSResponse(t, true, 100), true, 1200, 1)[0], TcpdumpOptions{},
+			"15:04:05.123456 IP6 2001:db8::53 > 2001:db8::1: ip-proto-44 1208"},
+		{"-v", syn, TcpdumpOptions{Verbose: 1},
+			"15:04:05.123456 IP (tos 0x0, ttl 64, id 0, offset 0, flags [none], proto TCP (6), length 40)\n" +
+				"    10.0.0.1.40000 > 10.0.0.2.80: Flags [S], cksum 0xfeeb, seq 100, win 65535, length 0"},
+		{"-vv", syn, TcpdumpOptions{Verbose: 2},
+			"15:04:05.123456 00:00:5e:00:53:01 > 00:00:5e:00:53:02, ethertype IPv4 (0x0800), length 60: " +
+				"IP (tos 0x0, ttl 64, id 0, offset 0, flags [none], proto TCP (6), length 40)\n" +
+				"    10.0.0.1.40000 > 10.0.0.2.80: Flags [S], cksum 0xfeeb, seq 100, win 65535, length 0"},
+		{"-x", syn, TcpdumpOptions{Hex: true},
+			"15:04:05.123456 IP 10.0.0.1.40000 > 10.0.0.2.80: Flags [S], seq 100, win 65535, length 0\n" +
+				"\t0x0000:  0000 5e00 5302 0000 5e00 5301 0800 4500\n" +
+				"\t0x0010:  0028 0000 0000 4006 66ce 0a00 0001 0a00\n" +
+				"\t0x0020:  0002 9c40 0050 0000 0064 0000 0000 5002\n" +
+				"\t0x0030:  ffff feeb 0000 0000 0000 0000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet := gopacket.NewPacket(tt.data, layers.LinkTypeEthernet, gopacket.Default)
+			packet.Metadata().Timestamp = ts
+			packet.Metadata().Length = len(tt.data)
+			if got := NewPacket(packet).tcpdump(tt.opts); got != tt.want {
+				t.Errorf("❌ tcpdump() =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPacket_tcpdumpOptions(t *testing.T) {
+	tcp := &layers.TCP{Options: []layers.TCPOption{
+		{OptionType: layers.TCPOptionKindMSS, OptionData: []byte{0x05, 0xb4}},
+		{OptionType: layers.TCPOptionKindSACKPermitted},
+		{OptionType: layers.TCPOptionKindTimestamps, OptionData: []byte{0, 0, 0, 1, 0, 0, 0, 2}},
+		{OptionType: layers.TCPOptionKindNop},
+		{OptionType: layers.TCPOptionKindWindowScale, OptionData: []byte{7}},
+	}}
+	if got, want := tcpdumpTCPOptions(tcp.Options), "mss 1460,sackOK,TS val 1 ecr 2,nop,wscale 7"; got != want {
+		t.Errorf("❌ tcpdumpTCPOptions() = %v, want %v", got, want)
+	}
+}
+
+func TestTcpdumpPacketsFormater(t *testing.T) {
+	source := newTestSyntheticSource(t, 3)
+	var n int
+	packets := CapturePacketsFastFrom(context.Background(), mustOpen(t, source))
+	for line := range TcpdumpPacketsFormater.FormatPackets(packets) {
+		n++
+		if !strings.Contains(string(line), "IP 10.0.0.1.40000 > 10.0.0.2.443: Flags [P.]") || strings.Contains(string(line), "\n") {
+			t.Errorf("❌ line %v = %s", n, line)
+		}
+	}
+	if n != 3 {
+		t.Errorf("❌ got %v lines, want 3", n)
+	}
+}
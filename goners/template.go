@@ -0,0 +1,209 @@
+package goners
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// templateFuncs are the functions for the packet templates,
+// in addition to the builtins of text/template.
+var templateFuncs = template.FuncMap{
+	// flow returns "src -> dst" of the packet (see Packet.Flow).
+	"flow": func(p templatePacket) string {
+		src, dst := p.p.Flow()
+		return src + " -> " + dst
+	},
+	"src": func(p templatePacket) string {
+		src, _ := p.p.Flow()
+		return src
+	},
+	"dst": func(p templatePacket) string {
+		_, dst := p.p.Flow()
+		return dst
+	},
+	// layer returns the first layer of the type (e.g. "TCP" or "tcp"),
+	// or nil if there's none.
+	"layer": func(typ string, p templatePacket) *Layer {
+		for i, l := range p.Layers {
+			if strings.EqualFold(l.LayerType, typ) || l.Abbr() == typ {
+				return &p.Layers[i]
+			}
+		}
+		return nil
+	},
+	// field returns the first value of the field (a field path, e.g.
+	// "tcp.dst_port", or a display filter field, e.g. "ip.src"),
+	// or "" if it's absent.
+	"field": func(name string, p templatePacket) any {
+		values := dfField{name: name}.values(p.p)
+		if len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	},
+	// fields returns all the values of the field, e.g. "tcp.port".
+	"fields": func(name string, p templatePacket) []any {
+		return dfField{name: name}.values(p.p)
+	},
+	// hex encodes the bytes (or string) in hex.
+	"hex": func(v any) (string, error) {
+		switch v := v.(type) {
+		case []byte:
+			return hex.EncodeToString(v), nil
+		case string:
+			return hex.EncodeToString([]byte(v)), nil
+		}
+		return "", fmt.Errorf("hex: unexpected %T", v)
+	},
+	// time formats the time with the layout of package time,
+	// e.g. "15:04:05.000000" or "2006-01-02T15:04:05Z07:00".
+	"time": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	// epoch returns the seconds since the Unix epoch.
+	"epoch": func(t time.Time) float64 {
+		return float64(t.UnixNano()) / 1e9
+	},
+	// json marshals the value, e.g. a field value for a JSON log line.
+	"json": func(v any) (string, error) {
+		j, err := json.Marshal(v)
+		return string(j), err
+	},
+}
+
+// templatePacket is the read-only view of a Packet that templates are
+// executed with: the data fields and the methods without side effects.
+// Templates may come from HTTP clients: they must not call the methods
+// like Packet.Release.
+type templatePacket struct {
+	DeviceIndex   int
+	Device        string
+	Timestamp     time.Time
+	Length        int
+	CaptureLength int
+	Reassembly    *IPReassembly
+	Layers        []Layer
+
+	p *Packet
+}
+
+func newTemplatePacket(p *Packet) templatePacket {
+	t := templatePacket{
+		DeviceIndex:   p.DeviceIndex,
+		Device:        p.Device,
+		Timestamp:     p.Timestamp,
+		Length:        p.Length,
+		CaptureLength: p.CaptureLength,
+		Layers:        p.Layers,
+		p:             p,
+	}
+	if p.Reassembly != nil {
+		r := *p.Reassembly
+		t.Reassembly = &r
+	}
+	return t
+}
+
+func (t templatePacket) Data() []byte       { return t.p.Data() }
+func (t templatePacket) Protocol() string   { return t.p.Protocol() }
+func (t templatePacket) Info() string       { return t.p.Info() }
+func (t templatePacket) PacketType() string { return t.p.PacketType() }
+func (t templatePacket) String() string     { return t.p.String() }
+
+// ParsePacketTemplate parses the text into a packet template with the
+// helper functions, e.g. to validate it (see NewTemplatePacketsFormater).
+func ParsePacketTemplate(text string) (*template.Template, error) {
+	return template.New("packet").Funcs(templateFuncs).Parse(text)
+}
+
+// NewTemplatePacketsFormater formats each packet by executing the
+// text/template, e.g.
+//
+//	{{.Timestamp | time "15:04:05"}} {{flow .}} {{.Protocol}} {{field "tcp.dstport" .}} {{.Info}}
+//
+// The template gets a read-only view of the Packet: its fields
+// (Timestamp, Length, Layers, ...) and the methods Data, Protocol, Info,
+// PacketType and String. The functions are:
+//
+//	flow, src, dst: the Packet.Flow
+//	layer TYPE: the first Layer of the type ("TCP" or "tcp"), or nil
+//	field NAME, fields NAME: the first (or all) values of a field,
+//	    like the display filter (e.g. "tcp.dst_port", "ip.src")
+//	hex: the bytes (e.g. .Data) in hex
+//	time LAYOUT, epoch: format the time (e.g. .Timestamp)
+//	json: the value in JSON
+//
+// A trailing newline of the output is trimmed: outputs add their own.
+//
+// Packets failing the template (e.g. {{(layer "tcp" .).Src}} on a UDP
+// packet) are skipped and counted, see Failed & Err.
+func NewTemplatePacketsFormater(text string) (*TemplatePacketsFormater, error) {
+	tmpl, err := ParsePacketTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplatePacketsFormater{tmpl: tmpl}, nil
+}
+
+// TemplatePacketsFormater is the PacketsFormater of a template,
+// see NewTemplatePacketsFormater.
+type TemplatePacketsFormater struct {
+	tmpl *template.Template
+
+	failed atomic.Int64
+	mu     sync.Mutex
+	err    error // the first failed execution
+}
+
+func (f *TemplatePacketsFormater) FormatPackets(in <-chan *Packet) <-chan []byte {
+	out := make(chan []byte, ChanBufSize)
+	go func() {
+		defer close(out)
+		var sb strings.Builder
+		for p := range in {
+			sb.Reset()
+			err := f.tmpl.Execute(&sb, newTemplatePacket(p))
+			p.Release()
+			if err != nil {
+				f.fail(err)
+				continue
+			}
+			out <- []byte(strings.TrimSuffix(sb.String(), "\n"))
+		}
+	}()
+	return out
+}
+
+// fail counts a packet failing the template. Only the first error is
+// logged: the others are likely the same.
+func (f *TemplatePacketsFormater) fail(err error) {
+	f.failed.Add(1)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err == nil {
+		slog.Error("TemplatePacketsFormater: execute template failed. Skip the packet.", "err", err)
+		f.err = err
+	}
+}
+
+// Failed returns the number of packets skipped failing the template.
+func (f *TemplatePacketsFormater) Failed() int64 {
+	return f.failed.Load()
+}
+
+// Err returns the error of the first packet failing the template, if any.
+func (f *TemplatePacketsFormater) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
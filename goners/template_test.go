@@ -0,0 +1,124 @@
+package goners
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestNewTemplatePacketsFormater(t *testing.T) {
+	data := craftDNSQueryPacket(t, "example.com")
+	source := SyntheticSource{Link: layers.LinkTypeEthernet, Packets: []SyntheticPacket{{
+		Data: data,
+		CaptureInfo: gopacket.CaptureInfo{
+			Timestamp:     time.Date(2023, 3, 5, 15, 4, 5, 0, time.UTC),
+			CaptureLength: len(data),
+			Length:        len(data),
+		},
+	}}}
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"fields & methods", `{{.Length}} {{.Protocol}} {{.Info}}`,
+			"71 DNS Standard query 0x002a A example.com"},
+		{"flow", `{{flow .}} | {{src .}} | {{dst .}}`,
+			"10.0.0.1:40000 -> 10.0.0.53:53 | 10.0.0.1:40000 | 10.0.0.53:53"},
+		{"layer", `{{with layer "udp" .}}{{.Src}}>{{.Dst}}{{end}}{{with layer "TCP" .}}tcp{{end}}`,
+			"40000>53"},
+		{"field", `{{field "ip.src" .}} {{field "dns.qry.name" .}} {{field "udp.dst_port" .}} [{{field "tcp.port" .}}]`,
+			"10.0.0.1 example.com 53 []"},
+		{"fields", `{{range fields "udp.port" .}}{{.}};{{end}}`,
+			"40000;53;"},
+		{"hex", `{{(layer "udp" .).Payload | hex | printf "%.8s"}} {{.Data | hex | printf "%.4s"}}`,
+			"002a0100 0000"},
+		{"time", `{{.Timestamp | time "2006-01-02T15:04:05Z07:00"}} {{.Timestamp | epoch}}`,
+			"2023-03-05T15:04:05Z 1.678028645e+09"},
+		{"json", `{"name": {{field "dns.qry.name" . | json}}, "len": {{json .Length}}}` + "\n",
+			`{"name": "example.com", "len": 71}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formater, err := NewTemplatePacketsFormater(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var lines []string
+			packets := CapturePacketsFrom(context.Background(), mustOpen(t, source))
+			for line := range formater.FormatPackets(packets) {
+				lines = append(lines, string(line))
+			}
+			if got := strings.Join(lines, "\n"); got != tt.want {
+				t.Errorf("❌ got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// read-only: no Release (of a fast path packet back into the pool)
+	for _, text := range []string{`{{.Release}}`, `{{.p.Release}}`, `{{(layer "udp" .).Payload | len}}{{.Release}}`} {
+		formater, err := NewTemplatePacketsFormater(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources := []PacketSource{mustOpen(t, source), mustOpen(t, source), mustOpen(t, source)}
+		packets := CapturePacketsFromAll(context.Background(), sources, nil, CapturePacketsFastFrom)
+		n := 0
+		for range formater.FormatPackets(packets) {
+			n++
+		}
+		if n != 0 || formater.Err() == nil || formater.Failed() != 3 {
+			t.Errorf("❌ %v: got %v outputs, %v failed, err = %v, want all the 3 packets failed",
+				text, n, formater.Failed(), formater.Err())
+		}
+	}
+
+	if _, err := NewTemplatePacketsFormater("{{flow ."); err == nil {
+		t.Errorf("❌ bad template: error = nil")
+	}
+	if _, err := NewTemplatePacketsFormater("{{nosuchfunc .}}"); err == nil {
+		t.Errorf("❌ unknown function: error = nil")
+	}
+}
+
+func TestTemplatePacketsFormater_skip(t *testing.T) {
+	source := SyntheticSource{Link: layers.LinkTypeEthernet}
+	for _, data := range [][]byte{
+		craftTCPPacket(t, 40000, 80, nil),
+		craftDNSQueryPacket(t, "example.com"), // no tcp layer
+		craftTCPPacket(t, 40001, 443, nil),
+	} {
+		source.Packets = append(source.Packets, SyntheticPacket{
+			Data:        data,
+			CaptureInfo: gopacket.CaptureInfo{CaptureLength: len(data), Length: len(data)},
+		})
+	}
+
+	formater, err := NewTemplatePacketsFormater(`{{(layer "tcp" .).Fields.dst_port}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := NewStatsCollector()
+	stats.AddFormater(formater)
+
+	var lines []string
+	packets := CapturePacketsFrom(context.Background(), mustOpen(t, source))
+	for line := range stats.CountFormatted(formater.FormatPackets(packets)) {
+		lines = append(lines, string(line))
+	}
+
+	if got := strings.Join(lines, ","); got != "80,443" {
+		t.Errorf("❌ got %q, want %q", got, "80,443")
+	}
+	if formater.Err() == nil {
+		t.Errorf("❌ Err() = nil, want the error of the UDP packet")
+	}
+	if got := stats.Stats(); got.Formatted != 2 || got.FormatFailed != 1 {
+		t.Errorf("❌ stats = %+v, want 2 formatted & 1 failed", got)
+	}
+}
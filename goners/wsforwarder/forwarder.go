@@ -22,17 +22,21 @@ const BufferSize = 8
 type Forwarder interface {
 	ForwardMessageTo(ws *websocket.Conn)
 	ForwardMessageFrom(msgCh <-chan []byte)
+	Clients() int
 }
 
 // messageForwarder forwards messages to connected clients, that are, Live2DViews.
 type messageForwarder struct {
 	msgChans []chan []byte
 	mu       sync.RWMutex // to protect msgChans
+
+	done chan struct{} // closed after ForwardMessageFrom returns
 }
 
 func NewMessageForwarder() Forwarder {
 	return &messageForwarder{
 		msgChans: []chan []byte{},
+		done:     make(chan struct{}),
 	}
 }
 
@@ -40,7 +44,8 @@ func NewMessageForwarder() Forwarder {
 //
 // Use SendMessage to send messages.
 //
-// Block until the websocket connection is closed.
+// Block until the websocket connection is closed,
+// or the messages source (of ForwardMessageFrom) is exhausted.
 func (f *messageForwarder) ForwardMessageTo(ws *websocket.Conn) {
 	ch := make(chan []byte, BufferSize)
 
@@ -56,7 +61,7 @@ func (f *messageForwarder) ForwardMessageTo(ws *websocket.Conn) {
 
 	// forward
 
-	forwardMessage(ch, ws) // 阻塞
+	forwardMessage(ch, ws, f.done) // 阻塞
 
 	// clean up
 
@@ -74,6 +79,14 @@ func (f *messageForwarder) ForwardMessageTo(ws *websocket.Conn) {
 	logger.Info("Stop ForwardMessageTo: %s by chan %v.", ws.RemoteAddr(), ch)
 }
 
+// Clients returns the number of connected WebSocket clients.
+func (f *messageForwarder) Clients() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return len(f.msgChans)
+}
+
 // SendMessage to WebSocket clients.
 //
 // Block until message is sent to all clients.
@@ -93,10 +106,12 @@ func (f *messageForwarder) SendMessage(msg []byte) {
 // ForwardMessageFrom the message channel.
 //
 // Block until the message channel is closed.
+// Then all the WebSocket connections are closed.
 func (f *messageForwarder) ForwardMessageFrom(msgCh <-chan []byte) {
 	for msg := range msgCh {
 		f.SendMessage(msg)
 	}
+	close(f.done)
 }
 
 // forwardMessage forwards messages from the message channel to the websocket
@@ -106,16 +121,41 @@ func (f *messageForwarder) ForwardMessageFrom(msgCh <-chan []byte) {
 //
 //	`{"motion": "shake"}`
 //	`{"expression": "f03"}`
-func forwardMessage(msgCh <-chan []byte, ws *websocket.Conn) {
-	for msg := range msgCh {
+//
+// After done is closed, the messages left in msgCh are forwarded,
+// and then the connection is closed.
+func forwardMessage(msgCh <-chan []byte, ws *websocket.Conn, done <-chan struct{}) {
+	defer ws.Close()
+
+	write := func(msg []byte) bool {
 		logger.Info(fmt.Sprintf("fwd msg: %s -> %s (chan %v).", string(msg), ws.RemoteAddr(), msgCh))
 		_, err := ws.Write(msg)
 		if err != nil {
 			logger.Info(fmt.Sprintf("fwd msg to %s (chan %v) error: %s.", ws.RemoteAddr(), msgCh, err))
-			break
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case msg, ok := <-msgCh:
+			if !ok || !write(msg) {
+				return
+			}
+		case <-done:
+			for {
+				select {
+				case msg := <-msgCh:
+					if !write(msg) {
+						return
+					}
+				default:
+					return
+				}
+			}
 		}
 	}
-	_ = ws.Close()
 }
 
 // region useful ForwardMessageFrom* methods
@@ -25,6 +25,8 @@ export interface Packet {
   src: string;
   dst: string;
   packet_type: string;
+  protocol: string;
+  info: string;
 }
 
 export interface Layer {